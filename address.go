@@ -0,0 +1,518 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Areas of the PLC memory, as used by S7Address and S7DataItem
+const (
+	S7AreaPE = s7areape // process inputs (E/I)
+	S7AreaPA = s7areapa // process outputs (A/Q)
+	S7AreaMK = s7areamk // merkers (M)
+	S7AreaDB = s7areadb // data blocks (DB)
+	S7AreaCT = s7areact // counters (Z/C)
+	S7AreaTM = s7areatm // timers (T)
+)
+
+// Word lengths of an item, as used by S7Address and S7DataItem
+const (
+	S7WLBit     = s7wlbit
+	S7WLByte    = s7wlbyte
+	S7WLChar    = s7wlChar
+	S7WLWord    = s7wlword
+	S7WLInt     = s7wlint
+	S7WLDWord   = s7wldword
+	S7WLDInt    = s7wldint
+	S7WLReal    = s7wlreal
+	S7WLCounter = s7wlcounter
+	S7WLTimer   = s7wltimer
+)
+
+const (
+	maxAddressOffset    = 65535
+	maxAddressDBNumber  = 65535
+	maxAddressStringLen = 254
+)
+
+// S7Address is a parsed S7 variable address such as "DB1.DBX2.3", "MW10", "%I0.1" or "DB5.DBSTRING10.20".
+type S7Address struct {
+	Area     int // S7AreaPE, S7AreaPA, S7AreaMK, S7AreaDB, S7AreaCT or S7AreaTM
+	DBNumber int // number of the data block, 0 for the other areas
+	Start    int // byte offset, or the number of the first timer/counter
+	Bit      int // bit in the byte at Start (0..7) for S7WLBit
+	WordLen  int // S7WLBit, S7WLByte, S7WLWord, S7WLDWord, S7WLTimer or S7WLCounter
+	Amount   int // number of elements, greater than 1 for arrays
+	Length   int // maximum number of characters of a STRING, 0 for the other types
+}
+
+// AddressError reports a malformed S7 address and the column (starting at 1) of the problem.
+type AddressError struct {
+	Address string
+	Column  int
+	Reason  string
+}
+
+func (e *AddressError) Error() string {
+	return fmt.Sprintf("s7: invalid address %q at column %d: %s", e.Address, e.Column, e.Reason)
+}
+
+// IsString tells whether the address is a STRING (DBSTRING) variable
+func (a S7Address) IsString() bool {
+	return a.Length > 0
+}
+
+// Size returns the number of bytes spanned by the address in the PLC memory
+func (a S7Address) Size() int {
+	switch {
+	case a.IsString():
+		return (a.Length + 2) * a.Amount
+	case a.WordLen == s7wlbit:
+		return (a.Bit + a.Amount + 7) / 8
+	default:
+		return dataSizeByte(a.WordLen) * a.Amount
+	}
+}
+
+// String returns the address in German mnemonics, DB addresses in STEP 7 syntax
+func (a S7Address) String() string {
+	var s string
+	switch a.Area {
+	case s7areatm:
+		s = "T" + strconv.Itoa(a.Start)
+	case s7areact:
+		s = "Z" + strconv.Itoa(a.Start)
+	case s7areadb:
+		s = "DB" + strconv.Itoa(a.DBNumber) + ".DB"
+		if a.IsString() {
+			s += "STRING" + strconv.Itoa(a.Start) + "." + strconv.Itoa(a.Length)
+		} else {
+			s += addressSizeLetter(a.WordLen, "X") + addressOffset(a)
+		}
+	default:
+		letter := map[int]string{s7areape: "E", s7areapa: "A", s7areamk: "M"}[a.Area]
+		s = letter + addressSizeLetter(a.WordLen, "") + addressOffset(a)
+	}
+	if a.Amount > 1 {
+		s += "[" + strconv.Itoa(a.Amount) + "]"
+	}
+	return s
+}
+
+func addressSizeLetter(wordLen int, bit string) string {
+	switch wordLen {
+	case s7wlbit:
+		return bit
+	case s7wlword:
+		return "W"
+	case s7wldword:
+		return "D"
+	default:
+		return "B"
+	}
+}
+
+func addressOffset(a S7Address) string {
+	if a.WordLen == s7wlbit {
+		return strconv.Itoa(a.Start) + "." + strconv.Itoa(a.Bit)
+	}
+	return strconv.Itoa(a.Start)
+}
+
+// ParseAddress parses an S7 address in German (E, A, M, T, Z) or international (I, Q, M, T, C) mnemonics,
+// optionally in TIA Portal syntax with a leading '%'. Examples:
+//
+//	EB0, IW2, AD4, Q1.7, MB10, M0.3, T5, Z3, C3
+//	DB1.DBX2.3, DB1.DBB4, DB1.DBW6, DB1.DBD8, DB5.DBSTRING10.20
+//	%MW10, %DB1.DBX2.3
+//
+// A suffix "[n]" makes the address an array of n elements, e.g. DB1.DBW10[4].
+// Parsing errors are returned as *AddressError.
+func ParseAddress(address string) (S7Address, error) {
+	p := addressParser{input: address}
+	return p.parse()
+}
+
+// addressParser is a scanner over the address, spaces are ignored
+type addressParser struct {
+	input string
+	pos   int
+}
+
+func (p *addressParser) fail(column int, format string, v ...interface{}) error {
+	return &AddressError{Address: p.input, Column: column + 1, Reason: fmt.Sprintf(format, v...)}
+}
+
+func (p *addressParser) skipSpaces() {
+	for p.pos < len(p.input) && p.input[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// peek returns the next character in upper case, 0 at the end of the input
+func (p *addressParser) peek() byte {
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	c := p.input[p.pos]
+	if c >= 'a' && c <= 'z' {
+		c -= 'a' - 'A'
+	}
+	return c
+}
+
+// accept consumes the keyword if the input continues with it
+func (p *addressParser) accept(keyword string) bool {
+	p.skipSpaces()
+	if len(p.input)-p.pos >= len(keyword) && strings.EqualFold(p.input[p.pos:p.pos+len(keyword)], keyword) {
+		p.pos += len(keyword)
+		return true
+	}
+	return false
+}
+
+func (p *addressParser) expect(keyword string) error {
+	if !p.accept(keyword) {
+		return p.fail(p.pos, "expected %q", keyword)
+	}
+	return nil
+}
+
+// number reads a decimal number in the range min..max
+func (p *addressParser) number(what string, min, max int) (int, error) {
+	p.skipSpaces()
+	begin := p.pos
+	for p.pos < len(p.input) && p.input[p.pos] >= '0' && p.input[p.pos] <= '9' {
+		p.pos++
+	}
+	if begin == p.pos {
+		return 0, p.fail(begin, "expected %s", what)
+	}
+	n, err := strconv.Atoi(p.input[begin:p.pos])
+	if err != nil || n < min || n > max {
+		return 0, p.fail(begin, "%s %s out of range %d..%d", what, p.input[begin:p.pos], min, max)
+	}
+	return n, nil
+}
+
+func (p *addressParser) parse() (a S7Address, err error) {
+	a.Amount = 1
+	p.accept("%")
+	if p.peek() == 0 {
+		return a, p.fail(p.pos, "address is empty")
+	}
+	if p.accept("DB") {
+		err = p.parseDB(&a)
+	} else {
+		err = p.parseArea(&a)
+	}
+	if err != nil {
+		return
+	}
+	if p.accept("[") {
+		if a.Amount, err = p.number("array size", 1, maxAddressOffset); err != nil {
+			return
+		}
+		if err = p.expect("]"); err != nil {
+			return
+		}
+	}
+	if p.peek() != 0 {
+		return a, p.fail(p.pos, "unexpected %q", p.input[p.pos:])
+	}
+	return
+}
+
+func (p *addressParser) parseDB(a *S7Address) (err error) {
+	a.Area = s7areadb
+	if a.DBNumber, err = p.number("DB number", 1, maxAddressDBNumber); err != nil {
+		return
+	}
+	if err = p.expect("."); err != nil {
+		return
+	}
+	if err = p.expect("DB"); err != nil {
+		return
+	}
+	column := p.pos
+	switch {
+	case p.accept("STRING"):
+		a.WordLen = s7wlbyte
+		if a.Start, err = p.number("byte offset", 0, maxAddressOffset); err != nil {
+			return
+		}
+		if err = p.expect("."); err != nil {
+			return
+		}
+		a.Length, err = p.number("string length", 1, maxAddressStringLen)
+		return
+	case p.accept("X"):
+		a.WordLen = s7wlbit
+	case p.accept("B"):
+		a.WordLen = s7wlbyte
+	case p.accept("W"):
+		a.WordLen = s7wlword
+	case p.accept("D"):
+		a.WordLen = s7wldword
+	default:
+		return p.fail(column, "expected DBX, DBB, DBW, DBD or DBSTRING")
+	}
+	return p.parseOffset(a)
+}
+
+func (p *addressParser) parseArea(a *S7Address) (err error) {
+	column := p.pos
+	switch p.peek() {
+	case 'E', 'I':
+		a.Area = s7areape
+	case 'A', 'Q':
+		a.Area = s7areapa
+	case 'M':
+		a.Area = s7areamk
+	case 'T':
+		a.Area = s7areatm
+	case 'Z', 'C':
+		a.Area = s7areact
+	default:
+		return p.fail(column, "unknown area %q", p.input[p.pos:p.pos+1])
+	}
+	p.pos++
+	if a.Area == s7areatm || a.Area == s7areact {
+		a.WordLen = map[int]int{s7areatm: s7wltimer, s7areact: s7wlcounter}[a.Area]
+		a.Start, err = p.number("number", 0, maxAddressOffset)
+		return
+	}
+	switch p.peek() {
+	case 'B':
+		a.WordLen = s7wlbyte
+	case 'W':
+		a.WordLen = s7wlword
+	case 'D':
+		a.WordLen = s7wldword
+	case 'X':
+		a.WordLen = s7wlbit
+	default:
+		// no size letter, e.g. E0.1
+		a.WordLen = s7wlbit
+		return p.parseOffset(a)
+	}
+	p.pos++
+	return p.parseOffset(a)
+}
+
+// parseOffset reads the byte offset, followed by the bit for bit addresses
+func (p *addressParser) parseOffset(a *S7Address) (err error) {
+	if a.Start, err = p.number("byte offset", 0, maxAddressOffset); err != nil {
+		return
+	}
+	if a.WordLen != s7wlbit {
+		if p.peek() == '.' {
+			return p.fail(p.pos, "bit access is only allowed for bit addresses")
+		}
+		return
+	}
+	if err = p.expect("."); err != nil {
+		return
+	}
+	a.Bit, err = p.number("bit", 0, 7)
+	return
+}
+
+// readAddress reads the memory spanned by the address into buffer
+func (mb *client) readAddress(a S7Address, buffer []byte) error {
+	if a.WordLen == s7wltimer || a.WordLen == s7wlcounter {
+		return mb.readArea(a.Area, 0, a.Start, a.Amount, a.WordLen, buffer)
+	}
+	return mb.readArea(a.Area, a.DBNumber, a.Start, a.Size(), s7wlbyte, buffer)
+}
+
+// decode converts the memory spanned by the address into a Go value:
+// bool for bits, byte, uint16 for words, timers and counters, uint32 for double words and string for STRING.
+// Arrays are returned as slices of these types.
+func (a S7Address) decode(buffer []byte) interface{} {
+	var helper Helper
+	switch {
+	case a.IsString():
+		values := make([]string, a.Amount)
+		for i := range values {
+			values[i] = helper.GetStringAt(buffer, i*(a.Length+2))
+		}
+		if a.Amount == 1 {
+			return values[0]
+		}
+		return values
+	case a.WordLen == s7wlbit:
+		values := make([]bool, a.Amount)
+		for i := range values {
+			bit := a.Bit + i
+			values[i] = helper.GetBoolAt(buffer[bit/8], uint(bit%8))
+		}
+		if a.Amount == 1 {
+			return values[0]
+		}
+		return values
+	case a.WordLen == s7wlword || a.WordLen == s7wltimer || a.WordLen == s7wlcounter:
+		values := make([]uint16, a.Amount)
+		for i := range values {
+			values[i] = binary.BigEndian.Uint16(buffer[i*2:])
+		}
+		if a.Amount == 1 {
+			return values[0]
+		}
+		return values
+	case a.WordLen == s7wldword:
+		values := make([]uint32, a.Amount)
+		for i := range values {
+			values[i] = binary.BigEndian.Uint32(buffer[i*4:])
+		}
+		if a.Amount == 1 {
+			return values[0]
+		}
+		return values
+	default:
+		if a.Amount == 1 {
+			return buffer[0]
+		}
+		values := make([]byte, a.Amount)
+		copy(values, buffer)
+		return values
+	}
+}
+
+// encode converts a Go value into the memory spanned by the address, the type of the value has to match
+// the width of the address: bool for bits, byte/int8 for bytes, uint16/int16 for words, timers and counters,
+// uint32/int32/float32 for double words and string for STRING. Arrays take slices of these types,
+// untyped integer constants (int) are accepted if they fit into the width.
+func (a S7Address) encode(value interface{}) ([]byte, error) {
+	buffer := make([]byte, a.Size())
+	values := reflect.ValueOf(value)
+	if a.Amount > 1 {
+		if values.Kind() != reflect.Slice || values.Type().Elem().Kind() == reflect.Interface {
+			return nil, a.typeError(value)
+		}
+		if values.Len() != a.Amount {
+			return nil, fmt.Errorf("s7: cannot write %d elements to %s", values.Len(), a)
+		}
+	} else {
+		values = reflect.ValueOf([]interface{}{value})
+	}
+	for i := 0; i < values.Len(); i++ {
+		if err := a.encodeElement(buffer, i, values.Index(i).Interface()); err != nil {
+			return nil, err
+		}
+	}
+	return buffer, nil
+}
+
+func (a S7Address) encodeElement(buffer []byte, i int, value interface{}) error {
+	var helper Helper
+	switch {
+	case a.IsString():
+		s, ok := value.(string)
+		if !ok {
+			return a.typeError(value)
+		}
+		if len(s) > a.Length {
+			return fmt.Errorf("s7: string of %d characters does not fit into %s", len(s), a)
+		}
+		helper.SetStringAt(buffer, i*(a.Length+2), a.Length, s)
+	case a.WordLen == s7wlbit:
+		b, ok := value.(bool)
+		if m, isByte := value.(byte); isByte && a.Area == s7areadb && a.Amount == 1 && (m == 0 || m == 1<<uint(a.Bit)) {
+			// the masked byte Read returns for a single DB bit
+			b, ok = m != 0, true
+		}
+		if !ok {
+			return a.typeError(value)
+		}
+		bit := a.Bit + i
+		buffer[bit/8] = helper.SetBoolAt(buffer[bit/8], uint(bit%8), b)
+	case a.WordLen == s7wlword || a.WordLen == s7wltimer || a.WordLen == s7wlcounter:
+		var w uint16
+		switch v := value.(type) {
+		case uint16:
+			w = v
+		case int16:
+			w = uint16(v)
+		case int:
+			if v < math.MinInt16 || v > math.MaxUint16 {
+				return a.rangeError(value)
+			}
+			w = uint16(v)
+		default:
+			return a.typeError(value)
+		}
+		binary.BigEndian.PutUint16(buffer[i*2:], w)
+	case a.WordLen == s7wldword:
+		var d uint32
+		switch v := value.(type) {
+		case uint32:
+			d = v
+		case int32:
+			d = uint32(v)
+		case float32:
+			d = math.Float32bits(v)
+		case int:
+			if v < math.MinInt32 || v > math.MaxUint32 {
+				return a.rangeError(value)
+			}
+			d = uint32(v)
+		default:
+			return a.typeError(value)
+		}
+		binary.BigEndian.PutUint32(buffer[i*4:], d)
+	default:
+		switch v := value.(type) {
+		case byte:
+			buffer[i] = v
+		case int8:
+			buffer[i] = byte(v)
+		case int:
+			if v < math.MinInt8 || v > math.MaxUint8 {
+				return a.rangeError(value)
+			}
+			buffer[i] = byte(v)
+		default:
+			return a.typeError(value)
+		}
+	}
+	return nil
+}
+
+func (a S7Address) typeError(value interface{}) error {
+	return fmt.Errorf("s7: cannot write %T to %s", value, a)
+}
+
+func (a S7Address) rangeError(value interface{}) error {
+	return fmt.Errorf("s7: value %v does not fit into %s", value, a)
+}
+
+// writeAddress writes the encoded value of the address. Bits are written one by one with the bit
+// transport size, so that the other bits of the byte are not touched.
+func (mb *client) writeAddress(a S7Address, buffer []byte) (err error) {
+	switch {
+	case a.WordLen == s7wltimer || a.WordLen == s7wlcounter:
+		return mb.writeArea(a.Area, 0, a.Start, a.Amount, a.WordLen, buffer)
+	case a.WordLen == s7wlbit:
+		var helper Helper
+		for i := 0; i < a.Amount && err == nil; i++ {
+			bit := a.Bit + i
+			value := []byte{0}
+			if helper.GetBoolAt(buffer[bit/8], uint(bit%8)) {
+				value[0] = 1
+			}
+			err = mb.writeArea(a.Area, a.DBNumber, (a.Start+bit/8)*8+bit%8, 1, s7wlbit, value)
+		}
+		return
+	default:
+		return mb.writeArea(a.Area, a.DBNumber, a.Start, a.Size(), s7wlbyte, buffer)
+	}
+}
@@ -0,0 +1,79 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"testing"
+)
+
+func TestParseAddress(t *testing.T) {
+	input := []struct {
+		in     string
+		out    S7Address
+		size   int
+		format string
+	}{
+		{"DB1.DBX2.3", S7Address{Area: S7AreaDB, DBNumber: 1, Start: 2, Bit: 3, WordLen: S7WLBit, Amount: 1}, 1, "DB1.DBX2.3"},
+		{"db10.dbb4", S7Address{Area: S7AreaDB, DBNumber: 10, Start: 4, WordLen: S7WLByte, Amount: 1}, 1, "DB10.DBB4"},
+		{"DB1.DBW6[4]", S7Address{Area: S7AreaDB, DBNumber: 1, Start: 6, WordLen: S7WLWord, Amount: 4}, 8, "DB1.DBW6[4]"},
+		{"%DB2.DBD8", S7Address{Area: S7AreaDB, DBNumber: 2, Start: 8, WordLen: S7WLDWord, Amount: 1}, 4, "DB2.DBD8"},
+		{"DB5.DBSTRING10.20", S7Address{Area: S7AreaDB, DBNumber: 5, Start: 10, WordLen: S7WLByte, Amount: 1, Length: 20}, 22, "DB5.DBSTRING10.20"},
+		{"EB0", S7Address{Area: S7AreaPE, Start: 0, WordLen: S7WLByte, Amount: 1}, 1, "EB0"},
+		{"IW2", S7Address{Area: S7AreaPE, Start: 2, WordLen: S7WLWord, Amount: 1}, 2, "EW2"},
+		{"%I0.1", S7Address{Area: S7AreaPE, Start: 0, Bit: 1, WordLen: S7WLBit, Amount: 1}, 1, "E0.1"},
+		{"AD4", S7Address{Area: S7AreaPA, Start: 4, WordLen: S7WLDWord, Amount: 1}, 4, "AD4"},
+		{"Q1.7", S7Address{Area: S7AreaPA, Start: 1, Bit: 7, WordLen: S7WLBit, Amount: 1}, 1, "A1.7"},
+		{"%MW10", S7Address{Area: S7AreaMK, Start: 10, WordLen: S7WLWord, Amount: 1}, 2, "MW10"},
+		{"MX3.6[4]", S7Address{Area: S7AreaMK, Start: 3, Bit: 6, WordLen: S7WLBit, Amount: 4}, 2, "M3.6[4]"},
+		{"T5", S7Address{Area: S7AreaTM, Start: 5, WordLen: S7WLTimer, Amount: 1}, 2, "T5"},
+		{"Z3", S7Address{Area: S7AreaCT, Start: 3, WordLen: S7WLCounter, Amount: 1}, 2, "Z3"},
+		{"C 3", S7Address{Area: S7AreaCT, Start: 3, WordLen: S7WLCounter, Amount: 1}, 2, "Z3"},
+	}
+	for _, i := range input {
+		a, err := ParseAddress(i.in)
+		if err != nil {
+			t.Errorf("%s: %v", i.in, err)
+			continue
+		}
+		if a != i.out {
+			t.Errorf("%s: expected %+v given %+v", i.in, i.out, a)
+		}
+		if a.Size() != i.size {
+			t.Errorf("%s: expected size %d given %d", i.in, i.size, a.Size())
+		}
+		if a.String() != i.format {
+			t.Errorf("%s: expected %s given %s", i.in, i.format, a.String())
+		}
+	}
+}
+
+func TestParseAddressError(t *testing.T) {
+	input := []struct {
+		in     string
+		column int
+	}{
+		{"", 1},
+		{"X10", 1},
+		{"DB1.DBX2.8", 10},
+		{"DB1.DBY2", 7},
+		{"DB0.DBB2", 3},
+		{"MW10.1", 5},
+		{"MB", 3},
+		{"DB1.DBW2[0]", 10},
+		{"DB1.DBW2[2", 11},
+		{"DB5.DBSTRING10.255", 16},
+		{"EB0 EB1", 5},
+	}
+	for _, i := range input {
+		_, err := ParseAddress(i.in)
+		addressError, ok := err.(*AddressError)
+		if !ok {
+			t.Errorf("%s: expected an address error given %v", i.in, err)
+			continue
+		}
+		if addressError.Column != i.column {
+			t.Errorf("%s: expected column %d given %d (%v)", i.in, i.column, addressError.Column, err)
+		}
+	}
+}
@@ -36,13 +36,71 @@ type Client interface {
 	AGWriteCT(start int, size int, buffer []byte) (err error)
 	//multi read area
 	AGReadMulti(dataItems []S7DataItem, itemsCount int) (err error)
-	//multi write area
+	//multi write area, S7WLBit items take the bit address (byte*8 + bit) in Start as a single bit write does
 	AGWriteMulti(dataItems []S7DataItem, itemsCount int) (err error)
+	//read any number of items, split into as many requests as the PDU size needs, item errors are set in S7DataItem.Error
+	ReadItems(items []S7DataItem) (err error)
+	//write any number of items, split into as many requests as the PDU size needs, item errors are set in S7DataItem.Error,
+	//S7WLBit items take the byte in Start and the bit in Bit as in ReadItems
+	WriteItems(items []S7DataItem) (err error)
+	//read many addresses with as few requests as possible, addresses at most maxGap bytes apart are read as one range
+	ReadOptimized(addresses []S7Address, maxGap int) (results []ReadResult, err error)
 	/*block*/
 	DBFill(dbnumber int, fillchar int) error
 	DBGet(dbnumber int, usrdata []byte, size int) error
-	//general read function with S7 sytax
+	//general read function with S7 sytax, e.g. DB1.DBW2, MW10, %I0.1, see ParseAddress
 	Read(variable string, buffer []byte) (value interface{}, err error)
+	//general write function with S7 sytax, the type of value has to match the address
+	Write(variable string, value interface{}) (err error)
+	//set the tag table that ReadTag, WriteTag and ReadTags resolve symbolic names through
+	SetTagTable(table *TagTable)
+	//read a tag of the tag table, typed tags are returned as the Go type of their S7 type, e.g. int16 for INT
+	ReadTag(name string) (value interface{}, err error)
+	//write a tag of the tag table
+	WriteTag(name string, value interface{}) (err error)
+	//read tags of the tag table with multi item reads, values are in the order of the names
+	ReadTags(names ...string) (values []interface{}, err error)
+	/*elementary types, area is one of S7AreaPE, S7AreaPA, S7AreaMK or S7AreaDB, dbNumber is used for S7AreaDB only*/
+	//read a BOOL at start.bit
+	ReadBool(area int, dbNumber int, start int, bit int) (value bool, err error)
+	//write a BOOL at start.bit, the other bits are not changed
+	WriteBool(area int, dbNumber int, start int, bit int, value bool) error
+	//read an INT
+	ReadInt(area int, dbNumber int, start int) (value int16, err error)
+	//write an INT
+	WriteInt(area int, dbNumber int, start int, value int16) error
+	//read a DINT
+	ReadDInt(area int, dbNumber int, start int) (value int32, err error)
+	//write a DINT
+	WriteDInt(area int, dbNumber int, start int, value int32) error
+	//read a WORD
+	ReadWord(area int, dbNumber int, start int) (value uint16, err error)
+	//write a WORD
+	WriteWord(area int, dbNumber int, start int, value uint16) error
+	//read a REAL
+	ReadReal(area int, dbNumber int, start int) (value float32, err error)
+	//write a REAL
+	WriteReal(area int, dbNumber int, start int, value float32) error
+	//read a STRING[maxLen]
+	ReadString(area int, dbNumber int, start int, maxLen int) (value string, err error)
+	//write a STRING[maxLen]
+	WriteString(area int, dbNumber int, start int, maxLen int, value string) error
+	//read a WSTRING[maxLen]
+	ReadWString(area int, dbNumber int, start int, maxLen int) (value string, err error)
+	//write a WSTRING[maxLen]
+	WriteWString(area int, dbNumber int, start int, maxLen int, value string) error
+	//read a DTL
+	ReadDTL(area int, dbNumber int, start int) (value time.Time, err error)
+	//write a DTL
+	WriteDTL(area int, dbNumber int, start int, value time.Time) error
+	//read an S5TIME
+	ReadS5Time(area int, dbNumber int, start int) (value time.Duration, err error)
+	//write an S5TIME
+	WriteS5Time(area int, dbNumber int, start int, value time.Duration) error
+	//read a struct from a data block, see Unmarshal for the mapping of the fields
+	ReadStruct(dbNumber int, v interface{}) error
+	//write a struct to a data block, see Marshal for the mapping of the fields
+	WriteStruct(dbNumber int, v interface{}) error
 	//Get block  infor in AG area, refer an S7BlockInfor pointer
 	GetAgBlockInfo(blocktype int, blocknum int) (info S7BlockInfo, err error)
 	/***************end API AG***************/
@@ -60,6 +118,18 @@ type Client interface {
 	/*directory*/
 	//list all blocks in PLC, return a Blockslist which contains list of OB, DB, ...
 	PGListBlocks() (list S7BlocksList, err error)
+	/*block transfer*/
+	//upload a block (S7BlockOB, S7BlockDB, ...) from the PLC, return the block with header, MC7 code and footer
+	UploadBlock(blockType int, number int) (data []byte, err error)
+	//download a block (header, MC7 code and footer as from UploadBlock) into the PLC, replaces a block of the same type and number
+	DownloadBlock(data []byte) error
+	//delete a block (S7BlockOB, S7BlockDB, ...) from the PLC, refused unless ConfirmDelete is given
+	DeleteBlock(blockType int, number int, options ...DeleteOption) error
+	/*memory*/
+	//compress the work memory, wait at most timeout for the PLC to finish, 0 waits up to 2 minutes
+	Compress(timeout time.Duration) error
+	//copy the work memory into the load memory (S7-300/400), wait at most timeout for the PLC to finish, 0 waits up to 2 minutes
+	CopyRamToRom(timeout time.Duration) error
 	/*security*/
 	//set the session password for PLC to meet its security level
 	SetSessionPassword(password string) error
@@ -75,6 +145,8 @@ type Client interface {
 	GetCPUInfo() (info S7CpuInfo, err error)
 	//get CP info, return S7CpInfo and its properties
 	GetCPInfo() (info S7CpInfo, err error)
+	//read at most max events of the diagnostic buffer (all if max <= 0), the newest first
+	GetDiagnosticBuffer(max int) (events []DiagEvent, err error)
 	/*datetime*/
 	//read clock on PLC, return a time
 	PGClockRead(datetime time.Time) error
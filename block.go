@@ -10,6 +10,35 @@ import (
 	"time"
 )
 
+// Block types of S7BlockInfo.BlkType (sub block types)
+const (
+	S7BlockOB  = 0x08
+	S7BlockDB  = 0x0A
+	S7BlockSDB = 0x0B
+	S7BlockFC  = 0x0C
+	S7BlockSFC = 0x0D
+	S7BlockFB  = 0x0E
+	S7BlockSFB = 0x0F
+)
+
+// Languages of S7BlockInfo.BlkLang
+const (
+	S7LangSTL   = 0x01
+	S7LangLAD   = 0x02
+	S7LangFBD   = 0x03
+	S7LangSCL   = 0x04
+	S7LangDB    = 0x05
+	S7LangGRAPH = 0x06
+)
+
+// DeleteOption modifies DeleteBlock
+type DeleteOption int
+
+const (
+	// ConfirmDelete confirms that the block is to be deleted, DeleteBlock refuses to delete without it
+	ConfirmDelete DeleteOption = iota + 1
+)
+
 // S7BlockInfo Managed Block Info
 type S7BlockInfo struct {
 	BlkType   int
@@ -114,3 +143,66 @@ func (mb *client) GetAgBlockInfo(blocktype int, blocknum int) (info S7BlockInfo,
 func siemensTimestamp(EncodedDate int64) string {
 	return time.Date(1984, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Second * time.Duration((EncodedDate * 86400))).Format("02.01.2006")
 }
+
+//siemensDays is the reverse of siemensTimestamp, the days since 1984-01-01 of a date "02.01.2006", 0 if it is invalid
+func siemensDays(date string) uint16 {
+	t, err := time.Parse("02.01.2006", date)
+	if err != nil || t.Year() < 1984 {
+		return 0
+	}
+	return uint16(t.Sub(time.Date(1984, 1, 1, 0, 0, 0, 0, time.UTC)).Hours() / 24)
+}
+
+//blockTypeASCII returns the block type of the file names of the block transfers ('8' OB, 'A' DB, ...),
+//blockType is a sub block type (S7BlockOB, ...) or a block type byte (blockOB, ...) as for GetAgBlockInfo
+func blockTypeASCII(blockType int) (byte, error) {
+	switch {
+	case blockType >= S7BlockOB && blockType <= S7BlockSFB && blockType != 0x09:
+		return "0123456789ABCDEF"[blockType], nil
+	case blockType == blockOB || (blockType >= blockDB && blockType <= blockSFB):
+		return byte(blockType), nil
+	}
+	return 0, fmt.Errorf(ErrorText(errCliInvalidBlockType))
+}
+
+//putBlockNumber writes the 5 ASCII digits of a block number into buffer
+func putBlockNumber(buffer []byte, number int) error {
+	if number < 0 || number > maxAddressDBNumber {
+		return fmt.Errorf(ErrorText(errCliInvalidBlockNumber))
+	}
+	for i := 4; i >= 0; i-- {
+		buffer[i] = byte(number%10) + 0x30
+		number /= 10
+	}
+	return nil
+}
+
+//DeleteBlock deletes a block from the PLC with the PI service _DELE, blockType is S7BlockOB, S7BlockDB, ... or a
+//block type byte of GetAgBlockInfo. As the block is lost for good, nothing is deleted without ConfirmDelete.
+func (mb *client) DeleteBlock(blockType int, number int, options ...DeleteOption) (err error) {
+	confirmed := false
+	for _, option := range options {
+		confirmed = confirmed || option == ConfirmDelete
+	}
+	if !confirmed {
+		return fmt.Errorf("s7: deleting block %d of type %d is not confirmed by ConfirmDelete", number, blockType)
+	}
+	asciiType, err := blockTypeASCII(blockType)
+	if err != nil {
+		return
+	}
+	requestData := make([]byte, len(tpktISOTelegram)+len(s7PGBlockDeleteTelegram))
+	copy(requestData, tpktISOTelegram)
+	copy(requestData[len(tpktISOTelegram):], s7PGBlockDeleteTelegram)
+	binary.BigEndian.PutUint16(requestData[2:], uint16(len(requestData)))
+	requestData[30] = asciiType
+	if err = putBlockNumber(requestData[31:], number); err != nil {
+		return
+	}
+	request := NewProtocolDataUnit(requestData)
+	response, err := mb.send(&request)
+	if err != nil {
+		return
+	}
+	return piServiceError(response.Data, errCliDeleteRefused)
+}
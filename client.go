@@ -4,10 +4,9 @@ package gos7
 // This software may be modified and distributed under the terms
 // of the BSD license. See the LICENSE file for details.
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
-	"strconv"
-	"strings"
 )
 
 const (
@@ -46,6 +45,9 @@ const (
 	tsResInt   = 5
 	tsResReal  = 7
 	tsResOctet = 9
+
+	// minPduLength is the smallest PDU length any S7 CPU negotiates
+	minPduLength = 240
 )
 
 //PDULength variable to store pdu length after connect
@@ -59,6 +61,10 @@ type ClientHandler interface {
 type client struct {
 	packager    Packager
 	transporter Transporter
+	// ctx is the context of the calls made through this client, see withContext
+	ctx context.Context
+	// tags is the tag table of ReadTag, WriteTag and ReadTags, see SetTagTable
+	tags *TagTable
 }
 
 // NewClient creates a new s7 client with given backend handler.
@@ -185,9 +191,7 @@ func (mb *client) readArea(area int, dbNumber int, start int, amount int, wordLe
 		}
 	}
 
-	tt, _ := interface{}(mb.transporter).(*TCPClientHandler)
-
-	maxElements = (tt.PDULength - 18) / wordSize // 18 = Reply telegram header //lth note here
+	maxElements = (mb.pduLength() - 18) / wordSize // 18 = Reply telegram header //lth note here
 	totElements = amount
 	for totElements > 0 && err == nil {
 		numElements = totElements
@@ -283,8 +287,7 @@ func (mb *client) writeArea(area int, dbnumber int, start int, amount int, wordl
 			wordlen = s7wlbyte
 		}
 	}
-	tt, _ := interface{}(mb.transporter).(*TCPClientHandler)
-	maxElements = (tt.PDULength - 35) / wordSize // 35 = Reply telegram header
+	maxElements = (mb.pduLength() - 35) / wordSize // 35 = Reply telegram header
 	totElements = amount
 	for totElements > 0 && err == nil {
 		numElements = totElements
@@ -339,8 +342,7 @@ func (mb *client) writeArea(area int, dbnumber int, start int, amount int, wordl
 		case s7wlbit:
 			request.Data[32] = tsResBit
 			break
-		case s7wlcounter:
-		case s7wltimer:
+		case s7wlcounter, s7wltimer:
 			request.Data[32] = tsResOctet
 			break
 		default:
@@ -372,101 +374,89 @@ func (mb *client) writeArea(area int, dbnumber int, start int, amount int, wordl
 	return
 }
 
-//DBRead
+//Read reads a variable given in S7 syntax (see ParseAddress) into buffer and returns its value,
+//e.g. byte for MB0, uint16 for EW2, uint32 for DB1.DBD4, string for DB1.DBSTRING2.10 and bool for the bits
+//E0.1 or M3.2. A single DB bit such as DB1.DBX0.1 is returned as before as its byte masked to the bit
+//(0 or 1<<bit), which Write takes back, arrays of DB bits such as DB1.DBX0.1[3] as []bool.
 func (mb *client) Read(variable string, buffer []byte) (value interface{}, err error) {
-	variable = strings.ToUpper(variable)              //upper
-	variable = strings.Replace(variable, " ", "", -1) //remove spaces
+	address, err := ParseAddress(variable)
+	if err != nil {
+		return
+	}
+	size := address.Size()
+	if len(buffer) < size {
+		err = fmt.Errorf(ErrorText(errCliBufferTooSmall))
+		return
+	}
+	if err = mb.readAddress(address, buffer[:size]); err != nil {
+		return
+	}
+	if address.Area == s7areadb && address.WordLen == s7wlbit && address.Amount == 1 {
+		value = buffer[0] & (1 << uint(address.Bit))
+		return
+	}
+	value = address.decode(buffer[:size])
+	return
+}
 
-	if variable == "" {
-		err = fmt.Errorf("input variable is empty, variable should be S7 syntax")
+//Write writes a value to a variable given in S7 syntax (see ParseAddress). The Go type of the value has to
+//match the address: bool for DBX (or the masked byte Read returns for a single DB bit), byte for DBB, uint16/int16 for DBW, uint32/int32/float32 (REAL) for DBD,
+//string for DBSTRING and slices of them for arrays. Bits are written alone, without reading the byte first.
+func (mb *client) Write(variable string, value interface{}) (err error) {
+	address, err := ParseAddress(variable)
+	if err != nil {
 		return
 	}
-	//var area, dbNumber, start, amount, wordLen int
-	switch valueArea := variable[0:2]; valueArea {
-	case "EB": //input byte
-	case "EW": //input word
-	case "ED": //Input double-word
-	case "AB": //Output byte
-	case "AW": //Output word
-	case "AD": //Output double-word
-	case "MB": //Memory byte
-	case "MW": //Memory word
-	case "MD": //Memory double-word
-	case "DB": //Data Block
-		dbArray := strings.Split(variable, ".")
-		if len(dbArray) < 2 {
-			err = fmt.Errorf("Db Area read variable should not be empty")
-			return
-		}
-		dbNo, _ := strconv.ParseInt(string(string(dbArray[0])[2:]), 10, 16)
-		dbIndex, _ := strconv.ParseInt(string(string(dbArray[1])[3:]), 10, 16)
-		dbType := string(dbArray[1])[0:3]
-
-		switch dbType {
-		case "DBB": //byte
-			err = mb.AGReadDB(int(dbNo), int(dbIndex), 1, buffer)
-			value = buffer[0]
-			return
-		case "DBW": //word
-			err = mb.AGReadDB(int(dbNo), int(dbIndex), 2, buffer)
-			value = binary.BigEndian.Uint16(buffer[0:])
-			return
-		case "DBD": //dword
-			err = mb.AGReadDB(int(dbNo), int(dbIndex), 4, buffer)
-			value = binary.BigEndian.Uint32(buffer[0:])
-			return
-		case "DBX": //bit
-			mBit, _ := strconv.ParseInt(string(string(dbArray[2])[0:]), 10, 16)
-			if mBit > 7 || mBit < 0 {
-				err = fmt.Errorf("Db read bit is invalid")
-				return
-			}
-			err = mb.AGReadDB(int(dbNo), int(dbIndex), 1, buffer)
-			mask := []byte{0x01, 0x02, 0x04, 0x08, 0x10, 0x20, 0x40, 0x80}
-			value = buffer[0] & mask[mBit]
-			return
-		default:
-			err = fmt.Errorf("error when parsing dbtype")
-			return
-		}
-	default:
-		switch otherArea := variable[0:1]; otherArea {
-		case "E":
-		case "I": //input
-		case "A":
-		case "0": //output
-		case "M": //memory
-		case "T": //timer
-			startByte, _ := strconv.ParseInt(string(variable[1:]), 10, 16)
-			err = mb.AGReadTM(int(startByte), 1, buffer)
-			if err != nil {
-				return
-			}
-			helper := Helper{}
-			helper.GetValueAt(buffer, 0, value)
-			return
-		case "Z":
-		case "C": //counter
-			startByte, _ := strconv.ParseInt(string(variable[1:]), 10, 16)
-			err = mb.AGReadCT(int(startByte), 1, buffer)
-			if err != nil {
-				return
-			}
-			helper := Helper{}
-			helper.GetValueAt(buffer, 0, value)
-			return
-		default:
-			err = fmt.Errorf("error when parsing db area")
-			return
-		}
+	buffer, err := address.encode(value)
+	if err != nil {
+		return
+	}
+	return mb.writeAddress(address, buffer)
+}
 
+// session returns the negotiated session of the transporter or packager, nil if none of them exposes it
+func (mb *client) session() Session {
+	if session, ok := mb.transporter.(Session); ok {
+		return session
 	}
-	return
+	if session, ok := mb.packager.(Session); ok {
+		return session
+	}
+	return nil
+}
+
+// pduLength returns the negotiated PDU length, the minimum PDU length of all S7 CPUs if it is not known
+func (mb *client) pduLength() int {
+	if session := mb.session(); session != nil && session.PDUSize() >= minPduLength {
+		return session.PDUSize()
+	}
+	return minPduLength
+}
+
+// withContext returns a shallow copy of the client whose requests are bound to ctx
+func (mb *client) withContext(ctx context.Context) *client {
+	c := *mb
+	c.ctx = ctx
+	return &c
+}
+
+// context returns the context of the requests, background if none was given
+func (mb *client) context() context.Context {
+	if mb.ctx == nil {
+		return context.Background()
+	}
+	return mb.ctx
 }
 
 //send the package of a pdu request and a pdu response, check for response error and verify the package
 func (mb *client) send(request *ProtocolDataUnit) (response *ProtocolDataUnit, err error) {
-	dataResponse, err := mb.transporter.Send(request.Data)
+	ctx := mb.context()
+	var dataResponse []byte
+	if transporter, ok := mb.transporter.(TransporterContext); ok {
+		dataResponse, err = transporter.SendContext(ctx, request.Data)
+	} else if err = ctx.Err(); err == nil {
+		dataResponse, err = mb.transporter.Send(request.Data)
+	}
 	if err != nil {
 		return
 	}
@@ -0,0 +1,330 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// readTransporter answers every read var request with incrementing bytes and records the request sizes
+type readTransporter struct {
+	requested []int
+}
+
+func (t *readTransporter) Send(request []byte) ([]byte, error) {
+	amount := int(binary.BigEndian.Uint16(request[23:]))
+	start := (int(request[28])<<16 + int(request[29])<<8 + int(request[30])) >> 3
+	t.requested = append(t.requested, amount)
+	response := make([]byte, 25+amount)
+	copy(response, tpktISOTelegram)
+	binary.BigEndian.PutUint16(response[2:], uint16(len(response)))
+	response[7] = 0x32
+	response[8] = 3
+	copy(response[11:13], request[11:13])
+	response[19] = 4
+	response[20] = 1
+	response[21] = 0xFF
+	response[22] = tsResByte
+	binary.BigEndian.PutUint16(response[23:], uint16(amount<<3))
+	for i := 0; i < amount; i++ {
+		response[25+i] = byte(start + i)
+	}
+	return response, nil
+}
+
+type sessionTransporter struct {
+	readTransporter
+	pduSize int
+}
+
+func (t *sessionTransporter) PDUSize() int         { return t.pduSize }
+func (t *sessionTransporter) MaxParallelJobs() int { return 1 }
+
+func TestClientCustomTransporter(t *testing.T) {
+	transporter := &readTransporter{}
+	client := NewClient2(&tcpPackager{}, transporter)
+	buffer := make([]byte, 500)
+	if err := client.AGReadDB(1, 0, 500, buffer); err != nil {
+		t.Fatal(err)
+	}
+	// without a session the minimum PDU length of 240 is assumed
+	if !equalInts(transporter.requested, []int{222, 222, 56}) {
+		t.Fatalf("unexpected requests: %v", transporter.requested)
+	}
+	for i := range buffer {
+		if buffer[i] != byte(i) {
+			t.Fatalf("unexpected data at %d: %d", i, buffer[i])
+		}
+	}
+}
+
+func TestClientSessionPDUSize(t *testing.T) {
+	transporter := &sessionTransporter{pduSize: 480}
+	client := NewClient2(&tcpPackager{}, transporter)
+	buffer := make([]byte, 500)
+	if err := client.AGReadDB(1, 0, 500, buffer); err != nil {
+		t.Fatal(err)
+	}
+	if !equalInts(transporter.requested, []int{462, 38}) {
+		t.Fatalf("unexpected requests: %v", transporter.requested)
+	}
+	if !bytes.Equal(buffer[460:464], []byte{204, 205, 206, 207}) {
+		t.Fatalf("unexpected data: % x", buffer[460:464])
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestClientRead(t *testing.T) {
+	client := NewClient2(&tcpPackager{}, &readTransporter{})
+	buffer := make([]byte, 64)
+	input := []struct {
+		variable string
+		value    interface{}
+	}{
+		{"DB1.DBB5", byte(5)},
+		{"DB1.DBW2", uint16(0x0203)},
+		{"MD4", uint32(0x04050607)},
+		{"DB1.DBX3.1", byte(0x02)}, // a single DB bit is its masked byte
+		{"DB1.DBX3.2", byte(0)},
+		{"M3.1", true},
+		{"M3.2", false},
+		{"EB8", byte(8)},
+	}
+	for _, i := range input {
+		value, err := client.Read(i.variable, buffer)
+		if err != nil {
+			t.Fatalf("%s: %v", i.variable, err)
+		}
+		if value != i.value {
+			t.Errorf("%s: expected %v (%T) given %v (%T)", i.variable, i.value, i.value, value, value)
+		}
+	}
+}
+
+// writeTransporter acknowledges every write var request and records the requests
+type writeTransporter struct {
+	requests [][]byte
+}
+
+func (t *writeTransporter) Send(request []byte) ([]byte, error) {
+	t.requests = append(t.requests, append([]byte{}, request...))
+	response := []byte{3, 0, 0, 22, 2, 240, 128, 50, 3, 0, 0, request[11], request[12], 0, 2, 0, 1, 0, 0, 5, 1, 0xFF}
+	return response, nil
+}
+
+func TestClientWrite(t *testing.T) {
+	input := []struct {
+		variable  string
+		value     interface{}
+		wordLen   byte
+		address   int
+		data      []byte
+		transport byte
+	}{
+		{"DB1.DBX3.1", true, S7WLBit, 3*8 + 1, []byte{1}, tsResBit},
+		{"M0.7", false, S7WLBit, 7, []byte{0}, tsResBit},
+		{"DB1.DBB5", byte(7), S7WLByte, 5 * 8, []byte{7}, tsResByte},
+		{"DB1.DBW2", int16(-2), S7WLByte, 2 * 8, []byte{0xFF, 0xFE}, tsResByte},
+		{"MW10", 1000, S7WLByte, 10 * 8, []byte{0x03, 0xE8}, tsResByte},
+		{"DB2.DBD4", float32(1.5), S7WLByte, 4 * 8, []byte{0x3F, 0xC0, 0, 0}, tsResByte},
+		{"DB1.DBW0[2]", []uint16{1, 2}, S7WLByte, 0, []byte{0, 1, 0, 2}, tsResByte},
+		{"DB5.DBSTRING10.4", "ab", S7WLByte, 10 * 8, []byte{4, 2, 'a', 'b', 0, 0}, tsResByte},
+		{"T3", uint16(0x1234), S7WLTimer, 3, []byte{0x12, 0x34}, tsResOctet},
+	}
+	for _, i := range input {
+		transporter := &writeTransporter{}
+		client := NewClient2(&tcpPackager{}, transporter)
+		if err := client.Write(i.variable, i.value); err != nil {
+			t.Fatalf("%s: %v", i.variable, err)
+		}
+		if len(transporter.requests) != 1 {
+			t.Fatalf("%s: unexpected request count %d", i.variable, len(transporter.requests))
+		}
+		request := transporter.requests[0]
+		address := int(request[28])<<16 + int(request[29])<<8 + int(request[30])
+		if request[22] != i.wordLen || address != i.address || request[32] != i.transport {
+			t.Errorf("%s: unexpected request % x", i.variable, request)
+		}
+		if !bytes.Equal(request[35:], i.data) {
+			t.Errorf("%s: expected data % x given % x", i.variable, i.data, request[35:])
+		}
+	}
+}
+
+func TestClientWriteTypeMismatch(t *testing.T) {
+	client := NewClient2(&tcpPackager{}, &writeTransporter{})
+	input := []struct {
+		variable string
+		value    interface{}
+	}{
+		{"DB1.DBX0.0", 1},
+		{"DB1.DBX0.1", byte(1)},
+		{"DB1.DBX0.1[2]", []byte{0, 2}},
+		{"M0.1", byte(2)},
+		{"DB1.DBB0", uint16(1)},
+		{"DB1.DBW0", float32(1)},
+		{"DB1.DBW0", 70000},
+		{"DB1.DBD0", 1.5},
+		{"DB1.DBSTRING0.2", "abc"},
+		{"DB1.DBW0[2]", []uint16{1}},
+	}
+	for _, i := range input {
+		if err := client.Write(i.variable, i.value); err == nil {
+			t.Errorf("%s: expected an error writing %v (%T)", i.variable, i.value, i.value)
+		}
+	}
+}
+
+func TestClientWriteReadDBBit(t *testing.T) {
+	server := NewServer()
+	db := []byte{0x02}
+	server.RegisterArea(S7AreaDB, 1, db)
+	handler := startServer(t, server)
+	defer server.Close()
+	defer handler.Close()
+	client := NewClient(handler)
+
+	buffer := make([]byte, 1)
+	for _, variable := range []string{"DB1.DBX0.1", "DB1.DBX0.2"} {
+		value, err := client.Read(variable, buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// writing back what was read keeps the bit
+		if err = client.Write(variable, value); err != nil {
+			t.Fatalf("%s: %v", variable, err)
+		}
+	}
+	if db[0] != 0x02 {
+		t.Errorf("unexpected DB1 % x", db)
+	}
+	if err := client.Write("DB1.DBX0.2", byte(0x04)); err != nil || db[0] != 0x06 {
+		t.Errorf("unexpected DB1 % x: %v", db, err)
+	}
+}
+
+func TestClientElementaryTypes(t *testing.T) {
+	client := NewClient2(&tcpPackager{}, &readTransporter{})
+	// the transporter answers with the byte offsets as data
+	if value, err := client.ReadInt(S7AreaDB, 1, 0xFE); err != nil || value != -257 {
+		t.Errorf("ReadInt: %v %v", value, err)
+	}
+	if value, err := client.ReadWord(S7AreaMK, 0, 2); err != nil || value != 0x0203 {
+		t.Errorf("ReadWord: %v %v", value, err)
+	}
+	if value, err := client.ReadDInt(S7AreaDB, 1, 4); err != nil || value != 0x04050607 {
+		t.Errorf("ReadDInt: %v %v", value, err)
+	}
+	if value, err := client.ReadString(S7AreaDB, 1, 4, 10); err != nil || value != "\x06\x07\x08\x09\x0a" {
+		t.Errorf("ReadString: %q %v", value, err)
+	}
+	// the actual length 0x0C exceeds the maximum length
+	if _, err := client.ReadString(S7AreaDB, 1, 10, 10); err == nil {
+		t.Errorf("ReadString: expected an error")
+	}
+
+	transporter := &writeTransporter{}
+	client = NewClient2(&tcpPackager{}, transporter)
+	if err := client.WriteString(S7AreaDB, 1, 0, 10, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WriteReal(S7AreaDB, 1, 0, 1.5); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WriteString(S7AreaDB, 1, 0, 2, "abc"); err == nil {
+		t.Errorf("WriteString: expected an error")
+	}
+	if !bytes.Equal(transporter.requests[0][35:], []byte{10, 3, 'a', 'b', 'c'}) {
+		t.Errorf("WriteString: unexpected request % x", transporter.requests[0])
+	}
+	if !bytes.Equal(transporter.requests[1][35:], []byte{0x3F, 0xC0, 0, 0}) {
+		t.Errorf("WriteReal: unexpected request % x", transporter.requests[1])
+	}
+}
+
+// clockTransporter acknowledges the set clock request and records it
+type clockTransporter struct {
+	request []byte
+}
+
+func (t *clockTransporter) Send(request []byte) ([]byte, error) {
+	t.request = append([]byte{}, request...)
+	response := []byte{3, 0, 0, 34, 2, 240, 128, 50, 7, 0, 0, request[11], request[12], 0, 12, 0, 4, 0, 1, 18, 8, 18, 135, 2, 1, 0, 0, 0, 0, 10, 0, 0, 0, 0}
+	return response, nil
+}
+
+func TestClientPGClockRead(t *testing.T) {
+	transporter := &clockTransporter{}
+	client := NewClient2(&tcpPackager{}, transporter)
+	clock := time.Date(2026, time.October, 17, 13, 45, 9, 123000000, time.UTC)
+	if err := client.PGClockRead(clock); err != nil {
+		t.Fatal(err)
+	}
+	// snap7 S7_SET_DT: century, then year, month, day, hour, minute, second, ms and day of week (1 = Sunday) as BCD
+	expected := []byte{0x20, 0x26, 0x10, 0x17, 0x13, 0x45, 0x09, 0x12, 0x37}
+	if len(transporter.request) != len(s7SetDatetimeTelegram) || !bytes.Equal(transporter.request[30:], expected) {
+		t.Errorf("expected % x given % x", expected, transporter.request)
+	}
+	if !bytes.Equal(transporter.request[:30], s7SetDatetimeTelegram[:30]) {
+		t.Errorf("unexpected header % x", transporter.request[:30])
+	}
+}
+
+func TestClientElementaryTypesRoundTrip(t *testing.T) {
+	server := NewServer()
+	db := make([]byte, 64)
+	server.RegisterArea(S7AreaDB, 1, db)
+	handler := startServer(t, server)
+	defer server.Close()
+	defer handler.Close()
+	client := NewClient(handler)
+
+	if err := client.WriteBool(S7AreaDB, 1, 0, 5, true); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := client.ReadBool(S7AreaDB, 1, 0, 5); err != nil || !value || db[0] != 0x20 {
+		t.Errorf("ReadBool: %v %v, DB1 % x", value, err, db[:1])
+	}
+	if err := client.WriteBool(S7AreaDB, 1, 0, 5, false); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := client.ReadBool(S7AreaDB, 1, 0, 5); err != nil || value {
+		t.Errorf("ReadBool: %v %v", value, err)
+	}
+	if _, err := client.ReadBool(S7AreaDB, 1, 0, 8); err == nil {
+		t.Errorf("ReadBool: expected an error for bit 8")
+	}
+
+	stamp := time.Date(2021, 6, 30, 23, 59, 58, 123456789, time.UTC)
+	if err := client.WriteDTL(S7AreaDB, 1, 2, stamp); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := client.ReadDTL(S7AreaDB, 1, 2); err != nil || !value.Equal(stamp) {
+		t.Errorf("ReadDTL: %v %v", value, err)
+	}
+
+	if err := client.WriteS5Time(S7AreaDB, 1, 14, 2*time.Second+500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := client.ReadS5Time(S7AreaDB, 1, 14); err != nil || value != 2500*time.Millisecond {
+		t.Errorf("ReadS5Time: %v %v", value, err)
+	}
+	if err := client.WriteS5Time(S7AreaDB, 1, 14, 3*time.Hour); err == nil {
+		t.Errorf("WriteS5Time: expected an error beyond 2h46m30s")
+	}
+}
@@ -0,0 +1,346 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"context"
+	"time"
+)
+
+// ClientContext is a Client whose calls can also be bound to a context.Context.
+// Cancelling the context or reaching its deadline aborts the pending request and the call returns ctx.Err().
+// Clients returned by NewClient and NewClient2 implement ClientContext.
+type ClientContext interface {
+	Client
+	/***************start API AG (Automatisationsgerät)***************/
+	AGReadDBContext(ctx context.Context, dbNumber int, start int, size int, buffer []byte) (err error)
+	AGWriteDBContext(ctx context.Context, dbNumber int, start int, size int, buffer []byte) (err error)
+	AGReadMBContext(ctx context.Context, start int, size int, buffer []byte) (err error)
+	AGWriteMBContext(ctx context.Context, start int, size int, buffer []byte) (err error)
+	AGReadEBContext(ctx context.Context, start int, size int, buffer []byte) (err error)
+	AGWriteEBContext(ctx context.Context, start int, size int, buffer []byte) (err error)
+	AGReadABContext(ctx context.Context, start int, size int, buffer []byte) (err error)
+	AGWriteABContext(ctx context.Context, start int, size int, buffer []byte) (err error)
+	AGReadTMContext(ctx context.Context, start int, size int, buffer []byte) (err error)
+	AGWriteTMContext(ctx context.Context, start int, size int, buffer []byte) (err error)
+	AGReadCTContext(ctx context.Context, start int, size int, buffer []byte) (err error)
+	AGWriteCTContext(ctx context.Context, start int, size int, buffer []byte) (err error)
+	AGReadMultiContext(ctx context.Context, dataItems []S7DataItem, itemsCount int) (err error)
+	AGWriteMultiContext(ctx context.Context, dataItems []S7DataItem, itemsCount int) (err error)
+	ReadItemsContext(ctx context.Context, items []S7DataItem) (err error)
+	WriteItemsContext(ctx context.Context, items []S7DataItem) (err error)
+	ReadOptimizedContext(ctx context.Context, addresses []S7Address, maxGap int) (results []ReadResult, err error)
+	DBFillContext(ctx context.Context, dbnumber int, fillchar int) error
+	DBGetContext(ctx context.Context, dbnumber int, usrdata []byte, size int) error
+	ReadContext(ctx context.Context, variable string, buffer []byte) (value interface{}, err error)
+	WriteContext(ctx context.Context, variable string, value interface{}) (err error)
+	ReadTagContext(ctx context.Context, name string) (value interface{}, err error)
+	WriteTagContext(ctx context.Context, name string, value interface{}) (err error)
+	ReadTagsContext(ctx context.Context, names ...string) (values []interface{}, err error)
+	ReadBoolContext(ctx context.Context, area int, dbNumber int, start int, bit int) (value bool, err error)
+	WriteBoolContext(ctx context.Context, area int, dbNumber int, start int, bit int, value bool) error
+	ReadIntContext(ctx context.Context, area int, dbNumber int, start int) (value int16, err error)
+	WriteIntContext(ctx context.Context, area int, dbNumber int, start int, value int16) error
+	ReadDIntContext(ctx context.Context, area int, dbNumber int, start int) (value int32, err error)
+	WriteDIntContext(ctx context.Context, area int, dbNumber int, start int, value int32) error
+	ReadWordContext(ctx context.Context, area int, dbNumber int, start int) (value uint16, err error)
+	WriteWordContext(ctx context.Context, area int, dbNumber int, start int, value uint16) error
+	ReadRealContext(ctx context.Context, area int, dbNumber int, start int) (value float32, err error)
+	WriteRealContext(ctx context.Context, area int, dbNumber int, start int, value float32) error
+	ReadStringContext(ctx context.Context, area int, dbNumber int, start int, maxLen int) (value string, err error)
+	WriteStringContext(ctx context.Context, area int, dbNumber int, start int, maxLen int, value string) error
+	ReadWStringContext(ctx context.Context, area int, dbNumber int, start int, maxLen int) (value string, err error)
+	WriteWStringContext(ctx context.Context, area int, dbNumber int, start int, maxLen int, value string) error
+	ReadDTLContext(ctx context.Context, area int, dbNumber int, start int) (value time.Time, err error)
+	WriteDTLContext(ctx context.Context, area int, dbNumber int, start int, value time.Time) error
+	ReadS5TimeContext(ctx context.Context, area int, dbNumber int, start int) (value time.Duration, err error)
+	WriteS5TimeContext(ctx context.Context, area int, dbNumber int, start int, value time.Duration) error
+	ReadStructContext(ctx context.Context, dbNumber int, v interface{}) error
+	WriteStructContext(ctx context.Context, dbNumber int, v interface{}) error
+	GetAgBlockInfoContext(ctx context.Context, blocktype int, blocknum int) (info S7BlockInfo, err error)
+	/***************end API AG***************/
+
+	/***************start API PG (Programmiergerät)***************/
+	PLCHotStartContext(ctx context.Context) error
+	PLCColdStartContext(ctx context.Context) error
+	PLCStopContext(ctx context.Context) error
+	PLCGetStatusContext(ctx context.Context) (status int, err error)
+	PGListBlocksContext(ctx context.Context) (list S7BlocksList, err error)
+	UploadBlockContext(ctx context.Context, blockType int, number int) (data []byte, err error)
+	DownloadBlockContext(ctx context.Context, data []byte) error
+	DeleteBlockContext(ctx context.Context, blockType int, number int, options ...DeleteOption) error
+	CompressContext(ctx context.Context, timeout time.Duration) error
+	CopyRamToRomContext(ctx context.Context, timeout time.Duration) error
+	SetSessionPasswordContext(ctx context.Context, password string) error
+	ClearSessionPasswordContext(ctx context.Context) error
+	GetProtectionContext(ctx context.Context) (protection S7Protection, err error)
+	GetOrderCodeContext(ctx context.Context) (info S7OrderCode, err error)
+	GetCPUInfoContext(ctx context.Context) (info S7CpuInfo, err error)
+	GetCPInfoContext(ctx context.Context) (info S7CpInfo, err error)
+	GetDiagnosticBufferContext(ctx context.Context, max int) (events []DiagEvent, err error)
+	PGClockReadContext(ctx context.Context, datetime time.Time) error
+	PGClockWriteContext(ctx context.Context) (dt time.Time, err error)
+	/***************end API PG***************/
+}
+
+// NewClientContext creates a new s7 client with given backend handler, which accepts a context on every call.
+func NewClientContext(handler ClientHandler) ClientContext {
+	return &client{packager: handler, transporter: handler}
+}
+
+func (mb *client) AGReadDBContext(ctx context.Context, dbNumber int, start int, size int, buffer []byte) error {
+	return mb.withContext(ctx).AGReadDB(dbNumber, start, size, buffer)
+}
+
+func (mb *client) AGWriteDBContext(ctx context.Context, dbNumber int, start int, size int, buffer []byte) error {
+	return mb.withContext(ctx).AGWriteDB(dbNumber, start, size, buffer)
+}
+
+func (mb *client) AGReadMBContext(ctx context.Context, start int, size int, buffer []byte) error {
+	return mb.withContext(ctx).AGReadMB(start, size, buffer)
+}
+
+func (mb *client) AGWriteMBContext(ctx context.Context, start int, size int, buffer []byte) error {
+	return mb.withContext(ctx).AGWriteMB(start, size, buffer)
+}
+
+func (mb *client) AGReadEBContext(ctx context.Context, start int, size int, buffer []byte) error {
+	return mb.withContext(ctx).AGReadEB(start, size, buffer)
+}
+
+func (mb *client) AGWriteEBContext(ctx context.Context, start int, size int, buffer []byte) error {
+	return mb.withContext(ctx).AGWriteEB(start, size, buffer)
+}
+
+func (mb *client) AGReadABContext(ctx context.Context, start int, size int, buffer []byte) error {
+	return mb.withContext(ctx).AGReadAB(start, size, buffer)
+}
+
+func (mb *client) AGWriteABContext(ctx context.Context, start int, size int, buffer []byte) error {
+	return mb.withContext(ctx).AGWriteAB(start, size, buffer)
+}
+
+func (mb *client) AGReadTMContext(ctx context.Context, start int, amount int, buffer []byte) error {
+	return mb.withContext(ctx).AGReadTM(start, amount, buffer)
+}
+
+func (mb *client) AGWriteTMContext(ctx context.Context, start int, amount int, buffer []byte) error {
+	return mb.withContext(ctx).AGWriteTM(start, amount, buffer)
+}
+
+func (mb *client) AGReadCTContext(ctx context.Context, start int, amount int, buffer []byte) error {
+	return mb.withContext(ctx).AGReadCT(start, amount, buffer)
+}
+
+func (mb *client) AGWriteCTContext(ctx context.Context, start int, amount int, buffer []byte) error {
+	return mb.withContext(ctx).AGWriteCT(start, amount, buffer)
+}
+
+func (mb *client) AGReadMultiContext(ctx context.Context, dataItems []S7DataItem, itemsCount int) error {
+	return mb.withContext(ctx).AGReadMulti(dataItems, itemsCount)
+}
+
+func (mb *client) AGWriteMultiContext(ctx context.Context, dataItems []S7DataItem, itemsCount int) error {
+	return mb.withContext(ctx).AGWriteMulti(dataItems, itemsCount)
+}
+
+func (mb *client) ReadItemsContext(ctx context.Context, items []S7DataItem) error {
+	return mb.withContext(ctx).ReadItems(items)
+}
+
+func (mb *client) WriteItemsContext(ctx context.Context, items []S7DataItem) error {
+	return mb.withContext(ctx).WriteItems(items)
+}
+
+func (mb *client) ReadOptimizedContext(ctx context.Context, addresses []S7Address, maxGap int) ([]ReadResult, error) {
+	return mb.withContext(ctx).ReadOptimized(addresses, maxGap)
+}
+
+func (mb *client) DBFillContext(ctx context.Context, dbnumber int, fillChar int) error {
+	return mb.withContext(ctx).DBFill(dbnumber, fillChar)
+}
+
+func (mb *client) DBGetContext(ctx context.Context, dbnumber int, usrdata []byte, size int) error {
+	return mb.withContext(ctx).DBGet(dbnumber, usrdata, size)
+}
+
+func (mb *client) ReadContext(ctx context.Context, variable string, buffer []byte) (interface{}, error) {
+	return mb.withContext(ctx).Read(variable, buffer)
+}
+
+func (mb *client) WriteContext(ctx context.Context, variable string, value interface{}) error {
+	return mb.withContext(ctx).Write(variable, value)
+}
+
+func (mb *client) ReadTagContext(ctx context.Context, name string) (interface{}, error) {
+	return mb.withContext(ctx).ReadTag(name)
+}
+
+func (mb *client) WriteTagContext(ctx context.Context, name string, value interface{}) error {
+	return mb.withContext(ctx).WriteTag(name, value)
+}
+
+func (mb *client) ReadTagsContext(ctx context.Context, names ...string) ([]interface{}, error) {
+	return mb.withContext(ctx).ReadTags(names...)
+}
+
+func (mb *client) ReadBoolContext(ctx context.Context, area int, dbNumber int, start int, bit int) (bool, error) {
+	return mb.withContext(ctx).ReadBool(area, dbNumber, start, bit)
+}
+
+func (mb *client) WriteBoolContext(ctx context.Context, area int, dbNumber int, start int, bit int, value bool) error {
+	return mb.withContext(ctx).WriteBool(area, dbNumber, start, bit, value)
+}
+
+func (mb *client) ReadIntContext(ctx context.Context, area int, dbNumber int, start int) (int16, error) {
+	return mb.withContext(ctx).ReadInt(area, dbNumber, start)
+}
+
+func (mb *client) WriteIntContext(ctx context.Context, area int, dbNumber int, start int, value int16) error {
+	return mb.withContext(ctx).WriteInt(area, dbNumber, start, value)
+}
+
+func (mb *client) ReadDIntContext(ctx context.Context, area int, dbNumber int, start int) (int32, error) {
+	return mb.withContext(ctx).ReadDInt(area, dbNumber, start)
+}
+
+func (mb *client) WriteDIntContext(ctx context.Context, area int, dbNumber int, start int, value int32) error {
+	return mb.withContext(ctx).WriteDInt(area, dbNumber, start, value)
+}
+
+func (mb *client) ReadWordContext(ctx context.Context, area int, dbNumber int, start int) (uint16, error) {
+	return mb.withContext(ctx).ReadWord(area, dbNumber, start)
+}
+
+func (mb *client) WriteWordContext(ctx context.Context, area int, dbNumber int, start int, value uint16) error {
+	return mb.withContext(ctx).WriteWord(area, dbNumber, start, value)
+}
+
+func (mb *client) ReadRealContext(ctx context.Context, area int, dbNumber int, start int) (float32, error) {
+	return mb.withContext(ctx).ReadReal(area, dbNumber, start)
+}
+
+func (mb *client) WriteRealContext(ctx context.Context, area int, dbNumber int, start int, value float32) error {
+	return mb.withContext(ctx).WriteReal(area, dbNumber, start, value)
+}
+
+func (mb *client) ReadStringContext(ctx context.Context, area int, dbNumber int, start int, maxLen int) (string, error) {
+	return mb.withContext(ctx).ReadString(area, dbNumber, start, maxLen)
+}
+
+func (mb *client) WriteStringContext(ctx context.Context, area int, dbNumber int, start int, maxLen int, value string) error {
+	return mb.withContext(ctx).WriteString(area, dbNumber, start, maxLen, value)
+}
+
+func (mb *client) ReadWStringContext(ctx context.Context, area int, dbNumber int, start int, maxLen int) (string, error) {
+	return mb.withContext(ctx).ReadWString(area, dbNumber, start, maxLen)
+}
+
+func (mb *client) WriteWStringContext(ctx context.Context, area int, dbNumber int, start int, maxLen int, value string) error {
+	return mb.withContext(ctx).WriteWString(area, dbNumber, start, maxLen, value)
+}
+
+func (mb *client) ReadDTLContext(ctx context.Context, area int, dbNumber int, start int) (time.Time, error) {
+	return mb.withContext(ctx).ReadDTL(area, dbNumber, start)
+}
+
+func (mb *client) WriteDTLContext(ctx context.Context, area int, dbNumber int, start int, value time.Time) error {
+	return mb.withContext(ctx).WriteDTL(area, dbNumber, start, value)
+}
+
+func (mb *client) ReadS5TimeContext(ctx context.Context, area int, dbNumber int, start int) (time.Duration, error) {
+	return mb.withContext(ctx).ReadS5Time(area, dbNumber, start)
+}
+
+func (mb *client) WriteS5TimeContext(ctx context.Context, area int, dbNumber int, start int, value time.Duration) error {
+	return mb.withContext(ctx).WriteS5Time(area, dbNumber, start, value)
+}
+
+func (mb *client) ReadStructContext(ctx context.Context, dbNumber int, v interface{}) error {
+	return mb.withContext(ctx).ReadStruct(dbNumber, v)
+}
+
+func (mb *client) WriteStructContext(ctx context.Context, dbNumber int, v interface{}) error {
+	return mb.withContext(ctx).WriteStruct(dbNumber, v)
+}
+
+func (mb *client) GetAgBlockInfoContext(ctx context.Context, blocktype int, blocknum int) (S7BlockInfo, error) {
+	return mb.withContext(ctx).GetAgBlockInfo(blocktype, blocknum)
+}
+
+func (mb *client) PLCHotStartContext(ctx context.Context) error {
+	return mb.withContext(ctx).PLCHotStart()
+}
+
+func (mb *client) PLCColdStartContext(ctx context.Context) error {
+	return mb.withContext(ctx).PLCColdStart()
+}
+
+func (mb *client) PLCStopContext(ctx context.Context) error {
+	return mb.withContext(ctx).PLCStop()
+}
+
+func (mb *client) PLCGetStatusContext(ctx context.Context) (int, error) {
+	return mb.withContext(ctx).PLCGetStatus()
+}
+
+func (mb *client) PGListBlocksContext(ctx context.Context) (S7BlocksList, error) {
+	return mb.withContext(ctx).PGListBlocks()
+}
+
+func (mb *client) UploadBlockContext(ctx context.Context, blockType int, number int) ([]byte, error) {
+	return mb.withContext(ctx).UploadBlock(blockType, number)
+}
+
+func (mb *client) DownloadBlockContext(ctx context.Context, data []byte) error {
+	return mb.withContext(ctx).DownloadBlock(data)
+}
+
+func (mb *client) DeleteBlockContext(ctx context.Context, blockType int, number int, options ...DeleteOption) error {
+	return mb.withContext(ctx).DeleteBlock(blockType, number, options...)
+}
+
+func (mb *client) CompressContext(ctx context.Context, timeout time.Duration) error {
+	return mb.withContext(ctx).Compress(timeout)
+}
+
+func (mb *client) CopyRamToRomContext(ctx context.Context, timeout time.Duration) error {
+	return mb.withContext(ctx).CopyRamToRom(timeout)
+}
+
+func (mb *client) SetSessionPasswordContext(ctx context.Context, password string) error {
+	return mb.withContext(ctx).SetSessionPassword(password)
+}
+
+func (mb *client) ClearSessionPasswordContext(ctx context.Context) error {
+	return mb.withContext(ctx).ClearSessionPassword()
+}
+
+func (mb *client) GetProtectionContext(ctx context.Context) (S7Protection, error) {
+	return mb.withContext(ctx).GetProtection()
+}
+
+func (mb *client) GetOrderCodeContext(ctx context.Context) (S7OrderCode, error) {
+	return mb.withContext(ctx).GetOrderCode()
+}
+
+func (mb *client) GetCPUInfoContext(ctx context.Context) (S7CpuInfo, error) {
+	return mb.withContext(ctx).GetCPUInfo()
+}
+
+func (mb *client) GetCPInfoContext(ctx context.Context) (S7CpInfo, error) {
+	return mb.withContext(ctx).GetCPInfo()
+}
+
+func (mb *client) GetDiagnosticBufferContext(ctx context.Context, max int) ([]DiagEvent, error) {
+	return mb.withContext(ctx).GetDiagnosticBuffer(max)
+}
+
+func (mb *client) PGClockReadContext(ctx context.Context, datetime time.Time) error {
+	return mb.withContext(ctx).PGClockRead(datetime)
+}
+
+func (mb *client) PGClockWriteContext(ctx context.Context) (time.Time, error) {
+	return mb.withContext(ctx).PGClockWrite()
+}
@@ -4,8 +4,19 @@ package gos7
 // This software may be modified and distributed under the terms
 // of the BSD license. See the LICENSE file for details.
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
+	"time"
+)
+
+const (
+	// piPollInterval is the interval a PI service is repeated at while the PLC is busy
+	piPollInterval = 100 * time.Millisecond
+	// piServiceTimeout is the time a PI service is waited for if neither a timeout nor a context deadline is given
+	piServiceTimeout = 2 * time.Minute
+	// errClassNoResources is the error class of the PLC while it is busy
+	errClassNoResources = 0x83
 )
 
 // implement PLC hot start interface
@@ -110,3 +121,73 @@ func (mb *client) PLCGetStatus() (status int, err error) {
 	}
 	return
 }
+
+// Compress compresses the work memory of the PLC with the PI service _GARB, closing the gaps which deleted and
+// downloaded blocks leave. It waits at most timeout for the PLC to finish, 0 waits until the deadline of the context
+// of the call or 2 minutes without one.
+func (mb *client) Compress(timeout time.Duration) error {
+	return mb.piServiceDone(s7CompressTelegram, timeout, errCliCannotCompress)
+}
+
+// CopyRamToRom copies the work memory of the PLC into the load memory with the PI service _MODU, so that the
+// program survives a memory reset (S7-300/400). It waits at most timeout for the PLC to finish, 0 waits until
+// the deadline of the context of the call or 2 minutes without one.
+func (mb *client) CopyRamToRom(timeout time.Duration) error {
+	return mb.piServiceDone(s7CopyRamToRomTelegram, timeout, errCliCannotCopyRAMToRom)
+}
+
+// piServiceDone runs a PI service which keeps the PLC busy for a while. The PLC answers when the service is done,
+// while it is busy with it the request is repeated until timeout is over.
+func (mb *client) piServiceDone(telegram []byte, timeout time.Duration, refused int) error {
+	ctx := mb.context()
+	if _, ok := ctx.Deadline(); timeout <= 0 && !ok {
+		// every request gets the timeout of the transporter, a busy PLC would be polled forever
+		timeout = piServiceTimeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	c := mb.withContext(ctx)
+	for {
+		requestData := make([]byte, len(telegram))
+		copy(requestData, telegram)
+		request := NewProtocolDataUnit(requestData)
+		response, err := c.send(&request)
+		if err == nil && len(response.Data) >= 19 && response.Data[17] == errClassNoResources {
+			timer := time.NewTimer(piPollInterval)
+			select {
+			case <-timer.C:
+				continue
+			case <-ctx.Done():
+				timer.Stop()
+				err = ctx.Err()
+			}
+		}
+		if err != nil {
+			if err == context.DeadlineExceeded && mb.context().Err() == nil {
+				// the timeout of the service is over, not the one of the caller
+				err = fmt.Errorf(ErrorText(errCliJobTimeout))
+			}
+			return err
+		}
+		return piServiceError(response.Data, refused)
+	}
+}
+
+// piServiceError returns the error of the reply to a PI service, a missing password or block is told apart,
+// any other failure is refused
+func piServiceError(response []byte, refused int) error {
+	if len(response) < 19 {
+		return fmt.Errorf(ErrorText(errIsoInvalidPDU))
+	}
+	code := CPUError(uint(binary.BigEndian.Uint16(response[17:])))
+	switch {
+	case code == errCliNeedPassword || code == errCliItemNotAvailable:
+		return fmt.Errorf(ErrorText(code))
+	case code != 0 || len(response) < 20 || response[19] != pduControl:
+		return fmt.Errorf(ErrorText(refused))
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// busyTransporter answers PI services busy for the first busy requests, then with errCode
+type busyTransporter struct {
+	busy     int
+	errCode  uint16
+	requests int
+	services []string
+}
+
+func (t *busyTransporter) Send(request []byte) ([]byte, error) {
+	t.requests++
+	name, _, _ := piService(request)
+	t.services = append(t.services, name)
+	if t.requests <= t.busy {
+		return s7Reply(request, 3, errClassNoResources<<8|0x04, nil, nil), nil
+	}
+	if t.errCode != 0 {
+		return s7Reply(request, 3, t.errCode, nil, nil), nil
+	}
+	return s7Reply(request, 3, 0, []byte{pduControl}, nil), nil
+}
+
+func TestCompress(t *testing.T) {
+	transporter := &busyTransporter{busy: 2}
+	client := NewClient2(&tcpPackager{}, transporter)
+	if err := client.Compress(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if transporter.requests != 3 || transporter.services[0] != "_GARB" {
+		t.Fatalf("unexpected requests %d: %v", transporter.requests, transporter.services)
+	}
+	transporter = &busyTransporter{busy: 100}
+	client = NewClient2(&tcpPackager{}, transporter)
+	if err := client.Compress(250 * time.Millisecond); err == nil || err.Error() != ErrorText(errCliJobTimeout) {
+		t.Fatalf("unexpected error of a busy PLC: %v", err)
+	}
+	// without a timeout the deadline of the context ends the polling
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	if err := client.(ClientContext).CompressContext(ctx, 0); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error of a busy PLC: %v", err)
+	}
+}
+
+func TestCopyRamToRom(t *testing.T) {
+	transporter := &busyTransporter{}
+	client := NewClient2(&tcpPackager{}, transporter)
+	if err := client.CopyRamToRom(time.Second); err != nil || transporter.services[0] != "_MODU" {
+		t.Fatalf("unexpected services %v: %v", transporter.services, err)
+	}
+	client = NewClient2(&tcpPackager{}, &busyTransporter{errCode: 0xD00C})
+	if err := client.CopyRamToRom(time.Second); err == nil || err.Error() != ErrorText(errCliCannotCopyRAMToRom) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client = NewClient2(&tcpPackager{}, &busyTransporter{errCode: code7NeedPassword})
+	if err := client.CopyRamToRom(time.Second); err == nil || err.Error() != ErrorText(errCliNeedPassword) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
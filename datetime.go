@@ -16,6 +16,9 @@ func (mb *client) PGClockWrite() (datetime time.Time, err error) {
 	request := NewProtocolDataUnit(requestData)
 	//send
 	response, err := mb.send(&request)
+	if err != nil {
+		return
+	}
 	if length := len(response.Data); length > 30 {
 		if (binary.BigEndian.Uint16(response.Data[27:]) == 0) && (response.Data[29] == 0xFF) {
 			var s7 Helper
@@ -35,11 +38,16 @@ func (mb *client) PGClockRead(datetime time.Time) (err error) {
 	requestData := make([]byte, len(s7SetDatetimeTelegram))
 	copy(requestData, s7SetDatetimeTelegram)
 	var s7 Helper
-	s7.SetDateTimeAt(requestData, 32, datetime)
+	//century at 30, the 8 bytes DATE_AND_TIME from 31 on
+	requestData[30] = encodeBcd(datetime.Year() / 100)
+	s7.SetDateTimeAt(requestData, 31, datetime)
 
 	request := NewProtocolDataUnit(requestData)
 	//send
 	response, err := mb.send(&request)
+	if err != nil {
+		return
+	}
 	if length := len(response.Data); length > 30 {
 		if binary.BigEndian.Uint16(response.Data[27:]) != 0 {
 			err = fmt.Errorf(ErrorText(errCliInvalidPlcAnswer))
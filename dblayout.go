@@ -0,0 +1,367 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The variables of a DB are laid out by the S7 classic rules (as for Marshal): BOOLs are packed into bits,
+// byte types start at the next byte, all other types, STRUCTs and ARRAYs start at an even byte, STRUCTs and
+// ARRAYs take an even number of bytes. MC7 interfaces carry no names, so the variables are typed but
+// nameless until a STEP 7 source of the DB is merged.
+
+// layoutTypes are the types of block interfaces in addition to s7Types
+var layoutTypes = map[string]s7Type{
+	"POINTER":   {name: "POINTER", bits: 48, align: 16},
+	"ANY":       {name: "ANY", bits: 80, align: 16},
+	"BLOCK_FB":  {name: "BLOCK_FB", bits: 16, align: 16},
+	"BLOCK_FC":  {name: "BLOCK_FC", bits: 16, align: 16},
+	"BLOCK_DB":  {name: "BLOCK_DB", bits: 16, align: 16},
+	"BLOCK_SDB": {name: "BLOCK_SDB", bits: 16, align: 16},
+	"COUNTER":   {name: "COUNTER", bits: 16, align: 16},
+	"TIMER":     {name: "TIMER", bits: 16, align: 16},
+}
+
+// DBLayout is the layout of the variables of a data block, see NewDBLayout and ParseSource
+type DBLayout struct {
+	DBNumber int
+	// Name is the name of the block in a source, such as "DB 10", "UDT 1" or the symbol of the block
+	Name string
+	// Size is the number of bytes of the data block
+	Size int
+	// Variables are the top level variables in the order of the interface
+	Variables []DBVariable
+}
+
+// DBVariable is a variable of a data block with its offset and type
+type DBVariable struct {
+	Name    string // symbol name of a merged source, empty otherwise
+	Comment string // comment of a merged source
+	Kind    int    // MC7In, MC7Out, MC7InOut or MC7Stat (instance DBs)
+	Type    string // type in STEP 7 notation, such as REAL, STRING[20], ARRAY[1..4] OF INT or STRUCT
+	Offset  int    // byte offset in the data block
+	Bit     int    // bit of a BOOL
+	Size    int    // number of bytes taken, 1 for a BOOL, 6 for the POINTER of an IN_OUT STRING, DT, ARRAY or STRUCT
+	// Address is the address of elementary variables and of ARRAYs of elementary types, such as
+	// DB1.DBX2.3, DB1.DBD4 or DB1.DBW8[10], for Client.Read. Other types have a zero Area.
+	Address S7Address
+	// Bounds are the bounds of the dimensions of an ARRAY
+	Bounds [][2]int
+	// Element is the first element of an ARRAY, see Elements
+	Element *DBVariable
+	// Members are the members of a STRUCT
+	Members []DBVariable
+}
+
+// NewDBLayout computes the layout of a DB from the interface of its MC7 block, as decoded by ParseMC7
+func NewDBLayout(block *MC7Block) (*DBLayout, error) {
+	if block.BlkType != S7BlockDB {
+		return nil, fmt.Errorf("s7: block type 0x%02X is not a DB", block.BlkType)
+	}
+	l := &DBLayout{DBNumber: block.BlkNumber}
+	pos := 0
+	for _, param := range block.Interface {
+		v, end, err := layoutVariable(param, l.DBNumber, pos)
+		if err != nil {
+			return nil, err
+		}
+		l.Variables = append(l.Variables, v)
+		pos = end
+	}
+	l.Size = alignBits(pos, 16) / 8
+	if l.Size != len(block.Body) {
+		return nil, fmt.Errorf("s7: interface of DB%d takes %d bytes, the block holds %d", l.DBNumber, l.Size, len(block.Body))
+	}
+	return l, nil
+}
+
+// layoutVariable places a parameter at the bit position pos, it returns the variable and the bit position after it
+func layoutVariable(p MC7Parameter, db int, pos int) (v DBVariable, end int, err error) {
+	v = DBVariable{Kind: p.Kind, Type: p.String()}
+	if passedByPointer(p) {
+		// the instance DB holds a POINTER to the actual parameter
+		p = MC7Parameter{Kind: p.Kind, Type: "POINTER"}
+	}
+	switch p.Type {
+	case "STRUCT":
+		pos = alignBits(pos, 16)
+		end = pos
+		for _, m := range p.Members {
+			var member DBVariable
+			if member, end, err = layoutVariable(m, db, end); err != nil {
+				return
+			}
+			v.Members = append(v.Members, member)
+		}
+		end = alignBits(end, 16)
+	case "ARRAY":
+		if p.Element == nil {
+			return v, pos, fmt.Errorf("s7: %s without element", v.Type)
+		}
+		pos = alignBits(pos, 16)
+		var element DBVariable
+		var elementEnd int
+		if element, elementEnd, err = layoutVariable(*p.Element, db, pos); err != nil {
+			return
+		}
+		v.Bounds = p.Bounds
+		v.Element = &element
+		count := v.count()
+		end = alignBits(pos+count*(elementEnd-pos), 16)
+		if a := element.Address; a.Area != 0 && a.Amount == 1 {
+			v.Address = a
+			v.Address.Amount = count
+		}
+	default:
+		var typ *s7Type
+		if typ, err = layoutType(p); err != nil {
+			return
+		}
+		pos = alignBits(pos, typ.align)
+		end = pos + typ.bits
+		v.Address = elementaryAddress(typ, db, pos)
+	}
+	v.Offset, v.Bit = pos/8, pos%8
+	v.Size = (end - pos + 7) / 8
+	if end > maxAddressOffset*8 {
+		return v, end, fmt.Errorf("s7: %s at byte %d exceeds the data block", v.Type, v.Offset)
+	}
+	return
+}
+
+// passedByPointer tells whether the parameter is an IN_OUT of a type that is passed as a POINTER
+// to the actual parameter: STRING, DATE_AND_TIME, ARRAY and STRUCT (also UDT)
+func passedByPointer(p MC7Parameter) bool {
+	if p.Kind != MC7InOut {
+		return false
+	}
+	switch p.Type {
+	case "STRING", "DATE_AND_TIME", "ARRAY", "STRUCT":
+		return true
+	}
+	return false
+}
+
+// layoutType returns the elementary type of a parameter
+func layoutType(p MC7Parameter) (*s7Type, error) {
+	if typ, ok := layoutTypes[p.Type]; ok {
+		return &typ, nil
+	}
+	name := p.Type
+	if p.Type == "STRING" || p.Type == "WSTRING" {
+		name += "[" + strconv.Itoa(p.Length) + "]"
+	}
+	typ, err := lookupS7Type(name)
+	if err != nil {
+		return nil, fmt.Errorf("s7: %v", err)
+	}
+	return typ, nil
+}
+
+// elementaryAddress returns the DB address of a variable of an elementary type at the bit position pos
+func elementaryAddress(typ *s7Type, db int, pos int) S7Address {
+	a := S7Address{Area: s7areadb, DBNumber: db, Start: pos / 8, Amount: 1}
+	switch {
+	case typ.bits == 1:
+		a.WordLen, a.Bit = s7wlbit, pos%8
+	case typ.name == "STRING":
+		a.WordLen, a.Length = s7wlbyte, typ.length
+	case typ.bits == 8:
+		a.WordLen = s7wlbyte
+	case typ.bits == 16:
+		a.WordLen = s7wlword
+	case typ.bits == 32:
+		a.WordLen = s7wldword
+	default:
+		// DATE_AND_TIME, POINTER, ANY, WSTRING, ... are read as bytes
+		a.WordLen, a.Amount = s7wlbyte, typ.bits/8
+	}
+	return a
+}
+
+// count returns the number of elements of an ARRAY
+func (v DBVariable) count() int {
+	count := 1
+	for _, b := range v.Bounds {
+		count *= b[1] - b[0] + 1
+	}
+	return count
+}
+
+// Elements returns all elements of an ARRAY at their offsets, named by their indexes such as "Values[1,0]"
+func (v DBVariable) Elements() []DBVariable {
+	if v.Element == nil {
+		return nil
+	}
+	elements := make([]DBVariable, v.count())
+	stride := 8 * v.Element.Size
+	if v.Element.Type == "BOOL" {
+		stride = 1
+	}
+	index := make([]int, len(v.Bounds))
+	for i := range v.Bounds {
+		index[i] = v.Bounds[i][0]
+	}
+	for i := range elements {
+		e := v.Element.shift(i * stride)
+		names := make([]string, len(index))
+		for j, n := range index {
+			names[j] = strconv.Itoa(n)
+		}
+		e.Name = v.Name + "[" + strings.Join(names, ",") + "]"
+		elements[i] = e
+		// the last index runs fastest
+		for j := len(index) - 1; j >= 0; j-- {
+			if index[j]++; index[j] <= v.Bounds[j][1] {
+				break
+			}
+			index[j] = v.Bounds[j][0]
+		}
+	}
+	return elements
+}
+
+// shift returns a copy of the variable moved by bits
+func (v DBVariable) shift(bits int) DBVariable {
+	pos := v.Offset*8 + v.Bit + bits
+	v.Offset, v.Bit = pos/8, pos%8
+	if v.Address.Area != 0 {
+		pos = v.Address.Start*8 + v.Address.Bit + bits
+		v.Address.Start, v.Address.Bit = pos/8, pos%8
+	}
+	if v.Element != nil {
+		element := v.Element.shift(bits)
+		v.Element = &element
+	}
+	if v.Members != nil {
+		members := make([]DBVariable, len(v.Members))
+		for i, m := range v.Members {
+			members[i] = m.shift(bits)
+		}
+		v.Members = members
+	}
+	return v
+}
+
+// Elementary returns the variables of elementary types in the order of their offsets, members and elements
+// are named by their path, such as "Motor.Speed" or "Values[2]"
+func (l *DBLayout) Elementary() []DBVariable {
+	var variables []DBVariable
+	var walk func(v DBVariable)
+	walk = func(v DBVariable) {
+		switch {
+		case v.Element != nil:
+			for _, e := range v.Elements() {
+				walk(e)
+			}
+		case v.Type == "STRUCT":
+			for _, m := range v.Members {
+				if v.Name != "" {
+					m.Name = v.Name + "." + m.Name
+				}
+				walk(m)
+			}
+		default:
+			variables = append(variables, v)
+		}
+	}
+	for _, v := range l.Variables {
+		walk(v)
+	}
+	return variables
+}
+
+// SetDBNumber moves the layout to another DB, e.g. a DB named by its symbol in a source
+func (l *DBLayout) SetDBNumber(dbNumber int) {
+	l.DBNumber = dbNumber
+	for i := range l.Variables {
+		l.Variables[i].setDBNumber(dbNumber)
+	}
+}
+
+func (v *DBVariable) setDBNumber(dbNumber int) {
+	if v.Address.Area != 0 {
+		v.Address.DBNumber = dbNumber
+	}
+	if v.Element != nil {
+		v.Element.setDBNumber(dbNumber)
+	}
+	for i := range v.Members {
+		v.Members[i].setDBNumber(dbNumber)
+	}
+}
+
+// MergeSource names the variables after the declarations of the DB in a STEP 7 source (.db, .awl or .scl).
+// The source holds the DB by number ("DATA_BLOCK DB 10") or is the only DB of the source; UDTs and the FB
+// of an instance DB are resolved from the same source. The declarations must match the interface.
+func (l *DBLayout) MergeSource(src string) error {
+	blocks, err := parseSource(src)
+	if err != nil {
+		return err
+	}
+	var db *sourceBlock
+	count := 0
+	for i, block := range blocks {
+		if block.kind != "DATA_BLOCK" {
+			continue
+		}
+		count++
+		if block.name == "DB "+strconv.Itoa(l.DBNumber) || db == nil {
+			db = &blocks[i]
+		}
+	}
+	if db == nil || count > 1 && db.name != "DB "+strconv.Itoa(l.DBNumber) {
+		return fmt.Errorf("s7: DB%d is not in the source", l.DBNumber)
+	}
+	decl, err := resolveSource(db.decl, blocks, 0)
+	if err != nil {
+		return err
+	}
+	if decl.typ != "STRUCT" {
+		return &sourceError{decl.line, "DB of type " + decl.typ}
+	}
+	return mergeVariables(l.Variables, decl.members, "")
+}
+
+// mergeVariables names the variables after the declarations
+func mergeVariables(variables []DBVariable, decls []sourceDecl, path string) error {
+	if len(variables) != len(decls) {
+		return fmt.Errorf("s7: source declares %d variables in %q, the interface %d", len(decls), path, len(variables))
+	}
+	for i := range variables {
+		v, decl := &variables[i], decls[i]
+		name := decl.name
+		if path != "" {
+			name = path + "." + name
+		}
+		if t := decl.parameter().String(); t != v.Type {
+			return &sourceError{decl.line, fmt.Sprintf("%s is %s in the source but %s in the interface", name, t, v.Type)}
+		}
+		v.Name, v.Comment = decl.name, decl.comment
+		if passedByPointer(decl.parameter()) {
+			continue
+		}
+		if v.Element != nil {
+			if err := mergeElement(v.Element, *decl.elem, name); err != nil {
+				return err
+			}
+		}
+		if err := mergeVariables(v.Members, decl.members, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeElement names the members of the element of an ARRAY
+func mergeElement(element *DBVariable, decl sourceDecl, path string) error {
+	path += "[]"
+	if element.Element != nil {
+		return mergeElement(element.Element, *decl.elem, path)
+	}
+	return mergeVariables(element.Members, decl.members, path)
+}
@@ -0,0 +1,258 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"strings"
+	"testing"
+)
+
+const testDBSource = `TYPE "MotorType"
+  STRUCT
+   Running : BOOL ;
+   Name : STRING  [20 ] := 'M1';	//display name
+  END_STRUCT ;
+END_TYPE
+
+DATA_BLOCK DB 3
+TITLE = test values
+{ S7_language := '7(1) Englisch (USA)' }
+AUTHOR : gos7
+VERSION : 0.1
+
+  STRUCT
+   Speed : REAL ;	//set point
+   On : BOOL ;
+   Fault : BOOL  := TRUE;
+   Motor : "MotorType";
+   Values : ARRAY  [1 .. 3 ] OF INT ;
+   Mode : BYTE  := B#16#1;
+   Bits : ARRAY  [0 .. 9 ] OF BOOL ;
+   Stamp : DT ;
+  END_STRUCT ;
+BEGIN
+   Speed := 1.500000e+000;
+END_DATA_BLOCK
+`
+
+func testDBLayout(t *testing.T) *DBLayout {
+	params := []byte{
+		0x08, MC7Stat, // REAL
+		0x01, MC7Stat, // BOOL
+		0x01, MC7Stat | mc7StartValue, // BOOL
+		0x11, MC7Stat, 2, 0x01, MC7Stat, 0x13, MC7Stat | mc7StartValue, 20, // STRUCT BOOL, STRING[20] END_STRUCT
+		0x10, MC7Stat, 1, 1, 0, 3, 0, 0x05, MC7Stat, // ARRAY[1..3] OF INT
+		0x02, MC7Stat | mc7StartValue, // BYTE
+		0x10, MC7Stat, 1, 0, 0, 9, 0, 0x01, MC7Stat, // ARRAY[0..9] OF BOOL
+		0x0E, MC7Stat, // DATE_AND_TIME
+	}
+	b, err := ParseMC7(mc7Block(S7BlockDB, 3, make([]byte, 48), params, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewDBLayout(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestDBLayout(t *testing.T) {
+	l := testDBLayout(t)
+	if l.DBNumber != 3 || l.Size != 48 || len(l.Variables) != 8 {
+		t.Fatalf("unexpected layout %+v", l)
+	}
+	expected := []struct {
+		typ    string
+		offset int
+		bit    int
+		size   int
+	}{{"REAL", 0, 0, 4}, {"BOOL", 4, 0, 1}, {"BOOL", 4, 1, 1}, {"STRUCT", 6, 0, 24}, {"ARRAY[1..3] OF INT", 30, 0, 6},
+		{"BYTE", 36, 0, 1}, {"ARRAY[0..9] OF BOOL", 38, 0, 2}, {"DATE_AND_TIME", 40, 0, 8}}
+	for i, e := range expected {
+		if v := l.Variables[i]; v.Type != e.typ || v.Offset != e.offset || v.Bit != e.bit || v.Size != e.size {
+			t.Errorf("variable %d: %s at %d.%d (%d bytes) instead of %s at %d.%d (%d bytes)",
+				i, v.Type, v.Offset, v.Bit, v.Size, e.typ, e.offset, e.bit, e.size)
+		}
+	}
+	if a := l.Variables[4].Address.String(); a != "DB3.DBW30[3]" {
+		t.Errorf("unexpected array address %s", a)
+	}
+	if a := l.Variables[3].Address; a.Area != 0 {
+		t.Errorf("unexpected STRUCT address %v", a)
+	}
+	addresses := []string{"DB3.DBD0", "DB3.DBX4.0", "DB3.DBX4.1", "DB3.DBX6.0", "DB3.DBSTRING8.20", "DB3.DBW30", "DB3.DBW32",
+		"DB3.DBW34", "DB3.DBB36"}
+	for i := 0; i < 10; i++ {
+		addresses = append(addresses, "DB3.DBX"+map[bool]string{true: "38.", false: "39."}[i < 8]+string(rune('0'+i%8)))
+	}
+	addresses = append(addresses, "DB3.DBB40[8]")
+	elementary := l.Elementary()
+	if len(elementary) != len(addresses) {
+		t.Fatalf("%d elementary variables instead of %d", len(elementary), len(addresses))
+	}
+	for i, a := range addresses {
+		if s := elementary[i].Address.String(); s != a {
+			t.Errorf("variable %d: %s instead of %s", i, s, a)
+		}
+	}
+}
+
+func TestDBLayoutMergeSource(t *testing.T) {
+	l := testDBLayout(t)
+	if err := l.MergeSource(testDBSource); err != nil {
+		t.Fatal(err)
+	}
+	if v := l.Variables[0]; v.Name != "Speed" || v.Comment != "set point" {
+		t.Errorf("unexpected variable %+v", v)
+	}
+	names := []string{"Speed", "On", "Fault", "Motor.Running", "Motor.Name", "Values[1]", "Values[2]", "Values[3]", "Mode"}
+	elementary := l.Elementary()
+	for i, name := range names {
+		if elementary[i].Name != name {
+			t.Errorf("variable %d: %q instead of %q", i, elementary[i].Name, name)
+		}
+	}
+	if v := elementary[4]; v.Comment != "display name" || v.Address.String() != "DB3.DBSTRING8.20" {
+		t.Errorf("unexpected variable %+v", v)
+	}
+	if v := elementary[len(elementary)-1]; v.Name != "Stamp" {
+		t.Errorf("unexpected last variable %+v", v)
+	}
+
+	errors := map[string]string{
+		strings.Replace(testDBSource, "Speed : REAL", "Speed : INT", 1):          "Speed is INT in the source but REAL",
+		strings.Replace(testDBSource, "Mode : BYTE  := B#16#1;", "", 1):          "source declares 7 variables",
+		strings.Replace(testDBSource, "DB 3", "DB 4", 1):                         "", // the only DB of the source
+		strings.Replace(testDBSource, `Motor : "MotorType"`, `Motor : UDT 5`, 1): "UDT 5 is not declared",
+		strings.Replace(testDBSource, "END_STRUCT ;\nBEGIN", "BEGIN", 1):         "line 24: expected :",
+	}
+	for src, expected := range errors {
+		err := testDBLayout(t).MergeSource(src)
+		if expected == "" && err != nil || expected != "" && (err == nil || !strings.Contains(err.Error(), expected)) {
+			t.Errorf("error %v, expected %q", err, expected)
+		}
+	}
+}
+
+func TestDBLayoutInstanceDB(t *testing.T) {
+	src := `FUNCTION_BLOCK FB 1
+TITLE =
+VERSION : 0.1
+
+VAR_INPUT
+  Start : BOOL ;
+END_VAR
+VAR_OUTPUT
+  Count : INT ;
+END_VAR
+VAR_TEMP
+  Scratch : DINT ;
+END_VAR
+BEGIN
+NETWORK
+TITLE =
+      U     #Start;
+      =     L      0.0;
+END_FUNCTION_BLOCK
+
+DATA_BLOCK DB 4 FB 1
+BEGIN
+   Start := FALSE;
+END_DATA_BLOCK
+`
+	params := []byte{0x01, MC7In, 0x05, MC7Out}
+	b, err := ParseMC7(mc7Block(S7BlockDB, 4, make([]byte, 4), params, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewDBLayout(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.MergeSource(src); err != nil {
+		t.Fatal(err)
+	}
+	if v := l.Variables[1]; v.Name != "Count" || v.Kind != MC7Out || v.Address.String() != "DB4.DBW2" {
+		t.Errorf("unexpected variable %+v", v)
+	}
+}
+
+func TestDBLayoutErrors(t *testing.T) {
+	b, err := ParseMC7(mc7Block(S7BlockDB, 1, make([]byte, 4), []byte{0x05, MC7Stat}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewDBLayout(b); err == nil || !strings.Contains(err.Error(), "takes 2 bytes, the block holds 4") {
+		t.Errorf("unexpected error %v", err)
+	}
+	b.BlkType = S7BlockFC
+	if _, err = NewDBLayout(b); err == nil {
+		t.Error("expected an error for an FC")
+	}
+}
+
+func TestDBLayoutInstanceDBInOut(t *testing.T) {
+	src := `FUNCTION_BLOCK FB 2
+VERSION : 0.1
+
+VAR_INPUT
+  Speed : INT ;
+END_VAR
+VAR_IN_OUT
+  Text : STRING [20];
+  Level : INT ;
+  Stamp : DATE_AND_TIME ;
+END_VAR
+VAR
+  Count : INT ;
+END_VAR
+BEGIN
+NETWORK
+TITLE =
+END_FUNCTION_BLOCK
+
+DATA_BLOCK DB 5 FB 2
+BEGIN
+END_DATA_BLOCK
+`
+	// IN_OUT STRING and DATE_AND_TIME are passed as POINTER, 6 bytes in the instance DB
+	expected := []struct {
+		name   string
+		typ    string
+		offset int
+		size   int
+	}{{"Speed", "INT", 0, 2}, {"Text", "STRING[20]", 2, 6}, {"Level", "INT", 8, 2}, {"Stamp", "DATE_AND_TIME", 10, 6},
+		{"Count", "INT", 16, 2}}
+	check := func(l *DBLayout) {
+		if l.Size != 18 || len(l.Variables) != len(expected) {
+			t.Fatalf("unexpected layout %+v", l)
+		}
+		for i, e := range expected {
+			if v := l.Variables[i]; v.Name != e.name || v.Type != e.typ || v.Offset != e.offset || v.Size != e.size {
+				t.Errorf("variable %d: %s %s at %d (%d bytes) instead of %s %s at %d (%d bytes)",
+					i, v.Name, v.Type, v.Offset, v.Size, e.name, e.typ, e.offset, e.size)
+			}
+		}
+	}
+	layouts, err := ParseSource(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(layouts[0])
+
+	params := []byte{0x05, MC7In, 0x13, MC7InOut, 20, 0x05, MC7InOut, 0x0E, MC7InOut, 0x05, MC7Stat}
+	b, err := ParseMC7(mc7Block(S7BlockDB, 5, make([]byte, 18), params, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewDBLayout(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.MergeSource(src); err != nil {
+		t.Fatal(err)
+	}
+	check(l)
+}
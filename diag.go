@@ -0,0 +1,172 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// szlDiagRecord is the length of an entry of the diagnostic buffer (SZL 0x00A0)
+const szlDiagRecord = 20
+
+// DiagEvent is an entry of the diagnostic buffer of the CPU. The entries have the layout of the start
+// information of an OB: event ID, priority class, OB number, the additional info DatID, Info1 and Info2
+// and the DATE_AND_TIME of the event, see SZL-ID W#16#00A0 in "System Software for S7-300/400 System and Standard Functions".
+type DiagEvent struct {
+	EventID     uint16 // such as 0x4304, STOP by PG operation
+	Priority    int    // priority class
+	OBNumber    int
+	DatID       uint16 // additional info, meaning depends on the event
+	Info1       uint16
+	Info2       uint32
+	Time        time.Time // CPU clock, read as UTC
+	Description string    // English description of the event, or of its class if the event is unknown
+}
+
+// diagEventClasses names the event classes, the upper 4 bits of event IDs
+var diagEventClasses = map[uint16]string{
+	0x1: "standard OB event",
+	0x2: "synchronous error",
+	0x3: "asynchronous error",
+	0x4: "operating mode transition",
+	0x5: "run-time event",
+	0x6: "communication event",
+	0x7: "H/F system event",
+	0x8: "module diagnostic data",
+	0x9: "user event",
+	0xA: "user event",
+	0xB: "user event",
+}
+
+// diagEvents are the descriptions of common events
+var diagEvents = map[uint16]string{
+	0x1381: "Request for manual warm restart",
+	0x1382: "Request for automatic warm restart",
+	0x1383: "Request for manual hot restart",
+	0x1384: "Request for automatic hot restart",
+	0x1385: "Request for manual cold restart",
+	0x1386: "Request for automatic cold restart",
+	0x2521: "BCD conversion error",
+	0x2522: "Area length error when reading",
+	0x2523: "Area length error when writing",
+	0x2524: "Area error when reading",
+	0x2525: "Area error when writing",
+	0x2526: "Timer number error",
+	0x2527: "Counter number error",
+	0x2528: "Alignment error when reading",
+	0x2529: "Alignment error when writing",
+	0x2530: "Write error when accessing the DB",
+	0x2531: "Write error when accessing the DI",
+	0x2532: "Block number error when opening a DB",
+	0x2533: "Block number error when opening a DI",
+	0x2534: "Block number error when calling an FC",
+	0x2535: "Block number error when calling an FB",
+	0x253A: "DB not loaded",
+	0x253C: "FC not loaded",
+	0x253D: "SFC not loaded",
+	0x253E: "FB not loaded",
+	0x253F: "SFB not loaded",
+	0x2942: "I/O access error, reading",
+	0x2943: "I/O access error, writing",
+	0x3501: "Cycle time exceeded",
+	0x3502: "User interface (OB or FRB) request error",
+	0x3505: "Time-of-day interrupt(s) skipped due to new clock setting",
+	0x3507: "Multiple OB request errors caused internal buffer overflow",
+	0x4301: "Mode transition from STOP to STARTUP",
+	0x4302: "Mode transition from STARTUP to RUN",
+	0x4303: "STOP caused by stop switch being activated",
+	0x4304: "STOP caused by PG STOP operation or by SFB 20 STOP",
+	0x4305: "HOLD: breakpoint reached",
+	0x4306: "HOLD: breakpoint exited",
+	0x4307: "Memory reset started by PG operation",
+	0x4308: "Memory reset started by switch setting",
+	0x4309: "Memory reset started automatically (power on not backed up)",
+	0x430D: "STOP caused by other CPU in multicomputing",
+	0x4520: "DEFECTIVE: STOP not possible",
+	0x4521: "DEFECTIVE: failure of instruction processing processor",
+	0x4562: "STOP caused by programming error (OB not loaded or not possible)",
+	0x4563: "STOP caused by I/O access error (OB not loaded or not possible)",
+	0x4567: "STOP caused by H event",
+	0x4568: "STOP caused by time error (OB not loaded or not possible)",
+	0x456A: "STOP caused by diagnostic interrupt (OB not loaded or not possible)",
+	0x456B: "STOP caused by removing/inserting module (OB not loaded or not possible)",
+	0x456C: "STOP caused by CPU hardware error (OB not loaded or not possible)",
+	0x456D: "STOP caused by program sequence error (OB not loaded or not possible)",
+	0x456E: "STOP caused by communication error (OB not loaded or not possible)",
+	0x456F: "STOP caused by rack failure (OB not loaded or not possible)",
+	0x4571: "STOP caused by nesting stack error",
+	0x4572: "STOP caused by master control relay stack error",
+	0x457F: "STOP caused by STOP command",
+	0x4580: "STOP: back-up buffer contents inconsistent (no transition to RUN)",
+	0x4590: "STOP caused by overloading the internal functions",
+}
+
+// DiagEventText returns the English description of an event ID, for unknown events the name of their class
+func DiagEventText(eventID uint16) string {
+	if text, ok := diagEvents[eventID]; ok {
+		return text
+	}
+	if class, ok := diagEventClasses[eventID>>12]; ok {
+		return fmt.Sprintf("%s (event 16#%04X)", class, eventID)
+	}
+	return fmt.Sprintf("event 16#%04X", eventID)
+}
+
+// decodeDiagEvent decodes an entry of the diagnostic buffer
+func decodeDiagEvent(record []byte) DiagEvent {
+	var helper Helper
+	e := DiagEvent{
+		EventID:  binary.BigEndian.Uint16(record),
+		Priority: int(record[2]),
+		OBNumber: int(record[3]),
+		DatID:    binary.BigEndian.Uint16(record[4:]),
+		Info1:    binary.BigEndian.Uint16(record[6:]),
+		Info2:    binary.BigEndian.Uint32(record[8:]),
+		Time:     helper.GetDateTimeAt(record, 12),
+	}
+	e.Description = DiagEventText(e.EventID)
+	return e
+}
+
+// encodeDiagEvent encodes an entry of the diagnostic buffer as the server answers it
+func encodeDiagEvent(e DiagEvent) []byte {
+	var helper Helper
+	record := make([]byte, szlDiagRecord)
+	binary.BigEndian.PutUint16(record, e.EventID)
+	record[2] = byte(e.Priority)
+	record[3] = byte(e.OBNumber)
+	binary.BigEndian.PutUint16(record[4:], e.DatID)
+	binary.BigEndian.PutUint16(record[6:], e.Info1)
+	binary.BigEndian.PutUint32(record[8:], e.Info2)
+	helper.SetDateTimeAt(record, 12, e.Time)
+	return record
+}
+
+// GetDiagnosticBuffer reads the diagnostic buffer of the CPU (SZL 0x00A0), the newest event first.
+// At most max events are returned, all if max is 0 or less.
+func (mb *client) GetDiagnosticBuffer(max int) (events []DiagEvent, err error) {
+	szl, size, err := mb.readSzl(0x00A0, 0x0000)
+	if err != nil {
+		return
+	}
+	recordLength := int(szl.Header.LengthHeader)
+	if recordLength < szlDiagRecord {
+		err = fmt.Errorf(ErrorText(errCliInvalidPlcAnswer))
+		return
+	}
+	count := size / recordLength
+	if n := int(szl.Header.NumberOfDataRecord); n < count {
+		count = n
+	}
+	if max > 0 && max < count {
+		count = max
+	}
+	events = make([]DiagEvent, count)
+	for i := range events {
+		events[i] = decodeDiagEvent(szl.Data[i*recordLength:])
+	}
+	return
+}
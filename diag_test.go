@@ -0,0 +1,68 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"testing"
+	"time"
+)
+
+func TestGetDiagnosticBuffer(t *testing.T) {
+	server := NewServer()
+	server.PDULength = 240
+	stamp := time.Date(2018, 3, 14, 15, 9, 26, 530000000, time.UTC)
+	server.DiagnosticBuffer = []DiagEvent{
+		{EventID: 0x4304, Priority: 255, OBNumber: 255, DatID: 0xC4FE, Info1: 0x0042, Info2: 0x01020304, Time: stamp},
+		{EventID: 0x2522, Priority: 1, OBNumber: 121, Time: stamp.Add(-time.Second)},
+		{EventID: 0x3942, Priority: 26, OBNumber: 82},
+	}
+	// more events than fit into a PDU, the client fetches the rest with follow-up requests
+	for i := 0; i < 27; i++ {
+		server.DiagnosticBuffer = append(server.DiagnosticBuffer, DiagEvent{EventID: 0x4302, Priority: 27, OBNumber: 100,
+			Time: stamp.Add(-time.Duration(i+2) * time.Minute)})
+	}
+	handler := startServer(t, server)
+	defer server.Close()
+	defer handler.Close()
+	client := NewClient(handler)
+
+	events, err := client.GetDiagnosticBuffer(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 30 {
+		t.Fatalf("%d events instead of 30", len(events))
+	}
+	e := events[0]
+	if e.EventID != 0x4304 || e.Priority != 255 || e.OBNumber != 255 || e.DatID != 0xC4FE || e.Info1 != 0x0042 ||
+		e.Info2 != 0x01020304 || !e.Time.Equal(stamp) || e.Description != "STOP caused by PG STOP operation or by SFB 20 STOP" {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if e := events[1]; e.OBNumber != 121 || e.Description != "Area length error when reading" {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if e := events[2]; e.Description != "asynchronous error (event 16#3942)" {
+		t.Errorf("unexpected description %q", e.Description)
+	}
+	if e := events[29]; e.EventID != 0x4302 || !e.Time.Equal(stamp.Add(-28*time.Minute)) {
+		t.Errorf("unexpected last event %+v", e)
+	}
+
+	if events, err = client.GetDiagnosticBuffer(2); err != nil || len(events) != 2 || events[1].EventID != 0x2522 {
+		t.Errorf("unexpected events %+v: %v", events, err)
+	}
+}
+
+func TestDiagEventText(t *testing.T) {
+	texts := map[uint16]string{
+		0x4302: "Mode transition from STARTUP to RUN",
+		0x8F23: "module diagnostic data (event 16#8F23)",
+		0xE001: "event 16#E001",
+	}
+	for id, expected := range texts {
+		if text := DiagEventText(id); text != expected {
+			t.Errorf("%04X: %q instead of %q", id, text, expected)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"fmt"
+	"time"
+	"unicode/utf16"
+)
+
+// Sizes in bytes of the S7 elementary types
+const (
+	sizeS7Int    = 2
+	sizeS7DInt   = 4
+	sizeS7Word   = 2
+	sizeS7Real   = 4
+	sizeS7DTL    = 12
+	sizeS7S5Time = 2
+)
+
+// ReadBool reads the bit at start.bit of an area (S7AreaDB, S7AreaMK, ...), dbNumber is only used for S7AreaDB
+func (mb *client) ReadBool(area int, dbNumber int, start int, bit int) (value bool, err error) {
+	if bit < 0 || bit > 7 {
+		err = fmt.Errorf(ErrorText(errCliInvalidParams))
+		return
+	}
+	buffer := make([]byte, 1)
+	if err = mb.readArea(area, dbNumber, start*8+bit, 1, s7wlbit, buffer); err == nil {
+		value = buffer[0] != 0
+	}
+	return
+}
+
+// WriteBool writes the bit at start.bit of an area without changing the other bits of the byte
+func (mb *client) WriteBool(area int, dbNumber int, start int, bit int, value bool) error {
+	if bit < 0 || bit > 7 {
+		return fmt.Errorf(ErrorText(errCliInvalidParams))
+	}
+	buffer := []byte{0}
+	if value {
+		buffer[0] = 1
+	}
+	return mb.writeArea(area, dbNumber, start*8+bit, 1, s7wlbit, buffer)
+}
+
+// ReadInt reads an S7 INT (16 bit signed)
+func (mb *client) ReadInt(area int, dbNumber int, start int) (value int16, err error) {
+	buffer := make([]byte, sizeS7Int)
+	if err = mb.readArea(area, dbNumber, start, sizeS7Int, s7wlbyte, buffer); err == nil {
+		var helper Helper
+		helper.GetValueAt(buffer, 0, &value)
+	}
+	return
+}
+
+// WriteInt writes an S7 INT (16 bit signed)
+func (mb *client) WriteInt(area int, dbNumber int, start int, value int16) error {
+	buffer := make([]byte, sizeS7Int)
+	var helper Helper
+	helper.SetValueAt(buffer, 0, value)
+	return mb.writeArea(area, dbNumber, start, sizeS7Int, s7wlbyte, buffer)
+}
+
+// ReadDInt reads an S7 DINT (32 bit signed)
+func (mb *client) ReadDInt(area int, dbNumber int, start int) (value int32, err error) {
+	buffer := make([]byte, sizeS7DInt)
+	if err = mb.readArea(area, dbNumber, start, sizeS7DInt, s7wlbyte, buffer); err == nil {
+		var helper Helper
+		helper.GetValueAt(buffer, 0, &value)
+	}
+	return
+}
+
+// WriteDInt writes an S7 DINT (32 bit signed)
+func (mb *client) WriteDInt(area int, dbNumber int, start int, value int32) error {
+	buffer := make([]byte, sizeS7DInt)
+	var helper Helper
+	helper.SetValueAt(buffer, 0, value)
+	return mb.writeArea(area, dbNumber, start, sizeS7DInt, s7wlbyte, buffer)
+}
+
+// ReadWord reads an S7 WORD (16 bit unsigned)
+func (mb *client) ReadWord(area int, dbNumber int, start int) (value uint16, err error) {
+	buffer := make([]byte, sizeS7Word)
+	if err = mb.readArea(area, dbNumber, start, sizeS7Word, s7wlbyte, buffer); err == nil {
+		var helper Helper
+		helper.GetValueAt(buffer, 0, &value)
+	}
+	return
+}
+
+// WriteWord writes an S7 WORD (16 bit unsigned)
+func (mb *client) WriteWord(area int, dbNumber int, start int, value uint16) error {
+	buffer := make([]byte, sizeS7Word)
+	var helper Helper
+	helper.SetValueAt(buffer, 0, value)
+	return mb.writeArea(area, dbNumber, start, sizeS7Word, s7wlbyte, buffer)
+}
+
+// ReadReal reads an S7 REAL (32 bit floating point)
+func (mb *client) ReadReal(area int, dbNumber int, start int) (value float32, err error) {
+	buffer := make([]byte, sizeS7Real)
+	if err = mb.readArea(area, dbNumber, start, sizeS7Real, s7wlbyte, buffer); err == nil {
+		var helper Helper
+		value = helper.GetRealAt(buffer, 0)
+	}
+	return
+}
+
+// WriteReal writes an S7 REAL (32 bit floating point)
+func (mb *client) WriteReal(area int, dbNumber int, start int, value float32) error {
+	buffer := make([]byte, sizeS7Real)
+	var helper Helper
+	helper.SetRealAt(buffer, 0, value)
+	return mb.writeArea(area, dbNumber, start, sizeS7Real, s7wlbyte, buffer)
+}
+
+// ReadString reads an S7 STRING[maxLen], which takes maxLen characters plus 2 bytes header (max and actual length)
+func (mb *client) ReadString(area int, dbNumber int, start int, maxLen int) (value string, err error) {
+	if maxLen <= 0 || maxLen > maxAddressStringLen {
+		err = fmt.Errorf(ErrorText(errCliInvalidParams))
+		return
+	}
+	buffer := make([]byte, maxLen+2)
+	if err = mb.readArea(area, dbNumber, start, len(buffer), s7wlbyte, buffer); err != nil {
+		return
+	}
+	if int(buffer[1]) > maxLen {
+		err = fmt.Errorf(ErrorText(errCliInvalidDataSizeRecvd))
+		return
+	}
+	var helper Helper
+	value = helper.GetStringAt(buffer, 0)
+	return
+}
+
+// WriteString writes an S7 STRING[maxLen], only the header and the characters of value are written
+func (mb *client) WriteString(area int, dbNumber int, start int, maxLen int, value string) error {
+	if maxLen <= 0 || maxLen > maxAddressStringLen || len(value) > maxLen {
+		return fmt.Errorf(ErrorText(errCliInvalidParams))
+	}
+	buffer := make([]byte, len(value)+2)
+	var helper Helper
+	helper.SetStringAt(buffer, 0, maxLen, value)
+	return mb.writeArea(area, dbNumber, start, len(buffer), s7wlbyte, buffer)
+}
+
+// ReadWString reads an S7 WSTRING[maxLen], which takes 2 bytes per UTF-16 character plus 4 bytes header
+func (mb *client) ReadWString(area int, dbNumber int, start int, maxLen int) (value string, err error) {
+	if maxLen <= 0 || maxLen > 16382 {
+		err = fmt.Errorf(ErrorText(errCliInvalidParams))
+		return
+	}
+	buffer := make([]byte, maxLen*2+4)
+	if err = mb.readArea(area, dbNumber, start, len(buffer), s7wlbyte, buffer); err != nil {
+		return
+	}
+	var helper Helper
+	var length int16
+	helper.GetValueAt(buffer, 2, &length)
+	if length < 0 || int(length) > maxLen {
+		err = fmt.Errorf(ErrorText(errCliInvalidDataSizeRecvd))
+		return
+	}
+	value = helper.GetWStringAt(buffer, 0)
+	return
+}
+
+// WriteWString writes an S7 WSTRING[maxLen], only the header and the characters of value are written
+func (mb *client) WriteWString(area int, dbNumber int, start int, maxLen int, value string) error {
+	chars := len(utf16.Encode([]rune(value)))
+	if maxLen <= 0 || maxLen > 16382 || chars > maxLen {
+		return fmt.Errorf(ErrorText(errCliInvalidParams))
+	}
+	buffer := make([]byte, chars*2+4)
+	var helper Helper
+	helper.SetWStringAt(buffer, 0, maxLen, value)
+	return mb.writeArea(area, dbNumber, start, len(buffer), s7wlbyte, buffer)
+}
+
+// ReadDTL reads an S7 DTL (S71200/1500 date and time, 12 bytes)
+func (mb *client) ReadDTL(area int, dbNumber int, start int) (value time.Time, err error) {
+	buffer := make([]byte, sizeS7DTL)
+	if err = mb.readArea(area, dbNumber, start, sizeS7DTL, s7wlbyte, buffer); err == nil {
+		var helper Helper
+		value = helper.GetDTLAt(buffer, 0)
+	}
+	return
+}
+
+// WriteDTL writes an S7 DTL (S71200/1500 date and time, 12 bytes)
+func (mb *client) WriteDTL(area int, dbNumber int, start int, value time.Time) error {
+	buffer := make([]byte, sizeS7DTL)
+	var helper Helper
+	helper.SetDTLAt(buffer, 0, value)
+	return mb.writeArea(area, dbNumber, start, sizeS7DTL, s7wlbyte, buffer)
+}
+
+// ReadS5Time reads an S7 S5TIME (BCD coded duration with time base)
+func (mb *client) ReadS5Time(area int, dbNumber int, start int) (value time.Duration, err error) {
+	buffer := make([]byte, sizeS7S5Time)
+	if err = mb.readArea(area, dbNumber, start, sizeS7S5Time, s7wlbyte, buffer); err == nil {
+		var helper Helper
+		value = helper.GetS5TimeAt(buffer, 0)
+	}
+	return
+}
+
+// WriteS5Time writes an S7 S5TIME, the duration is rounded to the time base which fits it (max 2h46m30s)
+func (mb *client) WriteS5Time(area int, dbNumber int, start int, value time.Duration) error {
+	if value < 0 || value.Milliseconds() >= 9990000 {
+		return fmt.Errorf(ErrorText(errCliInvalidValue))
+	}
+	buffer := make([]byte, sizeS7S5Time)
+	var helper Helper
+	helper.SetS5TimeAt(buffer, 0, value)
+	return mb.writeArea(area, dbNumber, start, sizeS7S5Time, s7wlbyte, buffer)
+}
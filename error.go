@@ -46,6 +46,9 @@ const (
 	errCliInvalidBlockType       = 0x01700000
 	errCliInvalidBlockNumber     = 0x01800000
 	errCliInvalidBlockSize       = 0x01900000
+	errCliDownloadSequenceFailed = 0x01A00000
+	errCliInsertRefused          = 0x01B00000
+	errCliDeleteRefused          = 0x01C00000
 	errCliNeedPassword           = 0x01D00000
 	errCliInvalidPassword        = 0x01E00000
 	errCliNoPasswordToSetOrClear = 0x01F00000
@@ -73,6 +76,14 @@ const (
 	code7DataOverPDU           = 34048
 )
 
+// Return codes of read/write var items, e.g. for a CPUErrorFault
+const (
+	S7CodeAddressOutOfRange     = code7AddressOutOfRange
+	S7CodeInvalidTransportSize  = code7InvalidTransportSize
+	S7CodeWriteDataSizeMismatch = code7WriteDataSizeMismatch
+	S7CodeItemNotAvailable      = code7ResItemNotAvailable
+)
+
 //ErrorText return a string error text from error code integer
 func ErrorText(err int) string {
 	switch err {
@@ -154,6 +165,12 @@ func ErrorText(err int) string {
 		return "CLI : Invalid block number"
 	case errCliInvalidBlockSize:
 		return "CLI : Invalid block size"
+	case errCliDownloadSequenceFailed:
+		return "CPU : Download sequence failed"
+	case errCliInsertRefused:
+		return "CPU : Insert refused"
+	case errCliDeleteRefused:
+		return "CPU : Delete refused"
 	case errCliNeedPassword:
 		return "CPU : Function not authorized for current protection level"
 	case errCliInvalidPassword:
@@ -209,5 +226,4 @@ func CPUError(err uint) int {
 	default:
 		return errCliFunctionRefused
 	}
-	return 0
 }
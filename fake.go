@@ -0,0 +1,70 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"fmt"
+	"sync"
+)
+
+// FakeClient is a Client working on in-process memory instead of a PLC, for unit tests of application code.
+// The requests of the client are answered by the embedded Server without a network, so the fake behaves
+// like a PLC: register the DB/M/I/Q/T/C byte areas with RegisterArea and the blocks with RegisterBlock,
+// program the answers with SetStatus, SetClock and CPUInfo, and emulate process logic with OnRead and OnWrite.
+// The server can also be started to let other clients share the memory. The calls with a context are available too.
+type FakeClient struct {
+	ClientContext
+	*Server
+}
+
+// NewFakeClient allocates a fake client, its PLC is in RUN mode without any memory
+func NewFakeClient() *FakeClient {
+	server := NewServer()
+	handler := &fakeHandler{conn: serverConn{server: server}}
+	return &FakeClient{ClientContext: NewClient(handler).(ClientContext), Server: server}
+}
+
+// fakeHandler hands the requests directly to a server connection, it implements ClientHandler and Session
+type fakeHandler struct {
+	tcpPackager
+	mu         sync.Mutex
+	conn       serverConn
+	negotiated bool
+}
+
+// negotiate sets up the communication on first use, so that PDULength may be set after NewFakeClient.
+// Caller must hold the mutex.
+func (h *fakeHandler) negotiate() {
+	if !h.negotiated {
+		h.conn.handle(append([]byte{}, s7PDUNegogiationTelegram...))
+		h.negotiated = true
+	}
+}
+
+// Send answers a request like a connected server does
+func (h *fakeHandler) Send(request []byte) (response []byte, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.negotiate()
+	if response = h.conn.handle(request); response == nil {
+		err = fmt.Errorf(ErrorText(errCliInvalidPlcAnswer))
+	}
+	return
+}
+
+// PDUSize returns the negotiated PDU length, implements Session.
+func (h *fakeHandler) PDUSize() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.negotiate()
+	return h.conn.pdu
+}
+
+// MaxParallelJobs returns the parallel jobs of the server, implements Session.
+func (h *fakeHandler) MaxParallelJobs() int {
+	if jobs := h.conn.server.MaxParallelJobs; jobs > 0 {
+		return jobs
+	}
+	return serverMaxParallelJobs
+}
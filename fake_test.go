@@ -0,0 +1,74 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFakeClient(t *testing.T) {
+	fake := NewFakeClient()
+	db := make([]byte, 8)
+	inputs := make([]byte, 2)
+	fake.RegisterArea(S7AreaDB, 1, db)
+	fake.RegisterArea(S7AreaPE, 0, inputs)
+	fake.RegisterBlock(S7BlockInfo{BlkType: S7BlockOB, BlkNumber: 1})
+	fake.CPUInfo.SerialNumber = "S C-FAKE"
+	fake.SetStatus(s7CpuStatusStop)
+	// process logic: the motor started with DB1.DBX0.0 reports running at I0.1
+	fake.OnWrite = func(area int, dbNumber int, start int, data []byte) {
+		if area == S7AreaDB && dbNumber == 1 && start == 0 {
+			inputs[0] = db[0] & 0x01 << 1
+		}
+	}
+	reads := 0
+	fake.OnRead = func(area int, dbNumber int, start int, data []byte) {
+		reads++
+	}
+
+	var client Client = fake
+	if err := client.WriteBool(S7AreaDB, 1, 0, 0, true); err != nil {
+		t.Fatal(err)
+	}
+	if running, err := client.ReadBool(S7AreaPE, 0, 0, 1); err != nil || !running || reads != 1 {
+		t.Fatalf("unexpected I0.1 %v after %d reads: %v", running, reads, err)
+	}
+	if value, err := client.Read("DB1.DBB0", make([]byte, 1)); err != nil || value != byte(1) {
+		t.Fatalf("unexpected DB1.DBB0 %v: %v", value, err)
+	}
+	// a single DB bit is its masked byte, arrays of bits are []bool
+	if value, err := client.Read("DB1.DBX0.0", make([]byte, 1)); err != nil || value != byte(1) {
+		t.Fatalf("unexpected DB1.DBX0.0 %v (%T): %v", value, value, err)
+	}
+	if value, err := client.Read("DB1.DBX0.0[2]", make([]byte, 1)); err != nil || !reflect.DeepEqual(value, []bool{true, false}) {
+		t.Fatalf("unexpected DB1.DBX0.0[2] %v: %v", value, err)
+	}
+	if value, err := client.Read("E0.1", make([]byte, 1)); err != nil || value != true {
+		t.Fatalf("unexpected E0.1 %v (%T): %v", value, value, err)
+	}
+	if status, err := client.PLCGetStatus(); err != nil || status != s7CpuStatusStop {
+		t.Fatalf("unexpected status %d: %v", status, err)
+	}
+	if info, err := client.GetCPUInfo(); err != nil || info.SerialNumber != "S C-FAKE" {
+		t.Fatalf("unexpected CPU info %+v: %v", info, err)
+	}
+	if list, err := client.PGListBlocks(); err != nil || !equalInts(list.OBList, []int{1}) || !equalInts(list.DBList, []int{1}) {
+		t.Fatalf("unexpected blocks %+v: %v", list, err)
+	}
+	if info, err := client.GetAgBlockInfo(blockDB, 1); err != nil || info.MC7Size != len(db) {
+		t.Fatalf("unexpected block info %+v: %v", info, err)
+	}
+	fake.SetClock(time.Date(2020, 2, 29, 23, 59, 0, 0, time.UTC))
+	if clock, err := client.PGClockWrite(); err != nil || clock.Year() != 2020 || clock.YearDay() != 60 {
+		t.Fatalf("unexpected clock %v: %v", clock, err)
+	}
+	if err := client.Compress(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.AGReadDB(2, 0, 1, make([]byte, 1)); err == nil {
+		t.Fatal("expected an error reading a missing DB")
+	}
+}
@@ -0,0 +1,256 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// FaultTransporter wraps a Transporter and injects faults into its replies, to test error handling and retries.
+// The faults act on the whole reply the wrapped transporter returns, not on the TPKT frames of the connection:
+// the wrapped transporter has read a complete frame before the reply is delayed, dropped, cut or changed.
+// The faults are drawn from a random source seeded at construction, the same seed and the same requests
+// give the same faults. Use it as transporter of NewClient2, with the wrapped handler as packager:
+//
+//	client := gos7.NewClient2(handler, gos7.NewFaultTransporter(handler, 42))
+type FaultTransporter struct {
+	Transporter Transporter
+	// DelayRate is the fraction (0..1) of replies delayed by Delay
+	DelayRate float64
+	Delay     time.Duration
+	// DropRate is the fraction of replies which are lost after the request was executed, the request fails with a timeout
+	DropRate float64
+	// TruncateRate is the fraction of replies handed on cut at a random length, the TPKT header keeps the
+	// full length. The connection is not affected, its frame was read completely.
+	TruncateRate float64
+	// CorruptReferenceRate is the fraction of replies with a wrong PDU reference
+	CorruptReferenceRate float64
+	// FailAfter lets every request after the first FailAfter requests fail without being sent, 0 never fails
+	FailAfter int
+	// CPUErrors replace the return code of the read/write var items they match
+	CPUErrors []CPUErrorFault
+
+	mu       sync.Mutex
+	rand     *rand.Rand
+	requests int
+}
+
+// CPUErrorFault makes the read/write var items which touch a memory range fail with a CPU return code
+type CPUErrorFault struct {
+	Area     int // S7AreaDB, S7AreaMK, ...
+	DBNumber int // number of the data block for S7AreaDB
+	Start    int // first byte, or the first timer/counter
+	Size     int // bytes (timers/counters) of the range, 0 or less matches the whole area
+	Code     byte
+}
+
+// faults are the faults drawn for a request
+type faults struct {
+	fail     bool
+	delay    bool
+	drop     bool
+	truncate float64 // fraction of the reply which is kept, 1 keeps all
+	corrupt  uint16  // xor mask of the PDU reference
+}
+
+// faultError is returned for failed and dropped requests, it is a net.Error
+type faultError struct {
+	message string
+	timeout bool
+}
+
+func (e *faultError) Error() string   { return e.message }
+func (e *faultError) Timeout() bool   { return e.timeout }
+func (e *faultError) Temporary() bool { return e.timeout }
+
+// NewFaultTransporter wraps transporter, the faults are drawn from a random source with the given seed
+func NewFaultTransporter(transporter Transporter, seed int64) *FaultTransporter {
+	return &FaultTransporter{Transporter: transporter, rand: rand.New(rand.NewSource(seed))}
+}
+
+// next draws the faults of the next request, all random numbers are drawn every time so that the faults of a
+// request only depend on the seed and the number of requests before
+func (t *FaultTransporter) next() (f faults) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.rand == nil {
+		t.rand = rand.New(rand.NewSource(0))
+	}
+	t.requests++
+	f.fail = t.FailAfter > 0 && t.requests > t.FailAfter
+	f.delay = t.rand.Float64() < t.DelayRate
+	f.drop = t.rand.Float64() < t.DropRate
+	truncate, keep := t.rand.Float64(), t.rand.Float64()
+	f.truncate = 1
+	if truncate < t.TruncateRate {
+		f.truncate = keep
+	}
+	corrupt, mask := t.rand.Float64(), uint16(t.rand.Intn(0xFFFF)+1)
+	if corrupt < t.CorruptReferenceRate {
+		f.corrupt = mask
+	}
+	return
+}
+
+// Send sends the request with the wrapped transporter and injects the faults into the reply
+func (t *FaultTransporter) Send(request []byte) (response []byte, err error) {
+	return t.SendContext(context.Background(), request)
+}
+
+// SendContext is like Send, the context is passed on to the wrapped transporter and aborts a delay
+func (t *FaultTransporter) SendContext(ctx context.Context, request []byte) (response []byte, err error) {
+	f := t.next()
+	if f.fail {
+		return nil, &faultError{message: fmt.Sprintf("s7: injected failure after %d requests", t.FailAfter)}
+	}
+	if transporter, ok := t.Transporter.(TransporterContext); ok {
+		response, err = transporter.SendContext(ctx, request)
+	} else if err = ctx.Err(); err == nil {
+		response, err = t.Transporter.Send(request)
+	}
+	if err != nil {
+		return
+	}
+	if f.delay {
+		timer := time.NewTimer(t.Delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if f.drop {
+		return nil, &faultError{message: "s7: injected loss of the reply", timeout: true}
+	}
+	response = t.substitute(request, append([]byte{}, response...))
+	if f.corrupt != 0 && len(response) >= 13 {
+		binary.BigEndian.PutUint16(response[11:], binary.BigEndian.Uint16(response[11:])^f.corrupt)
+	}
+	if f.truncate < 1 {
+		response = response[:int(f.truncate*float64(len(response)))]
+	}
+	return
+}
+
+// PDUSize returns the PDU size of the wrapped transporter, implements Session.
+func (t *FaultTransporter) PDUSize() int {
+	if session, ok := t.Transporter.(Session); ok {
+		return session.PDUSize()
+	}
+	return 0
+}
+
+// MaxParallelJobs returns the parallel jobs of the wrapped transporter, implements Session.
+func (t *FaultTransporter) MaxParallelJobs() int {
+	if session, ok := t.Transporter.(Session); ok {
+		return session.MaxParallelJobs()
+	}
+	return 1
+}
+
+// ReceiveJob waits for the next job of the PLC with the wrapped transporter, implements Exchanger.
+func (t *FaultTransporter) ReceiveJob(ctx context.Context) (job []byte, err error) {
+	if exchanger, ok := t.Transporter.(Exchanger); ok {
+		return exchanger.ReceiveJob(ctx)
+	}
+	return nil, fmt.Errorf(ErrorText(errCliFunctionNotImplemented))
+}
+
+// Reply answers a job of the PLC with the wrapped transporter, implements Exchanger.
+func (t *FaultTransporter) Reply(ctx context.Context, reply []byte) error {
+	if exchanger, ok := t.Transporter.(Exchanger); ok {
+		return exchanger.Reply(ctx, reply)
+	}
+	return fmt.Errorf(ErrorText(errCliFunctionNotImplemented))
+}
+
+// matches tells whether the fault hits an item of a read/write var request
+func (f *CPUErrorFault) matches(item serverItem) bool {
+	if item.area != f.Area || (f.Area == s7areadb && item.dbNumber != f.DBNumber) {
+		return false
+	}
+	if f.Size <= 0 {
+		return true
+	}
+	start, size := item.address>>3, item.amount*dataSizeByte(item.wordLen)
+	if item.wordLen == s7wltimer || item.wordLen == s7wlcounter {
+		start, size = item.address, item.amount
+	}
+	return start < f.Start+f.Size && f.Start < start+size
+}
+
+// substitute sets the return codes of the CPUErrors into the reply of a read/write var request
+func (t *FaultTransporter) substitute(request []byte, response []byte) []byte {
+	if len(t.CPUErrors) == 0 || len(request) < 19 || request[7] != 0x32 || request[8] != 1 ||
+		(request[17] != 0x04 && request[17] != 0x05) {
+		return response
+	}
+	if len(response) < 21 || response[8] != 3 || binary.BigEndian.Uint16(response[17:]) != 0 {
+		return response
+	}
+	list, ok := items(request)
+	if !ok {
+		return response
+	}
+	codes := make([]byte, len(list))
+	hit := false
+	for i, item := range list {
+		for _, fault := range t.CPUErrors {
+			if fault.matches(item) {
+				codes[i] = fault.Code
+				hit = true
+				break
+			}
+		}
+	}
+	if !hit {
+		return response
+	}
+	if request[17] == 0x05 {
+		for i, code := range codes {
+			if code != 0 && 21+i < len(response) {
+				response[21+i] = code
+			}
+		}
+		return response
+	}
+	// the data of a failed read item is left out
+	replaced := append([]byte{}, response[:21]...)
+	offset := 21
+	for i := range list {
+		if offset+sizeMultiItemData > len(response) {
+			return response
+		}
+		size := int(binary.BigEndian.Uint16(response[offset+2:]))
+		if ts := response[offset+1]; ts != tsResBit && ts != tsResOctet && ts != tsResReal {
+			size = (size + 7) >> 3
+		}
+		end := offset + sizeMultiItemData + size
+		if end > len(response) {
+			return response
+		}
+		last := i == len(list)-1
+		if codes[i] != 0 {
+			replaced = append(replaced, codes[i], 0, 0, 0)
+		} else {
+			replaced = append(replaced, response[offset:end]...)
+			if size%2 != 0 && !last {
+				replaced = append(replaced, 0)
+			}
+		}
+		offset = end
+		if size%2 != 0 && !last {
+			offset++
+		}
+	}
+	binary.BigEndian.PutUint16(replaced[2:], uint16(len(replaced)))
+	binary.BigEndian.PutUint16(replaced[15:], uint16(len(replaced)-21))
+	return replaced
+}
@@ -0,0 +1,105 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// newFaultClient returns a client of a fake PLC with DB1 and DB2 of 8 bytes, whose replies pass fault
+func newFaultClient(seed int64) (ClientContext, *FaultTransporter) {
+	server := NewServer()
+	server.RegisterArea(S7AreaDB, 1, []byte{1, 2, 3, 4, 5, 6, 7, 8})
+	server.RegisterArea(S7AreaDB, 2, make([]byte, 8))
+	handler := &fakeHandler{conn: serverConn{server: server}}
+	fault := NewFaultTransporter(handler, seed)
+	return NewClient2(handler, fault).(ClientContext), fault
+}
+
+func TestFaultTransporterCPUErrors(t *testing.T) {
+	client, fault := newFaultClient(1)
+	fault.CPUErrors = []CPUErrorFault{{Area: S7AreaDB, DBNumber: 1, Start: 3, Size: 2, Code: S7CodeAddressOutOfRange}}
+	items := []S7DataItem{
+		{Area: S7AreaDB, WordLen: S7WLByte, DBNumber: 1, Start: 0, Amount: 3, Data: make([]byte, 3)},
+		{Area: S7AreaDB, WordLen: S7WLByte, DBNumber: 1, Start: 2, Amount: 3, Data: make([]byte, 3)},
+		{Area: S7AreaDB, WordLen: S7WLByte, DBNumber: 2, Start: 0, Amount: 1, Data: make([]byte, 1)},
+	}
+	if err := client.AGReadMulti(items, len(items)); err != nil {
+		t.Fatal(err)
+	}
+	if items[0].Error != "" || items[0].Data[2] != 3 || items[1].Error != ErrorText(errCliAddressOutOfRange) || items[2].Error != "" {
+		t.Fatalf("unexpected items: %+v", items)
+	}
+	if err := client.AGWriteMulti(items, len(items)); err != nil {
+		t.Fatal(err)
+	}
+	if items[0].Error != "" || items[1].Error != ErrorText(errCliAddressOutOfRange) || items[2].Error != "" {
+		t.Fatalf("unexpected items: %+v", items)
+	}
+	if err := client.AGReadDB(1, 4, 2, make([]byte, 2)); err == nil {
+		t.Fatal("expected the injected CPU error")
+	}
+}
+
+func TestFaultTransporterFailAfter(t *testing.T) {
+	client, fault := newFaultClient(1)
+	fault.FailAfter = 2
+	buffer := make([]byte, 2)
+	for i := 0; i < 2; i++ {
+		if err := client.AGReadDB(1, 0, 2, buffer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.AGReadDB(1, 0, 2, buffer); err == nil {
+		t.Fatal("expected a failure after 2 requests")
+	}
+}
+
+func TestFaultTransporterSeed(t *testing.T) {
+	outcomes := func(seed int64) (results []string) {
+		client, fault := newFaultClient(seed)
+		fault.DropRate = 0.2
+		fault.TruncateRate = 0.2
+		fault.CorruptReferenceRate = 0.2
+		buffer := make([]byte, 4)
+		for i := 0; i < 50; i++ {
+			err := client.AGReadDB(1, 0, 4, buffer)
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				results = append(results, "timeout")
+			} else if err != nil {
+				results = append(results, err.Error())
+			} else {
+				results = append(results, "ok")
+			}
+		}
+		return
+	}
+	first, second := outcomes(7), outcomes(7)
+	failed := 0
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("request %d: %q with the same seed than %q", i, second[i], first[i])
+		}
+		if first[i] != "ok" {
+			failed++
+		}
+	}
+	if failed == 0 || failed == len(first) {
+		t.Fatalf("unexpected failures %d of %d: %v", failed, len(first), first)
+	}
+}
+
+func TestFaultTransporterDelay(t *testing.T) {
+	client, fault := newFaultClient(1)
+	fault.DelayRate = 1
+	fault.Delay = time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := client.AGReadDBContext(ctx, 1, 0, 2, make([]byte, 2)); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
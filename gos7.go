@@ -4,6 +4,7 @@ package gos7
 // This software may be modified and distributed under the terms
 // of the BSD license. See the LICENSE file for details.
 import (
+	"context"
 	"fmt"
 	"strconv"
 )
@@ -36,6 +37,31 @@ type Transporter interface {
 	Send(request []byte) (response []byte, err error)
 }
 
+// Session exposes the parameters negotiated with the PLC when the connection is established.
+// Transports (or any wrapper around them) implement it so that the client can size its requests.
+type Session interface {
+	// PDUSize returns the negotiated PDU length in bytes
+	PDUSize() int
+	// MaxParallelJobs returns the number of jobs the PLC accepts without acknowledging them (max AmQ calling).
+	// The client sends requests in parallel only through a TCPClientHandler with Pipelining, the
+	// Transporter of a Session is not required to be safe for concurrent use.
+	MaxParallelJobs() int
+}
+
+// TransporterContext is implemented by transports which can abort a request
+// when the given context is cancelled or its deadline expires.
+type TransporterContext interface {
+	SendContext(ctx context.Context, request []byte) (response []byte, err error)
+}
+
+// Exchanger is implemented by transports which let the PLC send jobs to the client, as the PLC does during
+// a block download: ReceiveJob waits for the next job of the PLC, Reply answers it. Replies keep the PDU
+// reference of the job they answer.
+type Exchanger interface {
+	ReceiveJob(ctx context.Context) (job []byte, err error)
+	Reply(ctx context.Context, reply []byte) error
+}
+
 // Error converts known s7 exception code to error message.
 func (e *S7Error) Error() string {
 	/* CPU tells there is no peripheral at address */
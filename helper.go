@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math"
 	"time"
+	"unicode/utf16"
 )
 
 const (
@@ -108,7 +109,7 @@ func (s7 *Helper) SetDateTimeAt(buffer []byte, pos int, value time.Time) {
 	buffer[pos+4] = encodeBcd(mi)
 	buffer[pos+5] = encodeBcd(s)
 	buffer[pos+6] = encodeBcd(value.Nanosecond() / 1000000 / 10)
-	buffer[pos+7] = (encodeBcd(value.Nanosecond()/1000000%10) << 4) | encodeBcd(int(value.Weekday()))
+	buffer[pos+7] = (encodeBcd(value.Nanosecond()/1000000%10) << 4) | encodeBcd(int(value.Weekday())+1)
 }
 
 //GetDateAt DATE (S7 DATE)
@@ -130,7 +131,7 @@ func (s7 *Helper) SetDateAt(buffer []byte, pos int, value time.Time) {
 //GetTODAt TOD (S7 TIME_OF_DAY)
 func (s7 *Helper) GetTODAt(buffer []byte, pos int) time.Time {
 	var ms int32
-	s7.GetValueAt(buffer, 0, &ms)
+	s7.GetValueAt(buffer, pos, &ms)
 	return time.Date(1970, time.Month(1), 1, 0, 0, 0, int(ms)*1000000, time.UTC)
 }
 
@@ -247,22 +248,17 @@ func (s7 *Helper) GetStringAt(buffer []byte, pos int) string {
 	return string(buffer[pos+2 : pos+2+int(l)])
 }
 
-//SetWStringAt Set String (WString)
+//SetWStringAt Set String (WString), the lengths count UTF-16 code units as the PLC does
 func (s7 *Helper) SetWStringAt(buffer []byte, pos int, maxLen int, value string) []byte {
-	chars := []rune(value)
-	var sLen int
-	if maxLen < len(value) {
+	chars := utf16.Encode([]rune(value))
+	sLen := len(chars)
+	if maxLen < sLen {
 		sLen = maxLen
-	} else {
-		sLen = len(value)
 	}
 	s7.SetValueAt(buffer, pos+0, int16(maxLen))
 	s7.SetValueAt(buffer, pos+2, int16(sLen))
-	for i, c := range chars {
-		if i >= sLen {
-			return buffer
-		}
-		s7.SetValueAt(buffer, pos+4+i*2, uint16(c))
+	for i, c := range chars[:sLen] {
+		s7.SetValueAt(buffer, pos+4+i*2, c)
 	}
 	return buffer
 }
@@ -270,19 +266,13 @@ func (s7 *Helper) SetWStringAt(buffer []byte, pos int, maxLen int, value string)
 //GetWStringAt Get WString
 func (s7 *Helper) GetWStringAt(buffer []byte, pos int) string {
 	var l, max int16
-	var i int
-	var s string
 	s7.GetValueAt(buffer, pos+0, &max)
 	s7.GetValueAt(buffer, pos+2, &l)
-	bs := buffer[pos+4:]
-	for i < int(l) {
-		var c uint16
-		s7.GetValueAt(bs, 0, &c)
-		bs = bs[2:]
-		s += fmt.Sprintf("%c", c)
-		i++
+	chars := make([]uint16, l)
+	for i := range chars {
+		s7.GetValueAt(buffer, pos+4+i*2, &chars[i])
 	}
-	return s
+	return string(utf16.Decode(chars))
 }
 
 //GetCharsAt Get Array of char (S7 ARRAY OF CHARS)
@@ -2,6 +2,7 @@ package gos7
 
 import (
 	"testing"
+	"time"
 )
 
 func TestHelper_SetBoolAt(t *testing.T) {
@@ -48,3 +49,12 @@ func TestHelper_GetBoolAt(t *testing.T) {
 		}
 	}
 }
+
+func TestHelper_GetTODAt(t *testing.T) {
+	var h Helper
+	buffer := []byte{0xFF, 0xFF, 0x00, 0x00, 0x04, 0xD2}
+	expected := time.Date(1970, time.Month(1), 1, 0, 0, 1, 234000000, time.UTC)
+	if tod := h.GetTODAt(buffer, 2); !tod.Equal(expected) {
+		t.Errorf("expected %v given %v", expected, tod)
+	}
+}
@@ -0,0 +1,641 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Struct marshalling maps Go structs to the memory layout of S7 data blocks (S7-300/400 and
+// not optimized S7-1200/1500 blocks). The layout follows the S7 rules:
+//   - consecutive BOOLs are packed into bytes, 8 per byte
+//   - BYTE, CHAR, SINT and USINT start at the next byte
+//   - all other types, STRING, nested structs (UDTs) and ARRAYs start at the next even byte
+//   - nested structs and arrays take an even number of bytes
+//
+// A field can be placed and typed explicitly with a tag, the offset is relative to the start
+// of the enclosing struct, for the top level struct it is the offset in the data block:
+//
+//	type Motor struct {
+//		Running bool    `s7:"offset=0.0"`
+//		Speed   float32 `s7:"offset=2.0,type=REAL"`
+//		Name    string  `s7:"type=STRING[20]"`
+//		Counts  [4]int  `s7:"type=INT"`
+//		Spare   int     `s7:"-"`
+//	}
+//
+// Fields without offset follow the previous field. The type of an array field is the type of its
+// elements. Without a type the S7 type is derived from the Go type: bool BOOL, uint8 BYTE, int8 SINT,
+// uint16 WORD, int16 INT, uint32 DWORD, int32 DINT, uint64 LWORD, int64 LINT, float32 REAL, float64 LREAL,
+// string STRING[254], time.Duration TIME, time.Time DATE_AND_TIME, structs UDT and arrays ARRAY.
+// Further types are CHAR, UINT, UDINT, ULINT, S5TIME, DATE, TIME_OF_DAY (TOD), DTL and WSTRING[n].
+// Unexported fields and fields tagged with "-" are ignored.
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// s7Type describes an elementary S7 type
+type s7Type struct {
+	name   string
+	bits   int  // size in bits, 1 for BOOL
+	align  int  // alignment in bits: 1 for BOOL, 8 for byte types, 16 for the others
+	signed bool // signed integer
+	length int  // maximum length of STRING and WSTRING
+}
+
+var s7Types = map[string]s7Type{
+	"BOOL":          {name: "BOOL", bits: 1, align: 1},
+	"BYTE":          {name: "BYTE", bits: 8, align: 8},
+	"CHAR":          {name: "CHAR", bits: 8, align: 8},
+	"USINT":         {name: "USINT", bits: 8, align: 8},
+	"SINT":          {name: "SINT", bits: 8, align: 8, signed: true},
+	"WORD":          {name: "WORD", bits: 16, align: 16},
+	"UINT":          {name: "UINT", bits: 16, align: 16},
+	"INT":           {name: "INT", bits: 16, align: 16, signed: true},
+	"DWORD":         {name: "DWORD", bits: 32, align: 16},
+	"UDINT":         {name: "UDINT", bits: 32, align: 16},
+	"DINT":          {name: "DINT", bits: 32, align: 16, signed: true},
+	"LWORD":         {name: "LWORD", bits: 64, align: 16},
+	"ULINT":         {name: "ULINT", bits: 64, align: 16},
+	"LINT":          {name: "LINT", bits: 64, align: 16, signed: true},
+	"REAL":          {name: "REAL", bits: 32, align: 16},
+	"LREAL":         {name: "LREAL", bits: 64, align: 16},
+	"TIME":          {name: "TIME", bits: 32, align: 16},
+	"S5TIME":        {name: "S5TIME", bits: 16, align: 16},
+	"DATE":          {name: "DATE", bits: 16, align: 16},
+	"TIME_OF_DAY":   {name: "TIME_OF_DAY", bits: 32, align: 16},
+	"TOD":           {name: "TIME_OF_DAY", bits: 32, align: 16},
+	"DATE_AND_TIME": {name: "DATE_AND_TIME", bits: 64, align: 16},
+	"DT":            {name: "DATE_AND_TIME", bits: 64, align: 16},
+	"DTL":           {name: "DTL", bits: 96, align: 16},
+}
+
+// layout is the memory layout of an S7 value, offsets and sizes are in bits
+type layout struct {
+	typ    *s7Type       // elementary types
+	fields []fieldLayout // structs
+	elem   *layout       // arrays
+	count  int           // number of array elements
+	bits   int
+	align  int
+	// explicit is set if a field is placed with an offset tag, the gaps between
+	// the fields may be used by other variables then
+	explicit bool
+}
+
+type fieldLayout struct {
+	index  int
+	name   string
+	offset int
+	layout *layout
+}
+
+// structLayout computes the layout of a struct type
+func structLayout(t reflect.Type) (*layout, error) {
+	l := &layout{align: 16}
+	pos := 0
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("s7")
+		if f.PkgPath != "" || tag == "-" {
+			continue
+		}
+		offset, typeName, err := parseS7Tag(tag)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", f.Name, err)
+		}
+		fl, err := valueLayout(f.Type, typeName)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", f.Name, err)
+		}
+		if offset >= 0 {
+			if offset%8 != 0 && (fl.typ == nil || fl.typ.bits != 1) {
+				return nil, fmt.Errorf("field %s: only BOOL can be placed at a bit offset", f.Name)
+			}
+			pos = offset
+			l.explicit = true
+		} else {
+			pos = alignBits(pos, fl.align)
+		}
+		l.explicit = l.explicit || fl.explicit
+		l.fields = append(l.fields, fieldLayout{index: i, name: f.Name, offset: pos, layout: fl})
+		pos += fl.bits
+	}
+	l.bits = alignBits(pos, 16)
+	return l, nil
+}
+
+// valueLayout computes the layout of a Go type, typeName overrides the S7 type of elementary
+// values and array elements
+func valueLayout(t reflect.Type, typeName string) (*layout, error) {
+	if typeName == "" {
+		switch {
+		case t == timeType:
+			typeName = "DATE_AND_TIME"
+		case t == durationType:
+			typeName = "TIME"
+		case t.Kind() == reflect.Struct:
+			return structLayout(t)
+		case t.Kind() == reflect.Array:
+			elem, err := valueLayout(t.Elem(), "")
+			if err != nil {
+				return nil, err
+			}
+			return arrayLayout(elem, t.Len()), nil
+		default:
+			typeName = defaultS7Type(t.Kind())
+			if typeName == "" {
+				return nil, fmt.Errorf("no S7 type for %s, add a type to the tag", t)
+			}
+		}
+	} else if t.Kind() == reflect.Array {
+		elem, err := valueLayout(t.Elem(), typeName)
+		if err != nil {
+			return nil, err
+		}
+		return arrayLayout(elem, t.Len()), nil
+	}
+	typ, err := lookupS7Type(typeName)
+	if err != nil {
+		return nil, err
+	}
+	if !typ.accepts(t) {
+		return nil, fmt.Errorf("%s cannot hold %s", t, typ.name)
+	}
+	return &layout{typ: typ, bits: typ.bits, align: typ.align}, nil
+}
+
+// arrayLayout lays out count elements, every element starts at its own alignment
+func arrayLayout(elem *layout, count int) *layout {
+	bits := 0
+	for i := 0; i < count; i++ {
+		bits = alignBits(bits, elem.align) + elem.bits
+	}
+	return &layout{elem: elem, count: count, bits: alignBits(bits, 16), align: 16, explicit: elem.explicit}
+}
+
+// elementOffset returns the offset of the element i of an array relative to the array
+func (l *layout) elementOffset(i int) int {
+	return i * alignBits(l.elem.bits, l.elem.align)
+}
+
+func alignBits(pos int, align int) int {
+	return (pos + align - 1) / align * align
+}
+
+func defaultS7Type(kind reflect.Kind) string {
+	switch kind {
+	case reflect.Bool:
+		return "BOOL"
+	case reflect.Uint8:
+		return "BYTE"
+	case reflect.Int8:
+		return "SINT"
+	case reflect.Uint16:
+		return "WORD"
+	case reflect.Int16:
+		return "INT"
+	case reflect.Uint32:
+		return "DWORD"
+	case reflect.Int32:
+		return "DINT"
+	case reflect.Uint64:
+		return "LWORD"
+	case reflect.Int64:
+		return "LINT"
+	case reflect.Float32:
+		return "REAL"
+	case reflect.Float64:
+		return "LREAL"
+	case reflect.String:
+		return "STRING[254]"
+	}
+	return ""
+}
+
+// lookupS7Type resolves a type name, STRING[n] and WSTRING[n] carry their maximum length
+func lookupS7Type(name string) (*s7Type, error) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	for _, prefix := range []string{"STRING", "WSTRING"} {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		length := maxAddressStringLen
+		if prefix == "WSTRING" {
+			length = 254
+		}
+		if rest := name[len(prefix):]; rest != "" {
+			if !strings.HasPrefix(rest, "[") || !strings.HasSuffix(rest, "]") {
+				return nil, fmt.Errorf("invalid type %s", name)
+			}
+			n, err := strconv.Atoi(rest[1 : len(rest)-1])
+			if err != nil || n < 1 || n > maxAddressStringLen && prefix == "STRING" || n > 16382 {
+				return nil, fmt.Errorf("invalid length in %s", name)
+			}
+			length = n
+		}
+		if prefix == "STRING" {
+			return &s7Type{name: prefix, bits: (length + 2) * 8, align: 16, length: length}, nil
+		}
+		return &s7Type{name: prefix, bits: (2*length + 4) * 8, align: 16, length: length}, nil
+	}
+	typ, ok := s7Types[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown type %s", name)
+	}
+	return &typ, nil
+}
+
+// accepts tells whether a Go value of type t can hold the S7 type
+func (typ *s7Type) accepts(t reflect.Type) bool {
+	switch typ.name {
+	case "BOOL":
+		return t.Kind() == reflect.Bool
+	case "REAL", "LREAL":
+		return t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64
+	case "STRING", "WSTRING":
+		return t.Kind() == reflect.String
+	case "TIME", "S5TIME":
+		return t == durationType
+	case "DATE", "TIME_OF_DAY", "DATE_AND_TIME", "DTL":
+		return t == timeType
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return t != durationType
+	}
+	return false
+}
+
+// parseS7Tag parses `s7:"offset=4.0,type=REAL"`, offset is -1 if it is missing
+func parseS7Tag(tag string) (offset int, typeName string, err error) {
+	offset = -1
+	if tag == "" {
+		return
+	}
+	for _, option := range strings.Split(tag, ",") {
+		key, value := option, ""
+		if i := strings.IndexByte(option, '='); i >= 0 {
+			key, value = option[:i], option[i+1:]
+		}
+		switch strings.TrimSpace(key) {
+		case "offset":
+			offset, err = parseBitOffset(strings.TrimSpace(value))
+			if err != nil {
+				return
+			}
+		case "type":
+			typeName = value
+		default:
+			err = fmt.Errorf("unknown tag option %q", option)
+			return
+		}
+	}
+	return
+}
+
+// parseBitOffset parses byte.bit or byte into an offset in bits
+func parseBitOffset(s string) (int, error) {
+	byteOffset, bit := s, "0"
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		byteOffset, bit = s[:i], s[i+1:]
+	}
+	b, err := strconv.Atoi(byteOffset)
+	if err != nil || b < 0 || b > maxAddressOffset {
+		return 0, fmt.Errorf("invalid offset %q", s)
+	}
+	n, err := strconv.Atoi(bit)
+	if err != nil || n < 0 || n > 7 {
+		return 0, fmt.Errorf("invalid bit in offset %q", s)
+	}
+	return b*8 + n, nil
+}
+
+// span returns the first byte and the size in bytes of the memory used by the struct
+func (l *layout) span() (start int, size int) {
+	if len(l.fields) == 0 {
+		return 0, 0
+	}
+	start = l.fields[0].offset
+	end := 0
+	for _, f := range l.fields {
+		if f.offset < start {
+			start = f.offset
+		}
+		if e := f.offset + f.layout.bits; e > end {
+			end = e
+		}
+	}
+	start /= 8
+	return start, (end+7)/8 - start
+}
+
+// structValue returns the struct which v points to, or v itself if it is a struct and
+// the struct is not written
+func structValue(v interface{}, write bool) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	} else if write {
+		return reflect.Value{}, fmt.Errorf("s7: cannot unmarshal into %T, a pointer to a struct is needed", v)
+	}
+	if rv.Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("s7: cannot marshal %T, a struct is needed", v)
+	}
+	return rv, nil
+}
+
+// Marshal encodes the struct v (or a pointer to it) into the memory layout of an S7 data block.
+// The result starts at byte 0 of the data block and ends with the last field.
+func Marshal(v interface{}) ([]byte, error) {
+	rv, err := structValue(v, false)
+	if err != nil {
+		return nil, err
+	}
+	l, err := structLayout(rv.Type())
+	if err != nil {
+		return nil, fmt.Errorf("s7: %v", err)
+	}
+	start, size := l.span()
+	buffer := make([]byte, start+size)
+	if err = l.encode(buffer, 0, rv); err != nil {
+		return nil, fmt.Errorf("s7: %v", err)
+	}
+	return buffer, nil
+}
+
+// Unmarshal decodes the memory of an S7 data block starting at byte 0 into the struct v points to.
+func Unmarshal(data []byte, v interface{}) error {
+	rv, err := structValue(v, true)
+	if err != nil {
+		return err
+	}
+	l, err := structLayout(rv.Type())
+	if err != nil {
+		return fmt.Errorf("s7: %v", err)
+	}
+	if start, size := l.span(); len(data) < start+size {
+		return fmt.Errorf("s7: %d bytes are needed to unmarshal %s, given %d", start+size, rv.Type(), len(data))
+	}
+	if err = l.decode(data, 0, rv); err != nil {
+		return fmt.Errorf("s7: %v", err)
+	}
+	return nil
+}
+
+// encode writes v at the bit offset pos of buffer
+func (l *layout) encode(buffer []byte, pos int, v reflect.Value) error {
+	switch {
+	case l.fields != nil:
+		for _, f := range l.fields {
+			if err := f.layout.encode(buffer, pos+f.offset, v.Field(f.index)); err != nil {
+				return fmt.Errorf("%s: %v", f.name, err)
+			}
+		}
+	case l.elem != nil:
+		for i := 0; i < l.count; i++ {
+			if err := l.elem.encode(buffer, pos+l.elementOffset(i), v.Index(i)); err != nil {
+				return fmt.Errorf("[%d]: %v", i, err)
+			}
+		}
+	case l.typ != nil:
+		return l.typ.encode(buffer, pos, v)
+	}
+	return nil
+}
+
+// decode reads v from the bit offset pos of buffer
+func (l *layout) decode(buffer []byte, pos int, v reflect.Value) error {
+	switch {
+	case l.fields != nil:
+		for _, f := range l.fields {
+			if err := f.layout.decode(buffer, pos+f.offset, v.Field(f.index)); err != nil {
+				return fmt.Errorf("%s: %v", f.name, err)
+			}
+		}
+	case l.elem != nil:
+		for i := 0; i < l.count; i++ {
+			if err := l.elem.decode(buffer, pos+l.elementOffset(i), v.Index(i)); err != nil {
+				return fmt.Errorf("[%d]: %v", i, err)
+			}
+		}
+	case l.typ != nil:
+		return l.typ.decode(buffer, pos, v)
+	}
+	return nil
+}
+
+func (typ *s7Type) encode(buffer []byte, pos int, v reflect.Value) error {
+	var helper Helper
+	b := pos / 8
+	switch typ.name {
+	case "BOOL":
+		buffer[b] = helper.SetBoolAt(buffer[b], uint(pos%8), v.Bool())
+	case "REAL":
+		helper.SetRealAt(buffer, b, float32(v.Float()))
+	case "LREAL":
+		helper.SetLRealAt(buffer, b, v.Float())
+	case "STRING":
+		if v.Len() > typ.length {
+			return fmt.Errorf("string of %d characters does not fit into STRING[%d]", v.Len(), typ.length)
+		}
+		helper.SetStringAt(buffer, b, typ.length, v.String())
+	case "WSTRING":
+		if n := len(utf16.Encode([]rune(v.String()))); n > typ.length {
+			return fmt.Errorf("string of %d UTF-16 characters does not fit into WSTRING[%d]", n, typ.length)
+		}
+		helper.SetWStringAt(buffer, b, typ.length, v.String())
+	case "TIME":
+		ms := time.Duration(v.Int()).Milliseconds()
+		if ms < math.MinInt32 || ms > math.MaxInt32 {
+			return fmt.Errorf("%v does not fit into TIME", time.Duration(v.Int()))
+		}
+		helper.SetValueAt(buffer, b, int32(ms))
+	case "S5TIME":
+		if d := time.Duration(v.Int()); d < 0 || d.Milliseconds() >= 9990000 {
+			return fmt.Errorf("%v does not fit into S5TIME", d)
+		}
+		helper.SetS5TimeAt(buffer, b, time.Duration(v.Int()))
+	case "DATE":
+		helper.SetDateAt(buffer, b, v.Interface().(time.Time))
+	case "TIME_OF_DAY":
+		helper.SetTODAt(buffer, b, v.Interface().(time.Time))
+	case "DATE_AND_TIME":
+		helper.SetDateTimeAt(buffer, b, v.Interface().(time.Time))
+	case "DTL":
+		helper.SetDTLAt(buffer, b, v.Interface().(time.Time))
+	default:
+		// integers, stored big endian with the size of the S7 type
+		var raw uint64
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n := v.Int()
+			if !typ.fitsInt(n) {
+				return fmt.Errorf("value %d does not fit into %s", n, typ.name)
+			}
+			raw = uint64(n)
+		default:
+			n := v.Uint()
+			if n > typ.maxUint() {
+				return fmt.Errorf("value %d does not fit into %s", n, typ.name)
+			}
+			raw = n
+		}
+		for i := typ.bits/8 - 1; i >= 0; i-- {
+			buffer[b+i] = byte(raw)
+			raw >>= 8
+		}
+	}
+	return nil
+}
+
+func (typ *s7Type) decode(buffer []byte, pos int, v reflect.Value) error {
+	var helper Helper
+	b := pos / 8
+	switch typ.name {
+	case "BOOL":
+		v.SetBool(helper.GetBoolAt(buffer[b], uint(pos%8)))
+	case "REAL":
+		v.SetFloat(float64(helper.GetRealAt(buffer, b)))
+	case "LREAL":
+		v.SetFloat(helper.GetLRealAt(buffer, b))
+	case "STRING":
+		if int(buffer[b+1]) > typ.length {
+			return fmt.Errorf("actual length %d exceeds STRING[%d]", buffer[b+1], typ.length)
+		}
+		v.SetString(helper.GetStringAt(buffer, b))
+	case "WSTRING":
+		var length int16
+		helper.GetValueAt(buffer, b+2, &length)
+		if length < 0 || int(length) > typ.length {
+			return fmt.Errorf("actual length %d exceeds WSTRING[%d]", length, typ.length)
+		}
+		v.SetString(helper.GetWStringAt(buffer, b))
+	case "TIME":
+		var ms int32
+		helper.GetValueAt(buffer, b, &ms)
+		v.SetInt(int64(time.Duration(ms) * time.Millisecond))
+	case "S5TIME":
+		v.SetInt(int64(helper.GetS5TimeAt(buffer, b)))
+	case "DATE":
+		v.Set(reflect.ValueOf(helper.GetDateAt(buffer, b)))
+	case "TIME_OF_DAY":
+		v.Set(reflect.ValueOf(helper.GetTODAt(buffer, b)))
+	case "DATE_AND_TIME":
+		v.Set(reflect.ValueOf(helper.GetDateTimeAt(buffer, b)))
+	case "DTL":
+		v.Set(reflect.ValueOf(helper.GetDTLAt(buffer, b)))
+	default:
+		var raw uint64
+		for i := 0; i < typ.bits/8; i++ {
+			raw = raw<<8 | uint64(buffer[b+i])
+		}
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n := int64(raw)
+			if typ.signed {
+				// sign extension
+				shift := uint(64 - typ.bits)
+				n = int64(raw<<shift) >> shift
+			} else if n < 0 {
+				return fmt.Errorf("%s value %d does not fit into %s", typ.name, raw, v.Type())
+			}
+			if v.OverflowInt(n) {
+				return fmt.Errorf("%s value %d does not fit into %s", typ.name, n, v.Type())
+			}
+			v.SetInt(n)
+		default:
+			if typ.signed {
+				shift := uint(64 - typ.bits)
+				if n := int64(raw<<shift) >> shift; n < 0 {
+					return fmt.Errorf("%s value %d does not fit into %s", typ.name, n, v.Type())
+				}
+			}
+			if v.OverflowUint(raw) {
+				return fmt.Errorf("%s value %d does not fit into %s", typ.name, raw, v.Type())
+			}
+			v.SetUint(raw)
+		}
+	}
+	return nil
+}
+
+func (typ *s7Type) fitsInt(n int64) bool {
+	if typ.signed {
+		shift := uint(typ.bits - 1)
+		return typ.bits == 64 || n >= -1<<shift && n < 1<<shift
+	}
+	return n >= 0 && uint64(n) <= typ.maxUint()
+}
+
+func (typ *s7Type) maxUint() uint64 {
+	if typ.signed {
+		return 1<<uint(typ.bits-1) - 1
+	}
+	if typ.bits == 64 {
+		return math.MaxUint64
+	}
+	return 1<<uint(typ.bits) - 1
+}
+
+// ReadStruct reads the fields of the struct v points to from the data block dbNumber,
+// the memory used by the struct is read at once, see Unmarshal for the layout.
+func (mb *client) ReadStruct(dbNumber int, v interface{}) error {
+	rv, err := structValue(v, true)
+	if err != nil {
+		return err
+	}
+	l, err := structLayout(rv.Type())
+	if err != nil {
+		return fmt.Errorf("s7: %v", err)
+	}
+	start, size := l.span()
+	buffer := make([]byte, start+size)
+	if size > 0 {
+		if err = mb.AGReadDB(dbNumber, start, size, buffer[start:]); err != nil {
+			return err
+		}
+	}
+	if err = l.decode(buffer, 0, rv); err != nil {
+		return fmt.Errorf("s7: %v", err)
+	}
+	return nil
+}
+
+// WriteStruct writes the fields of the struct v (or a pointer to it) to the data block dbNumber,
+// the memory used by the struct is written at once. If fields are placed with explicit offsets,
+// the memory between them may belong to other variables: it is read before and written unchanged.
+func (mb *client) WriteStruct(dbNumber int, v interface{}) error {
+	rv, err := structValue(v, false)
+	if err != nil {
+		return err
+	}
+	l, err := structLayout(rv.Type())
+	if err != nil {
+		return fmt.Errorf("s7: %v", err)
+	}
+	start, size := l.span()
+	if size == 0 {
+		return nil
+	}
+	buffer := make([]byte, start+size)
+	if l.explicit {
+		if err = mb.AGReadDB(dbNumber, start, size, buffer[start:]); err != nil {
+			return err
+		}
+	}
+	if err = l.encode(buffer, 0, rv); err != nil {
+		return fmt.Errorf("s7: %v", err)
+	}
+	return mb.AGWriteDB(dbNumber, start, size, buffer[start:])
+}
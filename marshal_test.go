@@ -0,0 +1,150 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testValve struct {
+	Open   bool
+	Closed bool
+	Mode   byte
+	Count  int16
+	Name   string `s7:"type=STRING[3]"`
+	Flow   float32
+}
+
+type testStation struct {
+	Enabled bool
+	Valve   testValve
+	Alarms  [3]bool
+	Levels  [2]int `s7:"type=INT"`
+	Delay   time.Duration
+	ignored int
+}
+
+func TestMarshal(t *testing.T) {
+	station := testStation{
+		Enabled: true,
+		Valve:   testValve{Closed: true, Mode: 7, Count: -2, Name: "ab", Flow: 1.5},
+		Alarms:  [3]bool{false, true, true},
+		Levels:  [2]int{1, -1},
+		Delay:   2 * time.Second,
+	}
+	data, err := Marshal(&station)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		0x01, 0x00, // Enabled, the struct starts at the next even byte
+		0x02, 0x07, 0xFF, 0xFE, 0x03, 0x02, 'a', 'b', 0x00, 0x00, 0x3F, 0xC0, 0x00, 0x00, // Valve
+		0x06, 0x00, // Alarms
+		0x00, 0x01, 0xFF, 0xFF, // Levels
+		0x00, 0x00, 0x07, 0xD0, // Delay
+	}
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("expected % x given % x", expected, data)
+	}
+	var decoded testStation
+	if err = Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, station) {
+		t.Fatalf("expected %+v given %+v", station, decoded)
+	}
+}
+
+func TestMarshalWString(t *testing.T) {
+	type text struct {
+		S string `s7:"type=WSTRING[4]"`
+	}
+	data, err := Marshal(&text{"äö😀"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{0x00, 0x04, 0x00, 0x04, 0x00, 0xE4, 0x00, 0xF6, 0xD8, 0x3D, 0xDE, 0x00}
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("expected % x given % x", expected, data)
+	}
+	var decoded text
+	if err = Unmarshal(data, &decoded); err != nil || decoded.S != "äö😀" {
+		t.Fatalf("unexpected %q: %v", decoded.S, err)
+	}
+	if _, err = Marshal(&text{"äöü😀"}); err == nil {
+		t.Fatal("expected an error for 5 UTF-16 characters in WSTRING[4]")
+	}
+}
+
+func TestMarshalOffsets(t *testing.T) {
+	var v struct {
+		Level float32 `s7:"offset=10.0,type=REAL"`
+		Alarm bool    `s7:"offset=8.3"`
+		Count uint8   `s7:"type=USINT"`
+	}
+	v.Alarm = true
+	v.Count = 200
+	data, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0x08, 0xC8, 0, 0, 0, 0}
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("expected % x given % x", expected, data)
+	}
+}
+
+func TestMarshalError(t *testing.T) {
+	input := []interface{}{
+		testValve{Name: "abcd"},
+		struct{ A int }{},
+		struct {
+			A int16 `s7:"type=REAL"`
+		}{},
+		struct {
+			A int `s7:"type=SINT"`
+		}{A: 200},
+		struct {
+			A int16 `s7:"offset=1.2"`
+		}{},
+		struct {
+			A string `s7:"type=STRING[300]"`
+		}{},
+		42,
+	}
+	for _, i := range input {
+		if _, err := Marshal(i); err == nil {
+			t.Errorf("%+v: expected an error", i)
+		}
+	}
+	var v testValve
+	if err := Unmarshal(make([]byte, 4), &v); err == nil {
+		t.Errorf("expected an error for a short buffer")
+	}
+	if err := Unmarshal(make([]byte, 14), v); err == nil {
+		t.Errorf("expected an error for a struct which is not a pointer")
+	}
+}
+
+func TestClientReadStruct(t *testing.T) {
+	transporter := &readTransporter{}
+	client := NewClient2(&tcpPackager{}, transporter)
+	var v struct {
+		Word  uint16 `s7:"offset=20.0"`
+		Bytes [3]byte
+	}
+	if err := client.ReadStruct(1, &v); err != nil {
+		t.Fatal(err)
+	}
+	// one request for the whole span from byte 20 to 26
+	if !equalInts(transporter.requested, []int{6}) {
+		t.Fatalf("unexpected requests: %v", transporter.requested)
+	}
+	if v.Word != 0x1415 || v.Bytes != [3]byte{22, 23, 24} {
+		t.Fatalf("unexpected values: %+v", v)
+	}
+}
@@ -0,0 +1,280 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An MC7 block as stored in load memory (STEP 7 backups, UploadBlock) of S7-300/400 CPUs is laid out as:
+//
+//	header     36 bytes: 0x70 0x70, version, flags, language, sub block type, number, load memory length,
+//	           security, code and interface timestamps, interface, segment table, local data and MC7 length
+//	body       MC7 code of OBs, FCs and FBs, actual values of DBs
+//	interface  1 byte block type, 2 bytes (little endian) length of the parameter list, 2 bytes (little
+//	           endian) length of the start values, parameter list, start values
+//	segments   segment table of the networks
+//	footer     36 bytes: author, family, name, version, unknown, checksum, reserved
+//
+// Every parameter of the list takes a type byte and a kind byte (the section in the low 3 bits, 0x08 flags
+// a start value). An ARRAY continues with its dimension count, the bounds (little endian INTs) of every
+// dimension and the element, a STRUCT with its member count and the members, a STRING with its maximum length.
+
+// Sections of the parameters of a block interface
+const (
+	MC7In    = 1
+	MC7Out   = 2
+	MC7InOut = 3
+	MC7Stat  = 4
+	MC7Temp  = 5
+	MC7Ret   = 6
+)
+
+const (
+	mc7Array      = 0x10
+	mc7Struct     = 0x11
+	mc7String     = 0x13
+	mc7StartValue = 0x08
+	// sizeMC7Interface is the size of the head of the interface section
+	sizeMC7Interface = 5
+)
+
+// mc7Types are the names of the type bytes of the parameters, the data type codes of ANY pointers
+var mc7Types = map[byte]string{
+	0x01: "BOOL",
+	0x02: "BYTE",
+	0x03: "CHAR",
+	0x04: "WORD",
+	0x05: "INT",
+	0x06: "DWORD",
+	0x07: "DINT",
+	0x08: "REAL",
+	0x09: "DATE",
+	0x0A: "TIME_OF_DAY",
+	0x0B: "TIME",
+	0x0C: "S5TIME",
+	0x0E: "DATE_AND_TIME",
+	0x10: "ARRAY",
+	0x11: "STRUCT",
+	0x13: "STRING",
+	0x14: "POINTER",
+	0x16: "ANY",
+	0x17: "BLOCK_FB",
+	0x18: "BLOCK_FC",
+	0x19: "BLOCK_DB",
+	0x1A: "BLOCK_SDB",
+	0x1C: "COUNTER",
+	0x1D: "TIMER",
+}
+
+// MC7Block is a decoded MC7 block, see ParseMC7
+type MC7Block struct {
+	// S7BlockInfo holds the fields of header and footer like GetAgBlockInfo
+	S7BlockInfo
+	CodeTime      time.Time // last change of the code, UTC
+	InterfaceTime time.Time // last change of the interface, UTC
+	// Interface lists the parameters, static and temporary variables of code blocks and the variables of DBs
+	Interface []MC7Parameter
+	// StartValues are the start values of the interface as stored, in the order of the flagged parameters
+	StartValues []byte
+	// Body is the MC7 code of OBs, FCs and FBs or the actual values of DBs
+	Body []byte
+	// Segments is the segment table of the networks
+	Segments []byte
+	// Footer is the raw footer, its fields are decoded into S7BlockInfo
+	Footer []byte
+}
+
+// MC7Parameter is a parameter or variable of a block interface
+type MC7Parameter struct {
+	Kind       int    // MC7In, MC7Out, MC7InOut, MC7Stat, MC7Temp or MC7Ret
+	Type       string // BOOL, INT, ..., ARRAY, STRUCT, STRING
+	StartValue bool   // a start value is stored for the parameter
+	// Length is the maximum length of a STRING
+	Length int
+	// Bounds are the lower and upper bounds of the dimensions of an ARRAY
+	Bounds [][2]int
+	// Element is the element of an ARRAY
+	Element *MC7Parameter
+	// Members are the members of a STRUCT
+	Members []MC7Parameter
+}
+
+// String returns the type in STEP 7 notation, such as ARRAY[1..4, 0..1] OF STRING[20]
+func (p MC7Parameter) String() string {
+	switch p.Type {
+	case "STRING":
+		return "STRING[" + strconv.Itoa(p.Length) + "]"
+	case "ARRAY":
+		bounds := make([]string, len(p.Bounds))
+		for i, b := range p.Bounds {
+			bounds[i] = strconv.Itoa(b[0]) + ".." + strconv.Itoa(b[1])
+		}
+		element := "?"
+		if p.Element != nil {
+			element = p.Element.String()
+		}
+		return "ARRAY[" + strings.Join(bounds, ", ") + "] OF " + element
+	}
+	return p.Type
+}
+
+// ParseMC7 decodes an MC7 block as stored in load memory, e.g. returned by UploadBlock
+func ParseMC7(data []byte) (*MC7Block, error) {
+	blockType, number, mc7Size, err := blockHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	b := &MC7Block{}
+	b.BlkType = blockType
+	b.BlkNumber = number
+	b.BlkFlags = int(data[3])
+	b.BlkLang = int(data[4])
+	b.LoadSize = len(data)
+	b.CodeTime = mc7Timestamp(data[16:])
+	b.InterfaceTime = mc7Timestamp(data[22:])
+	b.CodeDate = siemensTimestamp(int64(binary.BigEndian.Uint16(data[20:])))
+	b.IntfDate = siemensTimestamp(int64(binary.BigEndian.Uint16(data[26:])))
+	b.SBBLength = int(binary.BigEndian.Uint16(data[28:]))
+	b.LocalData = int(binary.BigEndian.Uint16(data[32:]))
+	b.MC7Size = mc7Size
+
+	footer := data[len(data)-sizeBlockFooter:]
+	b.Footer = footer
+	b.Author = string(footer[0:8])
+	b.Family = string(footer[8:16])
+	b.Header = string(footer[16:24])
+	b.Version = int(footer[24])
+	b.CheckSum = int(binary.BigEndian.Uint16(footer[26:]))
+
+	b.Body = data[sizeBlockHeader : sizeBlockHeader+mc7Size]
+	rest := data[sizeBlockHeader+mc7Size : len(data)-sizeBlockFooter]
+	if len(rest) < sizeMC7Interface {
+		// no interface, e.g. an SDB
+		b.Segments = rest
+		return b, nil
+	}
+	length := int(binary.LittleEndian.Uint16(rest[1:]))
+	values := int(binary.LittleEndian.Uint16(rest[3:]))
+	if sizeMC7Interface+length > len(rest) {
+		return nil, fmt.Errorf("s7: MC7 interface of %d bytes exceeds the block", length)
+	}
+	p := &mc7Parser{data: rest[sizeMC7Interface : sizeMC7Interface+length], offset: sizeBlockHeader + mc7Size + sizeMC7Interface}
+	for p.pos < len(p.data) {
+		param, err := p.parameter()
+		if err != nil {
+			return nil, err
+		}
+		b.Interface = append(b.Interface, param)
+	}
+	// the segment table follows the start values
+	end := sizeMC7Interface + length + values
+	if end > len(rest) {
+		end = len(rest)
+	}
+	b.StartValues = rest[sizeMC7Interface+length : end]
+	b.Segments = rest[end:]
+	return b, nil
+}
+
+// mc7Timestamp decodes a timestamp of the header: milliseconds since midnight and days since 1984-01-01
+func mc7Timestamp(data []byte) time.Time {
+	ms := time.Duration(binary.BigEndian.Uint32(data)) * time.Millisecond
+	days := int(binary.BigEndian.Uint16(data[4:]))
+	return time.Date(1984, 1, 1+days, 0, 0, 0, 0, time.UTC).Add(ms)
+}
+
+// mc7Parser decodes the parameter list of an interface, offset is the position of data in the block
+type mc7Parser struct {
+	data   []byte
+	pos    int
+	offset int
+}
+
+func (p *mc7Parser) fail(format string, v ...interface{}) error {
+	return fmt.Errorf("s7: MC7 interface at byte %d: %s", p.offset+p.pos, fmt.Sprintf(format, v...))
+}
+
+func (p *mc7Parser) next() (byte, error) {
+	if p.pos >= len(p.data) {
+		return 0, p.fail("unexpected end of the parameter list")
+	}
+	p.pos++
+	return p.data[p.pos-1], nil
+}
+
+// bound reads an array bound, a little endian INT
+func (p *mc7Parser) bound() (int, error) {
+	if p.pos+2 > len(p.data) {
+		return 0, p.fail("unexpected end of the parameter list")
+	}
+	p.pos += 2
+	return int(int16(binary.LittleEndian.Uint16(p.data[p.pos-2:]))), nil
+}
+
+// parameter decodes the next parameter with its elements or members
+func (p *mc7Parser) parameter() (param MC7Parameter, err error) {
+	typeCode, err := p.next()
+	if err != nil {
+		return
+	}
+	kind, err := p.next()
+	if err != nil {
+		return
+	}
+	name, ok := mc7Types[typeCode]
+	if !ok {
+		return param, p.fail("unknown type 0x%02X", typeCode)
+	}
+	param = MC7Parameter{Kind: int(kind & 0x07), Type: name, StartValue: kind&mc7StartValue != 0}
+	switch typeCode {
+	case mc7String:
+		var length byte
+		length, err = p.next()
+		param.Length = int(length)
+	case mc7Array:
+		var dims byte
+		if dims, err = p.next(); err != nil {
+			return
+		}
+		if dims == 0 || dims > 6 {
+			return param, p.fail("ARRAY of %d dimensions", dims)
+		}
+		for i := 0; i < int(dims); i++ {
+			var low, high int
+			if low, err = p.bound(); err != nil {
+				return
+			}
+			if high, err = p.bound(); err != nil {
+				return
+			}
+			if high < low {
+				return param, p.fail("ARRAY bounds %d..%d", low, high)
+			}
+			param.Bounds = append(param.Bounds, [2]int{low, high})
+		}
+		var element MC7Parameter
+		if element, err = p.parameter(); err != nil {
+			return
+		}
+		param.Element = &element
+	case mc7Struct:
+		var count byte
+		if count, err = p.next(); err != nil {
+			return
+		}
+		for i := 0; i < int(count); i++ {
+			var member MC7Parameter
+			if member, err = p.parameter(); err != nil {
+				return
+			}
+			param.Members = append(param.Members, member)
+		}
+	}
+	return
+}
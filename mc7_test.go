@@ -0,0 +1,103 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// mc7Block builds a block as stored in load memory with the given body, parameter list and start values
+func mc7Block(blockType byte, number int, body []byte, params []byte, values []byte) []byte {
+	block := make([]byte, sizeBlockHeader, sizeBlockHeader+len(body)+sizeMC7Interface+len(params)+len(values)+sizeBlockFooter)
+	block[0], block[1] = 0x70, 0x70
+	block[3] = 0x01
+	block[4] = 5
+	block[5] = blockType
+	binary.BigEndian.PutUint16(block[6:], uint16(number))
+	// 22.01.2018 12:00:00.5
+	binary.BigEndian.PutUint32(block[16:], 12*3600*1000+500)
+	binary.BigEndian.PutUint16(block[20:], siemensDays("22.01.2018"))
+	binary.BigEndian.PutUint16(block[26:], siemensDays("21.01.2018"))
+	binary.BigEndian.PutUint16(block[32:], 20)
+	binary.BigEndian.PutUint16(block[34:], uint16(len(body)))
+	block = append(block, body...)
+	head := []byte{blockType, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint16(head[1:], uint16(len(params)))
+	binary.LittleEndian.PutUint16(head[3:], uint16(len(values)))
+	block = append(append(append(block, head...), params...), values...)
+	footer := make([]byte, sizeBlockFooter)
+	copy(footer, "gos7    MOTORS  VALVE   ")
+	footer[24] = 0x12
+	binary.BigEndian.PutUint16(footer[26:], 0xBEEF)
+	block = append(block, footer...)
+	binary.BigEndian.PutUint32(block[8:], uint32(len(block)))
+	return block
+}
+
+func TestParseMC7(t *testing.T) {
+	params := []byte{
+		0x05, MC7In, // INT
+		0x10, MC7Out, 2, 1, 0, 3, 0, 0xFF, 0xFF, 0, 0, 0x08, MC7Out, // ARRAY[1..3, -1..0] OF REAL
+		0x11, MC7InOut, 2, 0x01, MC7InOut, 0x13, MC7InOut, 10, // STRUCT BOOL, STRING[10] END_STRUCT
+		0x04, MC7Stat | mc7StartValue, // WORD := start value
+	}
+	block := mc7Block(S7BlockFB, 12, []byte{0x70, 0x0B, 0x00, 0x02}, params, []byte{0x12, 0x34})
+	b, err := ParseMC7(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.BlkType != S7BlockFB || b.BlkNumber != 12 || b.BlkLang != 5 || b.BlkFlags != 1 || b.LoadSize != len(block) ||
+		b.MC7Size != 4 || b.LocalData != 20 || b.CodeDate != "22.01.2018" || b.IntfDate != "21.01.2018" {
+		t.Fatalf("unexpected header %+v", b.S7BlockInfo)
+	}
+	if !b.CodeTime.Equal(time.Date(2018, 1, 22, 12, 0, 0, 500e6, time.UTC)) {
+		t.Fatalf("unexpected code time %v", b.CodeTime)
+	}
+	if b.Author != "gos7    " || b.Family != "MOTORS  " || b.Header != "VALVE   " || b.Version != 0x12 || b.CheckSum != 0xBEEF {
+		t.Fatalf("unexpected footer %+v", b.S7BlockInfo)
+	}
+	if !bytes.Equal(b.Body, []byte{0x70, 0x0B, 0x00, 0x02}) || !bytes.Equal(b.StartValues, []byte{0x12, 0x34}) || len(b.Segments) != 0 {
+		t.Fatalf("unexpected sections % x / % x / % x", b.Body, b.StartValues, b.Segments)
+	}
+	if len(b.Interface) != 4 {
+		t.Fatalf("unexpected interface %+v", b.Interface)
+	}
+	expected := []struct {
+		kind int
+		typ  string
+	}{{MC7In, "INT"}, {MC7Out, "ARRAY[1..3, -1..0] OF REAL"}, {MC7InOut, "STRUCT"}, {MC7Stat, "WORD"}}
+	for i, e := range expected {
+		if p := b.Interface[i]; p.Kind != e.kind || p.String() != e.typ {
+			t.Errorf("parameter %d: %d %s instead of %d %s", i, p.Kind, p, e.kind, e.typ)
+		}
+	}
+	if members := b.Interface[2].Members; len(members) != 2 || members[1].String() != "STRING[10]" {
+		t.Errorf("unexpected members %+v", members)
+	}
+	if !b.Interface[3].StartValue || b.Interface[0].StartValue {
+		t.Errorf("unexpected start value flags %+v", b.Interface)
+	}
+}
+
+func TestParseMC7Errors(t *testing.T) {
+	if _, err := ParseMC7(make([]byte, 80)); err == nil {
+		t.Fatal("expected an error for a block without header")
+	}
+	// unknown type
+	if _, err := ParseMC7(mc7Block(S7BlockFC, 1, nil, []byte{0x7F, MC7In}, nil)); err == nil {
+		t.Fatal("expected an error for an unknown type")
+	}
+	// the STRUCT misses a member
+	if _, err := ParseMC7(mc7Block(S7BlockFC, 1, nil, []byte{0x11, MC7Temp, 2, 0x01, MC7Temp}, nil)); err == nil {
+		t.Fatal("expected an error for a truncated STRUCT")
+	}
+	block := mc7Block(S7BlockDB, 1, make([]byte, 4), []byte{0x07, MC7Stat}, nil)
+	binary.LittleEndian.PutUint16(block[sizeBlockHeader+4+1:], 100)
+	if _, err := ParseMC7(block); err == nil {
+		t.Fatal("expected an error for an interface exceeding the block")
+	}
+}
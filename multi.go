@@ -6,6 +6,7 @@ package gos7
 import (
 	"encoding/binary"
 	"fmt"
+	"sync"
 )
 
 //S7DataItem which expose as S7DataItem to use in Multiple read/write
@@ -46,6 +47,7 @@ func (mb *client) AGWriteMulti(dataItems []S7DataItem, itemsCount int) (err erro
 
 		// Adjusts the offset
 		var addr int
+		// bits take the bit address (byte*8 + bit) in Start, Bit is not used
 		if dataItems[i].WordLen == s7wlbit || dataItems[i].WordLen == s7wlcounter || dataItems[i].WordLen == s7wltimer {
 			addr = dataItems[i].Start
 		} else {
@@ -73,8 +75,7 @@ func (mb *client) AGWriteMulti(dataItems []S7DataItem, itemsCount int) (err erro
 			itemDataSize = dataItems[i].Amount
 			binary.BigEndian.PutUint16(s7ItemWrite[2:], uint16(itemDataSize))
 			break
-		case s7wlcounter:
-		case s7wltimer:
+		case s7wlcounter, s7wltimer:
 			s7ItemWrite[1] = tsResOctet
 			itemDataSize = dataItems[i].Amount * 2
 			binary.BigEndian.PutUint16(s7ItemWrite[2:], uint16(itemDataSize))
@@ -101,17 +102,14 @@ func (mb *client) AGWriteMulti(dataItems []S7DataItem, itemsCount int) (err erro
 		offset = offset + itemDataSize + 4
 		dataLength = dataLength + itemDataSize + 4
 	}
-	tt, _ := interface{}(mb.transporter).(*TCPClientHandler)
 	//Checks the size
-	if offset > tt.PDULength {
+	if offset > mb.pduLength() {
 		err = fmt.Errorf(ErrorText(errCliSizeOverPDU))
 		return
 	}
 	binary.BigEndian.PutUint16(s7Multi[2:], uint16(offset))      // Whole size
 	binary.BigEndian.PutUint16(s7Multi[15:], uint16(dataLength)) // Whole size
 	request := NewProtocolDataUnit(s7Multi)
-	//debug
-	fmt.Printf("%d", s7Multi)
 	//send
 	response, err := mb.send(&request)
 	if err == nil {
@@ -182,8 +180,7 @@ func (mb *client) AGReadMulti(dataItems []S7DataItem, itemsCount int) (err error
 		s7Multi = append(s7Multi, s7Item...)
 		offset += len(s7Item)
 	}
-	tt, _ := interface{}(mb.transporter).(*TCPClientHandler)
-	if offset > tt.PDULength {
+	if offset > mb.pduLength() {
 		err = fmt.Errorf(ErrorText(errCliSizeOverPDU))
 		return
 	}
@@ -239,3 +236,187 @@ func (mb *client) AGReadMulti(dataItems []S7DataItem, itemsCount int) (err error
 	return
 
 }
+
+const (
+	maxMultiItems        = 20 // items of a multi read/write request
+	sizeMultiHeader      = 12 // S7 job header (10 bytes), function and item count
+	sizeMultiReplyHeader = 14 // S7 ack data header (12 bytes), function and item count
+	sizeMultiItem        = 12 // variable specification of an item
+	sizeMultiItemData    = 4  // return code, transport size and length of the item data
+)
+
+// itemDataSize returns the number of bytes of an item
+func itemDataSize(item *S7DataItem) int {
+	switch item.WordLen {
+	case s7wlbit:
+		return item.Amount
+	case s7wlcounter, s7wltimer:
+		return item.Amount * 2
+	}
+	return item.Amount * dataSizeByte(item.WordLen)
+}
+
+// itemDataSizePadded returns the size of an item on the wire, odd sizes are rounded up
+func itemDataSizePadded(item *S7DataItem) int {
+	size := itemDataSize(item)
+	return size + size%2
+}
+
+// checkItem validates an item before it is packed into a request
+func checkItem(item *S7DataItem) string {
+	if item.Amount <= 0 || dataSizeByte(item.WordLen) == 0 && item.WordLen != s7wlcounter && item.WordLen != s7wltimer {
+		return ErrorText(errCliInvalidParams)
+	}
+	if len(item.Data) < itemDataSize(item) {
+		return ErrorText(errCliBufferTooSmall)
+	}
+	return ""
+}
+
+// ReadItems reads any number of items. They are packed in order into as many multi read requests
+// as needed, so that neither a request nor its reply exceeds the negotiated PDU size and no request
+// carries more than 20 items. An item which does not fit into a reply on its own is read with
+// consecutive requests like AGReadDB.
+// The requests overlap as far as the PLC accepts parallel jobs if the client sends through a
+// TCPClientHandler with Pipelining, with any other transporter they are sent one after another.
+// The result of every item is reported in its Error field, "" on success. The first error of a
+// request is returned as well, the remaining requests are sent anyway.
+func (mb *client) ReadItems(items []S7DataItem) (err error) {
+	pduLength := mb.pduLength()
+	// indexes of the items of every multi read request
+	var requests [][]int
+	var pending []int
+	requestSize, replySize := isoHSize+sizeMultiHeader, sizeMultiReplyHeader
+	for i := range items {
+		item := &items[i]
+		if item.Error = checkItem(item); item.Error != "" {
+			continue
+		}
+		itemReplySize := sizeMultiItemData + itemDataSizePadded(item)
+		if sizeMultiReplyHeader+itemReplySize > pduLength {
+			// too large for a multi read
+			if readErr := mb.readArea(item.Area, item.DBNumber, item.Start, item.Amount, item.WordLen, item.Data); readErr != nil {
+				item.Error = readErr.Error()
+				if err == nil {
+					err = readErr
+				}
+			}
+			continue
+		}
+		if len(pending) == maxMultiItems || requestSize+sizeMultiItem > pduLength || replySize+itemReplySize > pduLength {
+			requests = append(requests, pending)
+			pending = nil
+			requestSize, replySize = isoHSize+sizeMultiHeader, sizeMultiReplyHeader
+		}
+		pending = append(pending, i)
+		requestSize += sizeMultiItem
+		replySize += itemReplySize
+	}
+	if len(pending) > 0 {
+		requests = append(requests, pending)
+	}
+	// the requests are sent in parallel as far as the PLC accepts parallel jobs, only by the pipelining
+	// TCP transporter though: other transporters are not required to be safe for concurrent use
+	jobs := 1
+	if handler, ok := mb.transporter.(*TCPClientHandler); ok && handler.Pipelining && handler.MaxParallelJobs() > 1 {
+		jobs = handler.MaxParallelJobs()
+	}
+	errs := make([]error, len(requests))
+	slots := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for r, request := range requests {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(r int, request []int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			chunk := make([]S7DataItem, len(request))
+			for i, index := range request {
+				chunk[i] = items[index]
+			}
+			errs[r] = mb.AGReadMulti(chunk, len(chunk))
+			for i, index := range request {
+				if errs[r] != nil {
+					items[index].Error = errs[r].Error()
+				} else {
+					items[index].Error = chunk[i].Error
+				}
+			}
+		}(r, request)
+	}
+	wg.Wait()
+	for _, requestErr := range errs {
+		if requestErr != nil && err == nil {
+			err = requestErr
+		}
+	}
+	return
+}
+
+// WriteItems writes any number of items. They are packed in order into as many multi write requests
+// as needed, so that no request exceeds the negotiated PDU size or carries more than 20 items.
+// An item which does not fit into a request on its own is written with consecutive requests like AGWriteDB.
+// The result of every item is reported in its Error field, "" on success. The first error of a
+// request is returned as well, the remaining requests are sent anyway.
+func (mb *client) WriteItems(items []S7DataItem) (err error) {
+	pduLength := mb.pduLength()
+	var pending []int
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		chunk := make([]S7DataItem, len(pending))
+		for i, index := range pending {
+			chunk[i] = items[index]
+			if chunk[i].WordLen == s7wlbit {
+				// AGWriteMulti takes the bit address in Start
+				chunk[i].Start, chunk[i].Bit = chunk[i].Start<<3+chunk[i].Bit, 0
+			}
+		}
+		if writeErr := mb.AGWriteMulti(chunk, len(chunk)); writeErr != nil {
+			for _, index := range pending {
+				items[index].Error = writeErr.Error()
+			}
+			if err == nil {
+				err = writeErr
+			}
+		} else {
+			for i, index := range pending {
+				items[index].Error = chunk[i].Error
+			}
+		}
+		pending = pending[:0]
+	}
+	requestSize := isoHSize + sizeMultiHeader
+	for i := range items {
+		item := &items[i]
+		if item.Error = checkItem(item); item.Error != "" {
+			continue
+		}
+		itemRequestSize := sizeMultiItem + sizeMultiItemData + itemDataSizePadded(item)
+		if isoHSize+sizeMultiHeader+itemRequestSize > pduLength {
+			// too large for a multi write
+			start := item.Start
+			if item.WordLen == s7wlbit {
+				start = start<<3 + item.Bit
+			}
+			if writeErr := mb.writeArea(item.Area, item.DBNumber, start, item.Amount, item.WordLen, item.Data); writeErr != nil {
+				item.Error = writeErr.Error()
+				if err == nil {
+					err = writeErr
+				}
+			}
+			continue
+		}
+		if len(pending) == maxMultiItems || requestSize+itemRequestSize > pduLength {
+			flush()
+			requestSize = isoHSize + sizeMultiHeader
+		}
+		pending = append(pending, i)
+		requestSize += itemRequestSize
+	}
+	flush()
+	return
+}
@@ -0,0 +1,217 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// multiTransporter answers multi read requests with the byte offsets as data, DB 99 does not exist.
+// Single read requests are answered by the embedded readTransporter.
+type multiTransporter struct {
+	readTransporter
+	itemCounts []int
+}
+
+func (t *multiTransporter) Send(request []byte) ([]byte, error) {
+	if request[18] == 1 {
+		return t.readTransporter.Send(request)
+	}
+	if len(request)-isoHSize > minPduLength {
+		return nil, fmt.Errorf("request of %d bytes exceeds the PDU", len(request))
+	}
+	count := int(request[18])
+	t.itemCounts = append(t.itemCounts, count)
+	response := make([]byte, 21)
+	copy(response, tpktISOTelegram)
+	response[7] = 0x32
+	response[8] = 3
+	copy(response[11:13], request[11:13])
+	response[19] = 4
+	response[20] = byte(count)
+	for i := 0; i < count; i++ {
+		item := request[19+i*12:]
+		amount := int(binary.BigEndian.Uint16(item[4:])) * dataSizeByte(int(item[3]))
+		start := (int(item[9])<<16 + int(item[10])<<8 + int(item[11])) >> 3
+		if binary.BigEndian.Uint16(item[6:]) == 99 {
+			response = append(response, 0x0A, 0, 0, 0)
+			continue
+		}
+		response = append(response, 0xFF, tsResByte, byte(amount>>5), byte(amount<<3))
+		for j := 0; j < amount; j++ {
+			response = append(response, byte(start+j))
+		}
+		if amount%2 != 0 && i < count-1 {
+			response = append(response, 0)
+		}
+	}
+	if len(response)-isoHSize > minPduLength {
+		return nil, fmt.Errorf("reply of %d bytes exceeds the PDU", len(response))
+	}
+	binary.BigEndian.PutUint16(response[2:], uint16(len(response)))
+	return response, nil
+}
+
+func TestClientReadItems(t *testing.T) {
+	transporter := &multiTransporter{}
+	client := NewClient2(&tcpPackager{}, transporter)
+	items := make([]S7DataItem, 45)
+	for i := range items {
+		items[i] = S7DataItem{Area: s7areadb, WordLen: s7wlbyte, DBNumber: 1, Start: i, Amount: 3, Data: make([]byte, 3)}
+	}
+	items[7].DBNumber = 99
+	items[30].Amount = 300
+	items[30].Data = make([]byte, 300)
+	items[31].Data = nil
+	if err := client.ReadItems(items); err != nil {
+		t.Fatal(err)
+	}
+	// 18 item specifications fit into a request of 240 bytes
+	if !equalInts(transporter.itemCounts, []int{18, 18, 7}) {
+		t.Fatalf("unexpected requests: %v", transporter.itemCounts)
+	}
+	// the large item is read on its own
+	if !equalInts(transporter.requested, []int{222, 78}) {
+		t.Fatalf("unexpected single requests: %v", transporter.requested)
+	}
+	for i, item := range items {
+		switch i {
+		case 7:
+			if item.Error != ErrorText(errCliItemNotAvailable) {
+				t.Errorf("item %d: unexpected error %q", i, item.Error)
+			}
+		case 31:
+			if item.Error != ErrorText(errCliBufferTooSmall) {
+				t.Errorf("item %d: unexpected error %q", i, item.Error)
+			}
+		default:
+			if item.Error != "" {
+				t.Errorf("item %d: unexpected error %q", i, item.Error)
+			}
+			if item.Data[0] != byte(item.Start) || item.Data[item.Amount-1] != byte(item.Start+item.Amount-1) {
+				t.Errorf("item %d: unexpected data % x", i, item.Data[:3])
+			}
+		}
+	}
+}
+
+// jobsTransporter is a multiTransporter reporting parallel jobs, it is not safe for concurrent use
+type jobsTransporter struct {
+	multiTransporter
+	busy     int32
+	overlaps int32
+}
+
+func (t *jobsTransporter) PDUSize() int         { return minPduLength }
+func (t *jobsTransporter) MaxParallelJobs() int { return 3 }
+
+func (t *jobsTransporter) Send(request []byte) ([]byte, error) {
+	if atomic.AddInt32(&t.busy, 1) > 1 {
+		atomic.AddInt32(&t.overlaps, 1)
+	}
+	defer atomic.AddInt32(&t.busy, -1)
+	time.Sleep(5 * time.Millisecond)
+	return t.multiTransporter.Send(request)
+}
+
+func TestClientReadItemsSerial(t *testing.T) {
+	transporter := &jobsTransporter{}
+	client := NewClient2(&tcpPackager{}, transporter)
+	items := make([]S7DataItem, 45)
+	for i := range items {
+		items[i] = S7DataItem{Area: s7areadb, WordLen: s7wlbyte, DBNumber: 1, Start: i, Amount: 3, Data: make([]byte, 3)}
+	}
+	if err := client.ReadItems(items); err != nil {
+		t.Fatal(err)
+	}
+	// only the pipelining TCP transporter gets the requests at once
+	if n := atomic.LoadInt32(&transporter.overlaps); n != 0 || !equalInts(transporter.itemCounts, []int{18, 18, 9}) {
+		t.Fatalf("%d overlapping requests: %v", n, transporter.itemCounts)
+	}
+}
+
+func TestClientReadItemsReplySize(t *testing.T) {
+	transporter := &multiTransporter{}
+	client := NewClient2(&tcpPackager{}, transporter)
+	items := make([]S7DataItem, 20)
+	for i := range items {
+		items[i] = S7DataItem{Area: s7areadb, WordLen: s7wlword, DBNumber: 1, Start: i * 40, Amount: 10, Data: make([]byte, 20)}
+	}
+	if err := client.ReadItems(items); err != nil {
+		t.Fatal(err)
+	}
+	// the reply takes 24 bytes per item
+	if !equalInts(transporter.itemCounts, []int{9, 9, 2}) {
+		t.Fatalf("unexpected requests: %v", transporter.itemCounts)
+	}
+	for i, item := range items {
+		if item.Error != "" || item.Data[19] != byte(i*40+19) {
+			t.Errorf("item %d: unexpected result %q % x", i, item.Error, item.Data)
+		}
+	}
+}
+
+func TestClientWriteItems(t *testing.T) {
+	transporter := &multiWriteTransporter{}
+	client := NewClient2(&tcpPackager{}, transporter)
+	items := make([]S7DataItem, 12)
+	for i := range items {
+		items[i] = S7DataItem{Area: s7areadb, WordLen: s7wlbyte, DBNumber: 1, Start: i, Amount: 15, Data: make([]byte, 15)}
+	}
+	if err := client.WriteItems(items); err != nil {
+		t.Fatal(err)
+	}
+	// every item takes 12 + 4 + 16 bytes of the request
+	if !equalInts(transporter.itemCounts, []int{6, 6}) {
+		t.Fatalf("unexpected requests: %v", transporter.itemCounts)
+	}
+}
+
+func TestClientWriteBitItems(t *testing.T) {
+	server := NewServer()
+	db := make([]byte, 4)
+	server.RegisterArea(S7AreaDB, 1, db)
+	handler := startServer(t, server)
+	defer server.Close()
+	defer handler.Close()
+	client := NewClient(handler)
+
+	// AGWriteMulti takes the bit address in Start, DBX2.3
+	items := []S7DataItem{{Area: s7areadb, WordLen: s7wlbit, DBNumber: 1, Start: 2*8 + 3, Amount: 1, Data: []byte{1}}}
+	if err := client.AGWriteMulti(items, 1); err != nil {
+		t.Fatal(err)
+	}
+	// WriteItems takes byte and bit, DBX1.5
+	items = []S7DataItem{{Area: s7areadb, WordLen: s7wlbit, DBNumber: 1, Start: 1, Bit: 5, Amount: 1, Data: []byte{1}}}
+	if err := client.WriteItems(items); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(db, []byte{0, 0x20, 0x08, 0}) {
+		t.Fatalf("unexpected DB1 % x", db)
+	}
+}
+
+// multiWriteTransporter acknowledges multi write requests
+type multiWriteTransporter struct {
+	itemCounts []int
+}
+
+func (t *multiWriteTransporter) Send(request []byte) ([]byte, error) {
+	if len(request)-isoHSize > minPduLength {
+		return nil, fmt.Errorf("request of %d bytes exceeds the PDU", len(request))
+	}
+	count := int(request[18])
+	t.itemCounts = append(t.itemCounts, count)
+	response := []byte{3, 0, 0, 0, 2, 240, 128, 50, 3, 0, 0, request[11], request[12], 0, 2, 0, 0, 0, 0, 5, byte(count)}
+	for i := 0; i < count; i++ {
+		response = append(response, 0xFF)
+	}
+	binary.BigEndian.PutUint16(response[2:], uint16(len(response)))
+	return response, nil
+}
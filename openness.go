@@ -0,0 +1,229 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TIA Portal Openness exports a global DB as SW.Blocks.GlobalDB with its number, memory layout and the
+// members of the Static section, nested members for STRUCTs and the expanded members of UDTs:
+//
+//	<SW.Blocks.GlobalDB><AttributeList>
+//	  <Interface><Sections><Section Name="Static">
+//	    <Member Name="Speed" Datatype="Real"><Comment><MultiLanguageText Lang="en-US">...
+//	    <Member Name="Motor" Datatype="&quot;MotorType&quot;"><Sections><Section Name="None"><Member ...
+//	  </Section></Sections></Interface>
+//	  <MemoryLayout>Standard</MemoryLayout><Name>Motors</Name><Number>7</Number>
+//	</AttributeList></SW.Blocks.GlobalDB>
+//
+// and a tag table as SW.Tags.PlcTagTable with SW.Tags.PlcTag objects of name, data type and logical address.
+
+type opennessDocument struct {
+	GlobalDBs []opennessBlock    `xml:"SW.Blocks.GlobalDB"`
+	TagTables []opennessTagTable `xml:"SW.Tags.PlcTagTable"`
+}
+
+type opennessBlock struct {
+	Name         string            `xml:"AttributeList>Name"`
+	Number       int               `xml:"AttributeList>Number"`
+	MemoryLayout string            `xml:"AttributeList>MemoryLayout"`
+	Sections     []opennessSection `xml:"AttributeList>Interface>Sections>Section"`
+}
+
+type opennessSection struct {
+	Name    string           `xml:"Name,attr"`
+	Members []opennessMember `xml:"Member"`
+}
+
+type opennessMember struct {
+	Name     string            `xml:"Name,attr"`
+	Datatype string            `xml:"Datatype,attr"`
+	Comment  []opennessText    `xml:"Comment>MultiLanguageText"`
+	Members  []opennessMember  `xml:"Member"`
+	Sections []opennessSection `xml:"Sections>Section"`
+}
+
+type opennessText struct {
+	Lang string `xml:"Lang,attr"`
+	Text string `xml:",chardata"`
+}
+
+type opennessTagTable struct {
+	Name string        `xml:"AttributeList>Name"`
+	Tags []opennessTag `xml:"ObjectList>SW.Tags.PlcTag"`
+}
+
+type opennessTag struct {
+	Name     string `xml:"AttributeList>Name"`
+	DataType string `xml:"AttributeList>DataTypeName"`
+	Address  string `xml:"AttributeList>LogicalAddress"`
+	Comment  []struct {
+		Culture string `xml:"AttributeList>Culture"`
+		Text    string `xml:"AttributeList>Text"`
+	} `xml:"ObjectList>MultilingualText>ObjectList>MultilingualTextItem"`
+}
+
+// ParseOpenness reads the tags of a TIA Portal Openness XML export of global DBs or PLC tag tables.
+// The members of DBs with standard (not optimized) access are laid out by the S7 classic rules and named
+// "DB name.member", ARRAYs are expanded into their elements. DBs with optimized access have no absolute
+// addresses and are rejected. Comments are taken in English if there are several languages.
+func ParseOpenness(r io.Reader) ([]Tag, error) {
+	var doc opennessDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("s7: Openness XML: %v", err)
+	}
+	if len(doc.GlobalDBs) == 0 && len(doc.TagTables) == 0 {
+		return nil, fmt.Errorf("s7: Openness XML holds no global DB or PLC tag table")
+	}
+	var tags []Tag
+	for _, db := range doc.GlobalDBs {
+		if !strings.EqualFold(db.MemoryLayout, "Standard") {
+			return nil, fmt.Errorf("s7: DB %q has optimized block access, its members have no absolute addresses; "+
+				"export it with optimized block access disabled", db.Name)
+		}
+		var decls []sourceDecl
+		for _, section := range db.Sections {
+			if section.Name != "Static" {
+				continue
+			}
+			for _, member := range section.Members {
+				decl, err := opennessDecl(member, db.Name+"."+member.Name)
+				if err != nil {
+					return nil, err
+				}
+				decls = append(decls, decl)
+			}
+		}
+		l, err := layoutDecls(db.Name, db.Number, decls)
+		if err != nil {
+			return nil, fmt.Errorf("%v in DB %q", err, db.Name)
+		}
+		tags = append(tags, dbTags(l)...)
+	}
+	for _, table := range doc.TagTables {
+		for _, t := range table.Tags {
+			tag, err := t.tag()
+			if err != nil {
+				return nil, fmt.Errorf("s7: tag %q of %q: %v", t.Name, table.Name, err)
+			}
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+// opennessDecl converts a member of a DB interface into a declaration, path names the member in errors
+func opennessDecl(m opennessMember, path string) (decl sourceDecl, err error) {
+	tokens, err := tokenizeSource(m.Datatype)
+	if err != nil {
+		return decl, opennessError(path, err)
+	}
+	p := &sourceParser{tokens: tokens}
+	decl, err = opennessType(p, m, path)
+	if err == nil && !p.done() {
+		err = fmt.Errorf("s7: %s: invalid type %q", path, m.Datatype)
+	}
+	decl.name = m.Name
+	decl.kind = MC7Stat
+	decl.comment = opennessComment(m.Comment)
+	return
+}
+
+// opennessType reads a data type, STRUCTs and UDTs take the nested members
+func opennessType(p *sourceParser, m opennessMember, path string) (decl sourceDecl, err error) {
+	switch word := p.peek(); {
+	case word == "ARRAY":
+		p.pos++
+		decl.typ = "ARRAY"
+		if decl.bounds, err = p.bounds(); err != nil {
+			return decl, opennessError(path, err)
+		}
+		if err = p.expect("OF"); err != nil {
+			return decl, fmt.Errorf("s7: %s: invalid type %q", path, m.Datatype)
+		}
+		var elem sourceDecl
+		if elem, err = opennessType(p, m, path); err != nil {
+			return
+		}
+		decl.elem = &elem
+	case word == "STRUCT" || strings.HasPrefix(word, "\""):
+		p.pos++
+		decl.typ = "STRUCT"
+		members := m.Members
+		for _, section := range m.Sections {
+			members = append(members, section.Members...)
+		}
+		if len(members) == 0 {
+			return decl, fmt.Errorf("s7: %s: the members of %s are not in the export", path, m.Datatype)
+		}
+		for _, member := range members {
+			var d sourceDecl
+			if d, err = opennessDecl(member, path+"."+member.Name); err != nil {
+				return
+			}
+			decl.members = append(decl.members, d)
+		}
+	default:
+		if decl, err = p.declType(); err != nil {
+			return decl, opennessError(path, err)
+		}
+		decl.line = 0
+		if _, err = layoutType(decl.parameter()); err != nil {
+			return decl, fmt.Errorf("s7: %s: %s", path, strings.TrimPrefix(err.Error(), "s7: "))
+		}
+	}
+	return
+}
+
+// opennessError reports an error of the source parser for a member, the lines of a data type are meaningless
+func opennessError(path string, err error) error {
+	if e, ok := err.(*sourceError); ok {
+		return fmt.Errorf("s7: %s: %s", path, e.reason)
+	}
+	return err
+}
+
+// opennessComment returns the English text of a comment, the first text if there is no English one
+func opennessComment(texts []opennessText) string {
+	comment := ""
+	for _, t := range texts {
+		text := strings.TrimSpace(t.Text)
+		if strings.HasPrefix(t.Lang, "en") && text != "" {
+			return text
+		}
+		if comment == "" {
+			comment = text
+		}
+	}
+	return comment
+}
+
+// tag converts a tag of a tag table, the data type has to fit the address
+func (t opennessTag) tag() (tag Tag, err error) {
+	tag = Tag{Name: t.Name, Type: strings.ToUpper(t.DataType)}
+	if tag.Address, err = ParseAddress(t.Address); err != nil {
+		return
+	}
+	texts := make([]opennessText, len(t.Comment))
+	for i, c := range t.Comment {
+		texts[i] = opennessText{Lang: c.Culture, Text: c.Text}
+	}
+	tag.Comment = opennessComment(texts)
+	switch tag.Address.WordLen {
+	case s7wltimer, s7wlcounter:
+		return
+	}
+	typ, err := lookupS7Type(tag.Type)
+	if err != nil {
+		return
+	}
+	if (typ.bits == 1) != (tag.Address.WordLen == s7wlbit) || typ.bits > 1 && typ.bits != 8*tag.Address.Size() {
+		err = fmt.Errorf("%s does not fit %s", tag.Type, t.Address)
+	}
+	return
+}
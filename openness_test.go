@@ -0,0 +1,168 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"strings"
+	"testing"
+)
+
+const testOpennessDB = `<?xml version="1.0" encoding="utf-8"?>
+<Document>
+  <Engineering version="V17" />
+  <SW.Blocks.GlobalDB ID="0">
+    <AttributeList>
+      <Interface><Sections xmlns="http://www.siemens.com/automation/Openness/SW/Interface/v5">
+  <Section Name="Static">
+    <Member Name="Speed" Datatype="Real" Remanence="NonRetain" Accessibility="Public">
+      <Comment>
+        <MultiLanguageText Lang="de-DE">Drehzahl</MultiLanguageText>
+        <MultiLanguageText Lang="en-US">speed</MultiLanguageText>
+      </Comment>
+      <StartValue>1.5</StartValue>
+    </Member>
+    <Member Name="Flags" Datatype="Array[0..2] of Bool" />
+    <Member Name="Motor" Datatype="&quot;MotorType&quot;">
+      <Sections>
+        <Section Name="None">
+          <Member Name="Running" Datatype="Bool" />
+          <Member Name="Name" Datatype="String[20]" />
+        </Section>
+      </Sections>
+    </Member>
+    <Member Name="Items" Datatype="Array[1..2] of Struct">
+      <Member Name="Count" Datatype="Int" />
+      <Member Name="Stamp" Datatype="Date_And_Time" />
+    </Member>
+  </Section>
+</Sections></Interface>
+      <MemoryLayout>Standard</MemoryLayout>
+      <Name>Motors</Name>
+      <Number>7</Number>
+      <ProgrammingLanguage>DB</ProgrammingLanguage>
+    </AttributeList>
+  </SW.Blocks.GlobalDB>
+</Document>
+`
+
+const testOpennessTags = `<?xml version="1.0" encoding="utf-8"?>
+<Document>
+  <Engineering version="V17" />
+  <SW.Tags.PlcTagTable ID="0">
+    <AttributeList><Name>Default tag table</Name></AttributeList>
+    <ObjectList>
+      <SW.Tags.PlcTag ID="1" CompositionName="Tags">
+        <AttributeList>
+          <DataTypeName>Bool</DataTypeName>
+          <ExternalAccessible>true</ExternalAccessible>
+          <LogicalAddress>%I0.1</LogicalAddress>
+          <Name>Start</Name>
+        </AttributeList>
+        <ObjectList>
+          <MultilingualText ID="2" CompositionName="Comment">
+            <ObjectList>
+              <MultilingualTextItem ID="3" CompositionName="Items">
+                <AttributeList><Culture>en-US</Culture><Text>start button</Text></AttributeList>
+              </MultilingualTextItem>
+            </ObjectList>
+          </MultilingualText>
+        </ObjectList>
+      </SW.Tags.PlcTag>
+      <SW.Tags.PlcTag ID="4" CompositionName="Tags">
+        <AttributeList>
+          <DataTypeName>Real</DataTypeName>
+          <LogicalAddress>%MD10</LogicalAddress>
+          <Name>Temperature</Name>
+        </AttributeList>
+      </SW.Tags.PlcTag>
+      <SW.Tags.PlcTag ID="5" CompositionName="Tags">
+        <AttributeList>
+          <DataTypeName>Timer</DataTypeName>
+          <LogicalAddress>%T5</LogicalAddress>
+          <Name>Delay</Name>
+        </AttributeList>
+      </SW.Tags.PlcTag>
+    </ObjectList>
+  </SW.Tags.PlcTagTable>
+</Document>
+`
+
+func TestParseOpennessDB(t *testing.T) {
+	tags, err := ParseOpenness(strings.NewReader(testOpennessDB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		name    string
+		address string
+		typ     string
+	}{
+		{"Motors.Speed", "DB7.DBD0", "REAL"},
+		{"Motors.Flags[0]", "DB7.DBX4.0", "BOOL"},
+		{"Motors.Flags[1]", "DB7.DBX4.1", "BOOL"},
+		{"Motors.Flags[2]", "DB7.DBX4.2", "BOOL"},
+		{"Motors.Motor.Running", "DB7.DBX6.0", "BOOL"},
+		{"Motors.Motor.Name", "DB7.DBSTRING8.20", "STRING[20]"},
+		{"Motors.Items[1].Count", "DB7.DBW30", "INT"},
+		{"Motors.Items[1].Stamp", "DB7.DBB32[8]", "DATE_AND_TIME"},
+		{"Motors.Items[2].Count", "DB7.DBW40", "INT"},
+		{"Motors.Items[2].Stamp", "DB7.DBB42[8]", "DATE_AND_TIME"},
+	}
+	if len(tags) != len(expected) {
+		t.Fatalf("%d tags instead of %d: %+v", len(tags), len(expected), tags)
+	}
+	for i, e := range expected {
+		if tag := tags[i]; tag.Name != e.name || tag.Address.String() != e.address || tag.Type != e.typ {
+			t.Errorf("tag %d: %s %s %s instead of %s %s %s", i, tag.Name, tag.Address, tag.Type, e.name, e.address, e.typ)
+		}
+	}
+	if tags[0].Comment != "speed" {
+		t.Errorf("unexpected comment %q", tags[0].Comment)
+	}
+}
+
+func TestParseOpennessTagTable(t *testing.T) {
+	tags, err := ParseOpenness(strings.NewReader(testOpennessTags))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 3 {
+		t.Fatalf("unexpected tags %+v", tags)
+	}
+	if tag := tags[0]; tag.Name != "Start" || tag.Address.String() != "E0.1" || tag.Type != "BOOL" || tag.Comment != "start button" {
+		t.Errorf("unexpected tag %+v", tag)
+	}
+	if tag := tags[1]; tag.Name != "Temperature" || tag.Address.String() != "MD10" || tag.Type != "REAL" {
+		t.Errorf("unexpected tag %+v", tag)
+	}
+	if tag := tags[2]; tag.Address.String() != "T5" {
+		t.Errorf("unexpected tag %+v", tag)
+	}
+}
+
+func TestParseOpennessErrors(t *testing.T) {
+	errors := map[string]string{
+		strings.Replace(testOpennessDB, "<MemoryLayout>Standard", "<MemoryLayout>Optimized", 1): `DB "Motors" has optimized block access`,
+		strings.Replace(testOpennessDB, `"Array[0..2] of Bool"`, `"Array[0..2] Bool"`, 1):       `Motors.Flags: invalid type "Array[0..2] Bool"`,
+		strings.Replace(testOpennessDB, `"Int"`, `"WChar"`, 1):                                  "Count: unknown type WCHAR",
+		strings.Replace(testOpennessTags, "%MD10", "%MW10", 1):                                  `tag "Temperature" of "Default tag table": REAL does not fit %MW10`,
+		strings.Replace(testOpennessTags, "%I0.1", "%I0.9", 1):                                  `tag "Start"`,
+		"<Document></Document>": "holds no global DB or PLC tag table",
+		"<Document>":            "Openness XML",
+	}
+	for src, expected := range errors {
+		if _, err := ParseOpenness(strings.NewReader(src)); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("error %v, expected %q", err, expected)
+		}
+	}
+	src := strings.Replace(testOpennessDB, `<Sections>
+        <Section Name="None">
+          <Member Name="Running" Datatype="Bool" />
+          <Member Name="Name" Datatype="String[20]" />
+        </Section>
+      </Sections>`, "", 1)
+	if _, err := ParseOpenness(strings.NewReader(src)); err == nil || !strings.Contains(err.Error(), `members of "MotorType" are not in the export`) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"errors"
+	"sort"
+)
+
+// ReadResult is the outcome of reading one address with ReadOptimized
+type ReadResult struct {
+	Address S7Address
+	// Data is the memory spanned by the address, see S7Address.Size
+	Data []byte
+	// Value is Data decoded like Read does, except for a single DB bit, which is a bool here
+	Value interface{}
+	Err   error
+}
+
+// readRange is a byte range of an area which is read with a single item
+type readRange struct {
+	area     int
+	dbNumber int
+	start    int // first byte, timers and counters take 2 bytes
+	end      int // byte after the range
+	members  []int
+}
+
+// addressRange returns the first byte and the byte after the memory spanned by an address,
+// timers and counters are numbered by element and take 2 bytes each
+func addressRange(a S7Address) (start int, end int) {
+	start = a.Start
+	if a.WordLen == s7wltimer || a.WordLen == s7wlcounter {
+		start *= 2
+	}
+	return start, start + a.Size()
+}
+
+// planReads merges the addresses of the same area and data block into ranges, two addresses
+// share a range if they overlap or at most maxGap unused bytes lie between them
+func planReads(addresses []S7Address, maxGap int) []readRange {
+	order := make([]int, len(addresses))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := addresses[order[i]], addresses[order[j]]
+		if a.Area != b.Area {
+			return a.Area < b.Area
+		}
+		if a.DBNumber != b.DBNumber {
+			return a.DBNumber < b.DBNumber
+		}
+		startA, _ := addressRange(a)
+		startB, _ := addressRange(b)
+		return startA < startB
+	})
+	var ranges []readRange
+	for _, index := range order {
+		a := addresses[index]
+		start, end := addressRange(a)
+		if n := len(ranges); n > 0 {
+			last := &ranges[n-1]
+			if last.area == a.Area && last.dbNumber == a.DBNumber && start-last.end <= maxGap {
+				if end > last.end {
+					last.end = end
+				}
+				last.members = append(last.members, index)
+				continue
+			}
+		}
+		ranges = append(ranges, readRange{area: a.Area, dbNumber: a.DBNumber, start: start, end: end, members: []int{index}})
+	}
+	return ranges
+}
+
+// item returns the multi read item of the range
+func (r *readRange) item() S7DataItem {
+	item := S7DataItem{Area: r.area, DBNumber: r.dbNumber, WordLen: s7wlbyte, Start: r.start, Amount: r.end - r.start}
+	switch r.area {
+	case s7areatm:
+		item.WordLen, item.Start, item.Amount = s7wltimer, r.start/2, item.Amount/2
+	case s7areact:
+		item.WordLen, item.Start, item.Amount = s7wlcounter, r.start/2, item.Amount/2
+	}
+	item.Data = make([]byte, r.end-r.start)
+	return item
+}
+
+// ReadOptimized reads many addresses with as few requests as possible: addresses of the same area and
+// data block are merged into one range if they overlap or if at most maxGap bytes lie between them,
+// the ranges are read with ReadItems and sliced back into one result per address, in the order of addresses.
+// A larger maxGap reads more unused bytes but needs fewer items, a gap of about 20 bytes costs less than
+// the 12 bytes of request and 4 bytes of reply overhead of another item.
+// The returned error is the first error of a request, the results tell which addresses failed.
+func (mb *client) ReadOptimized(addresses []S7Address, maxGap int) (results []ReadResult, err error) {
+	results = make([]ReadResult, len(addresses))
+	ranges := planReads(addresses, maxGap)
+	items := make([]S7DataItem, len(ranges))
+	for i := range ranges {
+		items[i] = ranges[i].item()
+	}
+	err = mb.ReadItems(items)
+	for i, r := range ranges {
+		for _, index := range r.members {
+			a := addresses[index]
+			results[index].Address = a
+			if items[i].Error != "" {
+				results[index].Err = errors.New(items[i].Error)
+				continue
+			}
+			start, end := addressRange(a)
+			results[index].Data = items[i].Data[start-r.start : end-r.start]
+			results[index].Value = a.decode(results[index].Data)
+		}
+	}
+	return
+}
@@ -0,0 +1,38 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"testing"
+)
+
+func TestClientReadOptimized(t *testing.T) {
+	var addresses []S7Address
+	for _, variable := range []string{"DB1.DBW20", "MB3", "DB1.DBD0", "DB2.DBB0", "DB1.DBX21.1", "DB1.DBW40", "MW0", "DB1.DBB8"} {
+		a, err := ParseAddress(variable)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addresses = append(addresses, a)
+	}
+	transporter := &multiTransporter{}
+	client := NewClient2(&tcpPackager{}, transporter)
+	results, err := client.ReadOptimized(addresses, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// MB0..MB3, DB1.DBB0..DBB8, DB1.DBB20..DBB21, DB1.DBW40 and DB2.DBB0 in a single request
+	if !equalInts(transporter.itemCounts, []int{5}) {
+		t.Fatalf("unexpected requests: %v", transporter.itemCounts)
+	}
+	expected := []interface{}{uint16(0x1415), byte(3), uint32(0x00010203), byte(0), false, uint16(0x2829), uint16(0x0001), byte(8)}
+	for i, result := range results {
+		if result.Err != nil || result.Value != expected[i] {
+			t.Errorf("%s: expected %v given %v (%v)", addresses[i], expected[i], result.Value, result.Err)
+		}
+	}
+	if r := planReads(addresses, 20); len(r) != 3 || r[1].end != 42 {
+		t.Errorf("unexpected ranges with a larger gap: %+v", r)
+	}
+}
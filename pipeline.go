@@ -0,0 +1,236 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// pipelineJobs is the number of jobs of the PLC kept until they are received
+const pipelineJobs = 4
+
+// pipeline sends several jobs over one connection without waiting for the replies of the previous ones.
+// Every request gets its own PDU reference, a reader goroutine hands the replies to the waiting requests
+// by their PDU reference. At most the negotiated count of parallel jobs is outstanding.
+type pipeline struct {
+	conn    net.Conn
+	logf    func(format string, v ...interface{})
+	slots   chan struct{}
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[uint16]chan []byte // nil channel: the request gave up, the reply is dropped
+	jobs    chan []byte            // jobs the PLC sends to the client, see receiveJob
+	nextRef uint16
+	err     error
+	done    chan struct{}
+}
+
+func newPipeline(conn net.Conn, jobs int, logf func(format string, v ...interface{})) *pipeline {
+	p := &pipeline{
+		conn:    conn,
+		logf:    logf,
+		slots:   make(chan struct{}, jobs),
+		pending: make(map[uint16]chan []byte),
+		jobs:    make(chan []byte, pipelineJobs),
+		done:    make(chan struct{}),
+	}
+	// the reader waits for replies as long as the connection lives, a lost reply is detected by send
+	conn.SetReadDeadline(time.Time{})
+	go p.read()
+	return p
+}
+
+// register reserves an unused PDU reference for a request
+func (p *pipeline) register() (ref uint16, reply chan []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		p.nextRef++
+		if _, used := p.pending[p.nextRef]; p.nextRef != 0 && !used {
+			break
+		}
+	}
+	reply = make(chan []byte, 1)
+	p.pending[p.nextRef] = reply
+	return p.nextRef, reply
+}
+
+// abandon marks a request as given up, its job slot is released by the reader when the reply arrives.
+// A reply that does not arrive within timeout breaks the pipeline as for a waiting request, so that the
+// slot is not kept for good. It returns false if the reply has arrived already.
+func (p *pipeline) abandon(ref uint16, timeout time.Duration) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.pending[ref]; !ok {
+		return false
+	}
+	p.pending[ref] = nil
+	if timeout > 0 {
+		time.AfterFunc(timeout, func() {
+			p.mu.Lock()
+			reply, ok := p.pending[ref]
+			p.mu.Unlock()
+			if ok && reply == nil {
+				p.fail(fmt.Errorf("s7: no reply for abandoned PDU reference %d within %v", ref, timeout))
+			}
+		})
+	}
+	return true
+}
+
+// fail stops the pipeline, the connection is closed and all waiting requests get err
+func (p *pipeline) fail(err error) {
+	p.mu.Lock()
+	if p.err == nil {
+		p.err = err
+		close(p.done)
+	}
+	p.mu.Unlock()
+	p.conn.Close()
+}
+
+// read dispatches the replies to the requests until the connection fails
+func (p *pipeline) read() {
+	data := make([]byte, tcpMaxLength)
+	for {
+		frame, _, err := readFrame(p.conn, data)
+		if err != nil {
+			p.fail(err)
+			return
+		}
+		if len(frame) < 13 || frame[7] != 0x32 {
+			p.logf("s7: discarding unexpected telegram % x", frame)
+			continue
+		}
+		if frame[8] == 1 {
+			select {
+			case p.jobs <- append([]byte{}, frame...):
+			default:
+				p.logf("s7: discarding job of the PLC % x", frame)
+			}
+			continue
+		}
+		ref := binary.BigEndian.Uint16(frame[11:])
+		p.mu.Lock()
+		reply, ok := p.pending[ref]
+		delete(p.pending, ref)
+		p.mu.Unlock()
+		switch {
+		case !ok:
+			p.logf("s7: discarding reply with unknown PDU reference %d", ref)
+		case reply == nil:
+			// the request gave up, its job is done now
+			<-p.slots
+		default:
+			reply <- append([]byte{}, frame...)
+		}
+	}
+}
+
+// send sends the request with its own PDU reference, which is written into request, and waits for
+// the reply. Waiting for a free job slot fails after timeout, a reply later than timeout breaks the pipeline.
+func (p *pipeline) send(ctx context.Context, request []byte, timeout time.Duration) (response []byte, err error) {
+	if len(request) < 13 || request[7] != 0x32 {
+		return nil, fmt.Errorf("s7: only S7 telegrams can be pipelined")
+	}
+	var full <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		full = timer.C
+	}
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-full:
+		return nil, fmt.Errorf("s7: no free job slot within %v", timeout)
+	case <-p.done:
+		return nil, p.err
+	}
+	released := false
+	defer func() {
+		if !released {
+			<-p.slots
+		}
+	}()
+	ref, reply := p.register()
+	binary.BigEndian.PutUint16(request[11:], ref)
+
+	p.writeMu.Lock()
+	p.logf("s7: sending % x", request)
+	if timeout > 0 {
+		p.conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	_, err = p.conn.Write(request)
+	p.writeMu.Unlock()
+	if err != nil {
+		p.fail(err)
+		return nil, err
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case response = <-reply:
+		p.logf("s7: received % x", response)
+		return response, nil
+	case <-ctx.Done():
+		// the reply is dropped by the reader, which releases the job slot then
+		released = p.abandon(ref, timeout)
+		return nil, ctx.Err()
+	case <-expired:
+		err = fmt.Errorf("s7: no reply for PDU reference %d within %v", ref, timeout)
+		p.fail(err)
+		return nil, err
+	case <-p.done:
+		return nil, p.err
+	}
+}
+
+// receiveJob waits for the next job of the PLC. A job later than timeout fails, the pipeline is kept.
+func (p *pipeline) receiveJob(ctx context.Context, timeout time.Duration) (job []byte, err error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case job = <-p.jobs:
+		p.logf("s7: received job % x", job)
+		return job, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-expired:
+		return nil, fmt.Errorf("s7: no job of the PLC within %v", timeout)
+	case <-p.done:
+		return nil, p.err
+	}
+}
+
+// reply sends the reply to a job of the PLC
+func (p *pipeline) reply(reply []byte, timeout time.Duration) (err error) {
+	p.writeMu.Lock()
+	p.logf("s7: sending % x", reply)
+	if timeout > 0 {
+		p.conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	_, err = p.conn.Write(reply)
+	p.writeMu.Unlock()
+	if err != nil {
+		p.fail(err)
+	}
+	return
+}
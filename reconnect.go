@@ -0,0 +1,83 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	reconnectInitialBackoff = 100 * time.Millisecond
+	reconnectMaxBackoff     = 10 * time.Second
+)
+
+// ReconnectPolicy configures how a TCPClientHandler re-establishes a lost connection.
+// The TCP connection, the ISO connection (COTP CR/CC) and the PDU negotiation are done again,
+// then the request which found the connection lost is repeated if it only reads from the PLC.
+type ReconnectPolicy struct {
+	// MaxAttempts is the number of connection attempts per request, 0 means a single attempt
+	MaxAttempts int
+	// InitialBackoff is the delay before the second attempt, it doubles with every further attempt
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between two attempts
+	MaxBackoff time.Duration
+	// Jitter randomizes every delay by up to this fraction (0..1) so that many clients do not redial at once
+	Jitter float64
+	// RetryWrites allows requests which change the PLC (write var, set clock) to be sent again
+	// on the new connection. The PLC may have executed them already before the connection was lost.
+	RetryWrites bool
+}
+
+// backoff returns the delay before the given attempt, attempt 1 being the first retry
+func (p *ReconnectPolicy) backoff(attempt int) time.Duration {
+	delay := p.InitialBackoff
+	if delay <= 0 {
+		delay = reconnectInitialBackoff
+	}
+	max := p.MaxBackoff
+	if max <= 0 {
+		max = reconnectMaxBackoff
+	}
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	if p.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(delay))
+	}
+	return delay
+}
+
+// retryable tells whether the request can be sent again after a reconnect
+func (p *ReconnectPolicy) retryable(request []byte) bool {
+	// S7 telegrams only, TPKT + COTP + S7 header + function
+	if len(request) < 19 || request[7] != 0x32 {
+		return false
+	}
+	switch request[8] {
+	case 1: // job
+		switch request[17] {
+		case 0x04: // read var
+			return true
+		case 0x05: // write var
+			return p.RetryWrites
+		}
+	case 7: // userdata
+		if len(request) < 24 {
+			return false
+		}
+		switch group, subfunction := request[22]&0x0F, request[23]; group {
+		case 0x03: // block functions: list, info
+			return true
+		case 0x04: // read SZL, only the first telegram, the following ones depend on the lost session
+			return subfunction == 0x01 && request[20] == 4
+		case 0x07: // clock: read or set
+			return subfunction == 0x01 || p.RetryWrites
+		}
+	}
+	return false
+}
@@ -0,0 +1,897 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	serverPDULength       = 480 // PDU length offered if Server.PDULength is not set
+	serverMaxParallelJobs = 1   // max AmQ offered if Server.MaxParallelJobs is not set
+	// sizeUserDataReply is the size of TPKT, COTP, S7 header, userdata parameters and data header of a reply
+	sizeUserDataReply = 33
+	// record lengths of the SZL answered by the server
+	szlCPUInfoRecord    = 34
+	szlCommRecord       = 40
+	szlProtectionRecord = 40
+	szlStatusRecord     = 20
+	// S7 error codes answered by the server
+	code7InvalidSZL = 0xD401
+	code7NoSuchJob  = 0xD802
+	blockLangDB     = 0x05 // language of a data block
+)
+
+// Server emulates an S7 CPU on ISO-on-TCP. It answers the connection request, the PDU negotiation,
+// read/write var (single and multi-item), SZL reads, block info and block list, clock and PLC control
+// telegrams of any rack and slot. The memory are byte areas registered with RegisterArea, the blocks
+// are the registered data blocks and the blocks added with RegisterBlock.
+// Set the exported fields before Start.
+type Server struct {
+	// PDULength is the largest PDU length agreed in the negotiation, 480 if not set
+	PDULength int
+	// MaxParallelJobs is answered as max AmQ calling and called in the negotiation, 1 if not set
+	MaxParallelJobs int
+	// CPUInfo is answered to SZL 0x001C (GetCPUInfo)
+	CPUInfo S7CpuInfo
+	// CPInfo is answered to SZL 0x0131 (GetCPInfo), MaxPduLength follows PDULength if not set
+	CPInfo S7CpInfo
+	// DiagnosticBuffer is answered to SZL 0x00A0 (GetDiagnosticBuffer), the newest event first
+	DiagnosticBuffer []DiagEvent
+	// OnRead runs before a client reads memory and OnWrite after a client wrote it, data are the accessed
+	// bytes from byte start of the area (of a bit: its byte, of timers and counters: 2 bytes each). They run
+	// with the memory locked, so they may change any registered area directly but not call the server.
+	OnRead  func(area int, dbNumber int, start int, data []byte)
+	OnWrite func(area int, dbNumber int, start int, data []byte)
+	Logger  *log.Logger
+
+	mu          sync.Mutex
+	areas       map[serverArea][]byte
+	blocks      map[serverBlock]S7BlockInfo
+	status      int
+	clockOffset time.Duration
+	listener    net.Listener
+	conns       map[net.Conn]struct{}
+	wg          sync.WaitGroup
+}
+
+type serverArea struct {
+	area     int
+	dbNumber int
+}
+
+type serverBlock struct {
+	blockType int
+	number    int
+}
+
+// NewServer allocates a server in RUN mode without any memory.
+func NewServer() *Server {
+	return &Server{
+		CPUInfo: S7CpuInfo{
+			ModuleTypeName: "CPU 315-2 PN/DP",
+			SerialNumber:   "S C-GOS7SERVER",
+			ASName:         "GOS7 SERVER",
+			Copyright:      "Original Siemens Equipment",
+			ModuleName:     "CPU 315-2 PN/DP",
+		},
+		CPInfo: S7CpInfo{MaxConnections: 16, MaxMpiRate: 187500, MaxBusRate: 12000000},
+		areas:  make(map[serverArea][]byte),
+		blocks: make(map[serverBlock]S7BlockInfo),
+		status: s7CpuStatusRun,
+		conns:  make(map[net.Conn]struct{}),
+	}
+}
+
+// areaKey validates an area, dbNumber only counts for S7AreaDB
+func areaKey(area int, dbNumber int) (serverArea, error) {
+	switch area {
+	case s7areadb:
+		if dbNumber < 1 || dbNumber > maxAddressDBNumber {
+			return serverArea{}, fmt.Errorf(ErrorText(errCliInvalidBlockNumber))
+		}
+	case s7areape, s7areapa, s7areamk, s7areatm, s7areact:
+		dbNumber = 0
+	default:
+		return serverArea{}, fmt.Errorf(ErrorText(errCliInvalidParams))
+	}
+	return serverArea{area, dbNumber}, nil
+}
+
+// RegisterArea makes data the memory of an area: S7AreaDB with the number of the data block, or S7AreaPE,
+// S7AreaPA, S7AreaMK, S7AreaTM and S7AreaCT, where dbNumber is ignored. Timers and counters take 2 bytes each.
+// The server works on data itself, access it with ReadArea and WriteArea while the server runs.
+func (s *Server) RegisterArea(area int, dbNumber int, data []byte) error {
+	key, err := areaKey(area, dbNumber)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.areas[key] = data
+	s.mu.Unlock()
+	return nil
+}
+
+// UnregisterArea removes an area, the clients get "item not available" for it again
+func (s *Server) UnregisterArea(area int, dbNumber int) {
+	if key, err := areaKey(area, dbNumber); err == nil {
+		s.mu.Lock()
+		delete(s.areas, key)
+		s.mu.Unlock()
+	}
+}
+
+// ReadArea copies len(buffer) bytes from start of a registered area
+func (s *Server) ReadArea(area int, dbNumber int, start int, buffer []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := s.area(area, dbNumber, start, len(buffer))
+	if err == nil {
+		copy(buffer, data)
+	}
+	return err
+}
+
+// WriteArea copies data to start of a registered area
+func (s *Server) WriteArea(area int, dbNumber int, start int, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	memory, err := s.area(area, dbNumber, start, len(data))
+	if err == nil {
+		copy(memory, data)
+	}
+	return err
+}
+
+// area returns size bytes from start of a registered area. Caller must hold the mutex.
+func (s *Server) area(area int, dbNumber int, start int, size int) ([]byte, error) {
+	key, err := areaKey(area, dbNumber)
+	if err != nil {
+		return nil, err
+	}
+	memory, ok := s.areas[key]
+	if !ok {
+		return nil, fmt.Errorf(ErrorText(errCliItemNotAvailable))
+	}
+	if start < 0 || start+size > len(memory) {
+		return nil, fmt.Errorf(ErrorText(errCliAddressOutOfRange))
+	}
+	return memory[start : start+size], nil
+}
+
+// RegisterBlock adds a block to the block list and answers info for GetAgBlockInfo. BlkType is one of
+// S7BlockOB, S7BlockDB, ..., CodeDate and IntfDate are formatted "02.01.2006". Registered data blocks are
+// listed without RegisterBlock, with the size of their area as MC7Size.
+func (s *Server) RegisterBlock(info S7BlockInfo) error {
+	if info.BlkType < S7BlockOB || info.BlkType > S7BlockSFB || info.BlkType == 0x09 {
+		return fmt.Errorf(ErrorText(errCliInvalidBlockType))
+	}
+	if info.BlkNumber < 0 || info.BlkNumber > maxAddressDBNumber {
+		return fmt.Errorf(ErrorText(errCliInvalidBlockNumber))
+	}
+	s.mu.Lock()
+	s.blocks[serverBlock{info.BlkType, info.BlkNumber}] = info
+	s.mu.Unlock()
+	return nil
+}
+
+// blockInfo returns the info of a block, registered data blocks have info even if not registered as block.
+// Caller must hold the mutex.
+func (s *Server) blockInfo(blockType int, number int) (info S7BlockInfo, ok bool) {
+	info, ok = s.blocks[serverBlock{blockType, number}]
+	if blockType != S7BlockDB {
+		return
+	}
+	if data, isArea := s.areas[serverArea{s7areadb, number}]; isArea {
+		if !ok {
+			info = S7BlockInfo{BlkType: S7BlockDB, BlkNumber: number, BlkLang: blockLangDB}
+			ok = true
+		}
+		if info.MC7Size == 0 {
+			info.MC7Size = len(data)
+		}
+	}
+	if ok && info.LoadSize == 0 {
+		info.LoadSize = info.MC7Size
+	}
+	return
+}
+
+// blockNumbers returns the sorted numbers of the blocks of a type. Caller must hold the mutex.
+func (s *Server) blockNumbers(blockType int) []int {
+	var numbers []int
+	for key := range s.blocks {
+		if key.blockType == blockType {
+			numbers = append(numbers, key.number)
+		}
+	}
+	if blockType == S7BlockDB {
+		for key := range s.areas {
+			if _, ok := s.blocks[serverBlock{S7BlockDB, key.dbNumber}]; key.area == s7areadb && !ok {
+				numbers = append(numbers, key.dbNumber)
+			}
+		}
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+// Status returns the PLC status answered to the clients, 8 run or 4 stop
+func (s *Server) Status() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// SetStatus changes the PLC status, like PLCHotStart (8 run) and PLCStop (4 stop) do
+func (s *Server) SetStatus(status int) {
+	s.mu.Lock()
+	s.status = status
+	s.mu.Unlock()
+}
+
+// Clock returns the time of the PLC clock, which runs with the system clock from the time set last
+func (s *Server) Clock() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Now().UTC().Add(s.clockOffset)
+}
+
+// SetClock sets the PLC clock, like PGClockRead does. The clock has no time zone,
+// it shows the date and time of t as they are.
+func (s *Server) SetClock(t time.Time) {
+	clock := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	s.mu.Lock()
+	s.clockOffset = clock.Sub(time.Now().UTC())
+	s.mu.Unlock()
+}
+
+// Start listens on address ("host:port", port 102 if it is missing) and serves clients in the background until Close
+func (s *Server) Start(address string) error {
+	if len(strings.Split(address, ":")) < 2 {
+		address = address + ":" + strconv.Itoa(isoTCP)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener != nil {
+		return fmt.Errorf("s7: server is already started on %v", s.listener.Addr())
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	s.wg.Add(1)
+	go s.serve(listener)
+	return nil
+}
+
+// Addr returns the address the server listens on, nil if it is not started
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Close stops listening, closes the client connections and waits until they are done
+func (s *Server) Close() (err error) {
+	s.mu.Lock()
+	listener := s.listener
+	s.listener = nil
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	if listener == nil {
+		return
+	}
+	err = listener.Close()
+	s.wg.Wait()
+	return
+}
+
+func (s *Server) logf(format string, v ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, v...)
+	}
+}
+
+func (s *Server) serve(listener net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.logf("s7 server: %v", err)
+			return
+		}
+		s.mu.Lock()
+		if s.listener != listener {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// serverConn is the state of a client connection
+type serverConn struct {
+	server  *Server
+	pdu     int
+	seq     byte
+	pending []byte // rest of a userdata reply larger than the PDU, fetched with follow-up requests
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+	s.logf("s7 server: client %v connected", conn.RemoteAddr())
+	c := &serverConn{server: s, pdu: minPduLength}
+	data := make([]byte, tcpMaxLength)
+	for {
+		request, err := readRequest(conn, data)
+		if err != nil {
+			if err != io.EOF {
+				s.logf("s7 server: client %v: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		s.logf("s7 server: received % x", request)
+		reply := c.handle(request)
+		if reply == nil {
+			continue
+		}
+		s.logf("s7 server: sending % x", reply)
+		if _, err = conn.Write(reply); err != nil {
+			s.logf("s7 server: client %v: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// readRequest reads a TPKT frame
+func readRequest(conn net.Conn, data []byte) ([]byte, error) {
+	if _, err := io.ReadFull(conn, data[:4]); err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(data[2:]))
+	if data[0] != 3 || length < isoHSize || length > len(data) {
+		return nil, fmt.Errorf("s7: invalid TPKT header % x", data[:4])
+	}
+	if _, err := io.ReadFull(conn, data[4:length]); err != nil {
+		return nil, err
+	}
+	return data[:length], nil
+}
+
+// handle returns the reply to a request, nil if there is none
+func (c *serverConn) handle(request []byte) []byte {
+	switch {
+	case request[5] == 0xE0: // COTP connection request
+		return connectionConfirm(request)
+	case request[5] != 0xF0 || len(request) < 17 || request[7] != 0x32:
+		c.server.logf("s7 server: ignoring telegram % x", request)
+		return nil
+	case request[8] == 1:
+		return c.handleJob(request)
+	case request[8] == 7:
+		return c.handleUserData(request)
+	}
+	c.server.logf("s7 server: ignoring ROSCTR %d", request[8])
+	return nil
+}
+
+// connectionConfirm answers a COTP connection request, for any TSAP
+func connectionConfirm(request []byte) []byte {
+	reply := append([]byte{}, request...)
+	if len(reply) < 10 {
+		return nil
+	}
+	reply[5] = 0xD0
+	reply[6], reply[7] = request[8], request[9] // destination reference is the source reference of the client
+	reply[8], reply[9] = 0, 1
+	return reply
+}
+
+// s7Reply builds a reply with the PDU reference of request, rosctr 3 (ack data) carries errCode in the header
+func s7Reply(request []byte, rosctr byte, errCode uint16, param []byte, data []byte) []byte {
+	size := 17
+	if rosctr == 2 || rosctr == 3 {
+		size = 19
+	}
+	reply := make([]byte, size, size+len(param)+len(data))
+	copy(reply, tpktISOTelegram)
+	reply[7] = 0x32
+	reply[8] = rosctr
+	copy(reply[11:13], request[11:13])
+	binary.BigEndian.PutUint16(reply[13:], uint16(len(param)))
+	binary.BigEndian.PutUint16(reply[15:], uint16(len(data)))
+	if rosctr == 2 || rosctr == 3 {
+		binary.BigEndian.PutUint16(reply[17:], errCode)
+	}
+	reply = append(append(reply, param...), data...)
+	binary.BigEndian.PutUint16(reply[2:], uint16(len(reply)))
+	return reply
+}
+
+func (c *serverConn) handleJob(request []byte) []byte {
+	if len(request) < 19 {
+		return s7Reply(request, 3, code7FunNotAvailable, nil, nil)
+	}
+	switch request[17] {
+	case 0xF0:
+		return c.setupCommunication(request)
+	case 0x04:
+		return c.readVar(request)
+	case 0x05:
+		return c.writeVar(request)
+	case pduStart, pduStop:
+		return c.control(request)
+	}
+	return s7Reply(request, 3, code7FunNotAvailable, nil, nil)
+}
+
+// setupCommunication negotiates the PDU length and answers the parallel jobs of the server
+func (c *serverConn) setupCommunication(request []byte) []byte {
+	if len(request) < 25 {
+		return s7Reply(request, 3, code7FunNotAvailable, nil, nil)
+	}
+	s := c.server
+	c.pdu = s.PDULength
+	if c.pdu <= 0 {
+		c.pdu = serverPDULength
+	}
+	if requested := int(binary.BigEndian.Uint16(request[23:])); requested >= minPduSize && requested < c.pdu {
+		c.pdu = requested
+	}
+	jobs := s.MaxParallelJobs
+	if jobs <= 0 {
+		jobs = serverMaxParallelJobs
+	}
+	param := []byte{0xF0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(param[2:], uint16(jobs))
+	binary.BigEndian.PutUint16(param[4:], uint16(jobs))
+	binary.BigEndian.PutUint16(param[6:], uint16(c.pdu))
+	return s7Reply(request, 3, 0, param, nil)
+}
+
+// serverItem is the variable specification of a read/write var item
+type serverItem struct {
+	wordLen  int
+	amount   int
+	dbNumber int
+	area     int
+	address  int // bit address, the element for timers and counters
+}
+
+// items parses the variable specifications of a read/write var request
+func items(request []byte) ([]serverItem, bool) {
+	count := int(request[18])
+	if count == 0 || count > maxMultiItems || len(request) < 19+count*sizeMultiItem {
+		return nil, false
+	}
+	list := make([]serverItem, count)
+	for i := range list {
+		spec := request[19+i*sizeMultiItem:]
+		list[i] = serverItem{
+			wordLen:  int(spec[3]),
+			amount:   int(binary.BigEndian.Uint16(spec[4:])),
+			dbNumber: int(binary.BigEndian.Uint16(spec[6:])),
+			area:     int(spec[8]),
+			address:  int(spec[9])<<16 | int(spec[10])<<8 | int(spec[11]),
+		}
+	}
+	return list, true
+}
+
+// locate returns the memory of an item, its first byte in the area and the return code. Caller must hold the mutex.
+func (s *Server) locate(item serverItem) (memory []byte, start int, code byte) {
+	start, size := item.address>>3, item.amount*dataSizeByte(item.wordLen)
+	switch item.wordLen {
+	case s7wlbit:
+		if item.amount != 1 {
+			return nil, start, code7InvalidTransportSize
+		}
+	case s7wltimer, s7wlcounter:
+		start = item.address * 2
+	default:
+		if size == 0 {
+			return nil, start, code7InvalidTransportSize
+		}
+	}
+	memory, err := s.area(item.area, item.dbNumber, start, size)
+	if err != nil {
+		if _, isArea := s.areas[serverArea{item.area, item.dbNumber}]; isArea {
+			return nil, start, code7AddressOutOfRange
+		}
+		return nil, start, code7ResItemNotAvailable
+	}
+	return memory, start, 0xFF
+}
+
+// hook runs OnRead or OnWrite for the memory of an item. Caller must hold the mutex.
+func (s *Server) hook(hook func(area int, dbNumber int, start int, data []byte), item serverItem, start int, memory []byte) {
+	if hook == nil {
+		return
+	}
+	dbNumber := item.dbNumber
+	if item.area != s7areadb {
+		dbNumber = 0
+	}
+	hook(item.area, dbNumber, start, memory)
+}
+
+// readVar answers the items of a read var request
+func (c *serverConn) readVar(request []byte) []byte {
+	list, ok := items(request)
+	if !ok {
+		return s7Reply(request, 3, code7FunNotAvailable, nil, nil)
+	}
+	s := c.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var data []byte
+	for i, item := range list {
+		memory, start, code := s.locate(item)
+		if code != 0xFF {
+			data = append(data, code, 0, 0, 0)
+			continue
+		}
+		s.hook(s.OnRead, item, start, memory)
+		header := []byte{code, tsResByte, 0, 0}
+		switch item.wordLen {
+		case s7wlbit:
+			header[1] = tsResBit
+			binary.BigEndian.PutUint16(header[2:], 1)
+			memory = []byte{memory[0] >> uint(item.address&0x07) & 0x01}
+		case s7wltimer, s7wlcounter:
+			header[1] = tsResOctet
+			binary.BigEndian.PutUint16(header[2:], uint16(len(memory)))
+		default:
+			binary.BigEndian.PutUint16(header[2:], uint16(len(memory)<<3))
+		}
+		data = append(append(data, header...), memory...)
+		if len(memory)%2 != 0 && i < len(list)-1 {
+			data = append(data, 0)
+		}
+	}
+	if sizeMultiReplyHeader+len(data) > c.pdu {
+		return s7Reply(request, 3, code7DataOverPDU, nil, nil)
+	}
+	return s7Reply(request, 3, 0, []byte{0x04, byte(len(list))}, data)
+}
+
+// writeVar writes the items of a write var request and answers a return code per item
+func (c *serverConn) writeVar(request []byte) []byte {
+	list, ok := items(request)
+	if !ok {
+		return s7Reply(request, 3, code7FunNotAvailable, nil, nil)
+	}
+	s := c.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	codes := make([]byte, len(list))
+	offset := 17 + int(binary.BigEndian.Uint16(request[13:]))
+	for i, item := range list {
+		if offset+sizeMultiItemData > len(request) {
+			codes[i] = code7WriteDataSizeMismatch
+			continue
+		}
+		size := int(binary.BigEndian.Uint16(request[offset+2:]))
+		if ts := request[offset+1]; ts != tsResBit && ts != tsResOctet && ts != tsResReal {
+			size = (size + 7) >> 3
+		}
+		offset += sizeMultiItemData
+		if offset+size > len(request) {
+			codes[i] = code7WriteDataSizeMismatch
+			continue
+		}
+		value := request[offset : offset+size]
+		offset += size
+		if size%2 != 0 && i < len(list)-1 {
+			offset++
+		}
+		memory, start, code := s.locate(item)
+		switch {
+		case code != 0xFF:
+		case len(value) != len(memory):
+			code = code7WriteDataSizeMismatch
+		case item.wordLen == s7wlbit:
+			mask := byte(1) << uint(item.address&0x07)
+			if value[0]&0x01 != 0 {
+				memory[0] |= mask
+			} else {
+				memory[0] &^= mask
+			}
+		default:
+			copy(memory, value)
+		}
+		if code == 0xFF {
+			s.hook(s.OnWrite, item, start, memory)
+		}
+		codes[i] = code
+	}
+	return s7Reply(request, 3, 0, []byte{0x05, byte(len(list))}, codes)
+}
+
+// control starts (hot or cold) or stops the PLC, deletes a block, compresses or copies RAM to ROM
+func (c *serverConn) control(request []byte) []byte {
+	if name, param, ok := piService(request); ok {
+		switch name {
+		case "_DELE":
+			return c.deleteBlock(request, param)
+		case "_GARB", "_MODU":
+			// the memory of the server needs neither compressing nor copying
+			return s7Reply(request, 3, 0, []byte{pduControl}, nil)
+		}
+	}
+	s := c.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	function := request[17]
+	switch {
+	case function == pduStart && s.status == s7CpuStatusRun:
+		return s7Reply(request, 3, 0, []byte{function, pduAlreadyStarted}, nil)
+	case function == pduStop && s.status == s7CpuStatusStop:
+		return s7Reply(request, 3, 0, []byte{function, pduAlreadyStopped}, nil)
+	case function == pduStart:
+		s.status = s7CpuStatusRun
+	default:
+		s.status = s7CpuStatusStop
+	}
+	return s7Reply(request, 3, 0, []byte{function}, nil)
+}
+
+// piService returns the name and the parameter block of a PI service request
+func piService(request []byte) (name string, param []byte, ok bool) {
+	if len(request) < 28 || request[17] != pduControl {
+		return
+	}
+	end := 27 + int(binary.BigEndian.Uint16(request[25:]))
+	if end >= len(request) || end+1+int(request[end]) > len(request) {
+		return
+	}
+	return string(request[end+1 : end+1+int(request[end])]), request[27:end], true
+}
+
+// deleteBlock deletes a registered block, the memory of a data block goes with it
+func (c *serverConn) deleteBlock(request []byte, param []byte) []byte {
+	// block count, unknown, '0', ASCII block type, ASCII block number, file system
+	if len(param) < 10 {
+		return s7Reply(request, 3, code7FunNotAvailable, nil, nil)
+	}
+	blockType := subBlockType(param[3])
+	number, err := strconv.Atoi(string(param[4:9]))
+	if err != nil {
+		return s7Reply(request, 3, code7FunNotAvailable, nil, nil)
+	}
+	s := c.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.blockInfo(blockType, number); !ok {
+		return s7Reply(request, 3, code7ResItemNotAvailable1, nil, nil)
+	}
+	delete(s.blocks, serverBlock{blockType, number})
+	if blockType == S7BlockDB {
+		delete(s.areas, serverArea{s7areadb, number})
+	}
+	return s7Reply(request, 3, 0, []byte{pduControl}, nil)
+}
+
+// userDataReply builds the reply of a userdata request, more tells that follow-up requests fetch the rest
+func (c *serverConn) userDataReply(request []byte, more bool, errCode uint16, data []byte) []byte {
+	param := []byte{0, 1, 0x12, 8, 0x12, 0x80 | request[22]&0x0F, request[23], c.seq, 0, 0, 0, 0}
+	if more {
+		param[9] = 1
+	}
+	binary.BigEndian.PutUint16(param[10:], errCode)
+	header := []byte{0x0A, 0, 0, 0} // no data
+	if len(data) > 0 {
+		header = []byte{0xFF, tsResOctet, 0, 0}
+		binary.BigEndian.PutUint16(header[2:], uint16(len(data)))
+	}
+	return s7Reply(request, 7, 0, param, append(header, data...))
+}
+
+// fragment answers the first part of data fitting into the PDU, the rest is pending for follow-up requests
+func (c *serverConn) fragment(request []byte, data []byte) []byte {
+	c.seq++
+	c.pending = nil
+	if max := c.pdu - (sizeUserDataReply - isoHSize); len(data) > max {
+		c.pending = data[max:]
+		data = data[:max]
+	}
+	return c.userDataReply(request, c.pending != nil, 0, data)
+}
+
+func (c *serverConn) handleUserData(request []byte) []byte {
+	paramLength := int(binary.BigEndian.Uint16(request[13:]))
+	if paramLength < 8 || len(request) < 17+paramLength+4 {
+		c.server.logf("s7 server: ignoring userdata % x", request)
+		return nil
+	}
+	if request[21] == 0x12 && paramLength >= 12 {
+		// follow-up request of a fragmented reply
+		if c.pending == nil || request[24] != c.seq {
+			return c.userDataReply(request, false, code7NoSuchJob, nil)
+		}
+		return c.fragment(request, c.pending)
+	}
+	data := request[17+paramLength+4:]
+	group, subfunction := request[22]&0x0F, request[23]
+	switch {
+	case group == 4 && subfunction == 1 && len(data) >= 4:
+		return c.readSZL(request, int(binary.BigEndian.Uint16(data)), int(binary.BigEndian.Uint16(data[2:])))
+	case group == 3 && subfunction == 2 && len(data) >= 2:
+		return c.blockList(request, data[1])
+	case group == 3 && subfunction == 3 && len(data) >= 7:
+		return c.blockInfo(request, data[1], string(data[2:7]))
+	case group == 7 && subfunction == 1:
+		return c.readClock(request)
+	case group == 7 && subfunction == 2 && len(data) >= 10:
+		var helper Helper
+		c.server.SetClock(helper.GetDateTimeAt(data, 2))
+		return c.userDataReply(request, false, 0, nil)
+	case group == 5 && (subfunction == 1 || subfunction == 2):
+		// session password, every password is accepted
+		return c.userDataReply(request, false, 0, nil)
+	}
+	return c.userDataReply(request, false, code7FunNotAvailable, nil)
+}
+
+// readSZL answers the system status lists the client reads
+func (c *serverConn) readSZL(request []byte, id int, index int) []byte {
+	s := c.server
+	var recordLength int
+	var records []byte
+	switch {
+	case id == 0x001C:
+		recordLength = szlCPUInfoRecord
+		texts := map[int]string{
+			1: s.CPUInfo.ASName,
+			2: s.CPUInfo.ModuleName,
+			4: s.CPUInfo.Copyright,
+			5: s.CPUInfo.SerialNumber,
+			7: s.CPUInfo.ModuleTypeName,
+		}
+		for _, i := range []int{1, 2, 3, 4, 5, 7, 8, 9, 10, 11} {
+			record := make([]byte, recordLength)
+			binary.BigEndian.PutUint16(record, uint16(i))
+			if i <= 8 {
+				copy(record[2:], fmt.Sprintf("%-32s", texts[i]))
+			}
+			records = append(records, record...)
+		}
+	case id == 0x0131 && (index == 0 || index == 1):
+		recordLength = szlCommRecord
+		records = make([]byte, recordLength)
+		pdu := s.CPInfo.MaxPduLength
+		if pdu == 0 {
+			if pdu = s.PDULength; pdu <= 0 {
+				pdu = serverPDULength
+			}
+		}
+		binary.BigEndian.PutUint16(records, 1)
+		binary.BigEndian.PutUint16(records[2:], uint16(pdu))
+		binary.BigEndian.PutUint16(records[4:], uint16(s.CPInfo.MaxConnections))
+		binary.BigEndian.PutUint32(records[6:], uint32(s.CPInfo.MaxMpiRate))
+		binary.BigEndian.PutUint32(records[10:], uint32(s.CPInfo.MaxBusRate))
+	case id == 0x0232 && index == 4:
+		recordLength = szlProtectionRecord
+		records = make([]byte, recordLength)
+		modeSelector := uint16(1) // RUN
+		if s.Status() == s7CpuStatusStop {
+			modeSelector = 3 // STOP
+		}
+		binary.BigEndian.PutUint16(records, 4)
+		binary.BigEndian.PutUint16(records[2:], 1) // protection level of the mode selector
+		binary.BigEndian.PutUint16(records[6:], 1) // valid protection level
+		binary.BigEndian.PutUint16(records[8:], modeSelector)
+	case id == 0x00A0:
+		recordLength = szlDiagRecord
+		for _, e := range s.DiagnosticBuffer {
+			records = append(records, encodeDiagEvent(e)...)
+		}
+	case id == 0x0424:
+		recordLength = szlStatusRecord
+		records = make([]byte, recordLength)
+		records[0], records[1], records[2] = 0x51, 0x44, 0xFF
+		records[3] = byte(s.Status())
+	default:
+		return c.userDataReply(request, false, code7InvalidSZL, nil)
+	}
+	data := make([]byte, 8, 8+len(records))
+	binary.BigEndian.PutUint16(data, uint16(id))
+	binary.BigEndian.PutUint16(data[2:], uint16(index))
+	binary.BigEndian.PutUint16(data[4:], uint16(recordLength))
+	binary.BigEndian.PutUint16(data[6:], uint16(len(records)/recordLength))
+	return c.fragment(request, append(data, records...))
+}
+
+// subBlockType returns the block type of the ASCII block type of a request, such as 'A' for S7BlockDB
+func subBlockType(ascii byte) int {
+	blockType, err := strconv.ParseUint(string(ascii), 16, 8)
+	if err != nil || blockType < S7BlockOB {
+		return -1
+	}
+	return int(blockType)
+}
+
+// blockList answers number, flags and language of the blocks of a type
+func (c *serverConn) blockList(request []byte, ascii byte) []byte {
+	s := c.server
+	s.mu.Lock()
+	blockType := subBlockType(ascii)
+	var data []byte
+	for _, number := range s.blockNumbers(blockType) {
+		info, _ := s.blockInfo(blockType, number)
+		data = append(data, byte(number>>8), byte(number), byte(info.BlkFlags), byte(info.BlkLang))
+	}
+	s.mu.Unlock()
+	return c.fragment(request, data)
+}
+
+// blockInfo answers the info of a block
+func (c *serverConn) blockInfo(request []byte, ascii byte, number string) []byte {
+	s := c.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	blockNumber, err := strconv.Atoi(number)
+	if err != nil {
+		return c.userDataReply(request, false, code7ResItemNotAvailable1, nil)
+	}
+	info, ok := s.blockInfo(subBlockType(ascii), blockNumber)
+	if !ok {
+		return c.userDataReply(request, false, code7ResItemNotAvailable1, nil)
+	}
+	// the offsets are those of the reply minus sizeUserDataReply
+	data := make([]byte, 78)
+	data[0] = 0x01
+	data[9] = byte(info.BlkFlags)
+	data[10] = byte(info.BlkLang)
+	data[11] = byte(info.BlkType)
+	binary.BigEndian.PutUint16(data[12:], uint16(info.BlkNumber))
+	binary.BigEndian.PutUint32(data[14:], uint32(info.LoadSize))
+	binary.BigEndian.PutUint16(data[26:], siemensDays(info.CodeDate))
+	binary.BigEndian.PutUint16(data[32:], siemensDays(info.IntfDate))
+	binary.BigEndian.PutUint16(data[34:], uint16(info.SBBLength))
+	binary.BigEndian.PutUint16(data[38:], uint16(info.LocalData))
+	binary.BigEndian.PutUint16(data[40:], uint16(info.MC7Size))
+	copy(data[42:50], fmt.Sprintf("%-8.8s", info.Author))
+	copy(data[50:58], fmt.Sprintf("%-8.8s", info.Family))
+	copy(data[58:66], fmt.Sprintf("%-8.8s", info.Header))
+	data[66] = byte(info.Version)
+	binary.BigEndian.PutUint16(data[68:], uint16(info.CheckSum))
+	return c.userDataReply(request, false, 0, data)
+}
+
+// readClock answers reserved byte, century and the DATE_AND_TIME of the PLC clock
+func (c *serverConn) readClock(request []byte) []byte {
+	var helper Helper
+	clock := c.server.Clock()
+	data := make([]byte, 10)
+	data[1] = encodeBcd(clock.Year() / 100)
+	helper.SetDateTimeAt(data, 2, clock)
+	return c.userDataReply(request, false, 0, data)
+}
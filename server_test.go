@@ -0,0 +1,246 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// startServer starts server on a free local port and connects a client handler to it
+func startServer(t *testing.T, server *Server) *TCPClientHandler {
+	if err := server.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	handler := NewTCPClientHandler(server.Addr().String(), 0, 2)
+	handler.Timeout = 5 * time.Second
+	if err := handler.Connect(); err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	return handler
+}
+
+func TestServerAreas(t *testing.T) {
+	server := NewServer()
+	db := make([]byte, 16)
+	timers := make([]byte, 8)
+	server.RegisterArea(S7AreaDB, 3, db)
+	server.RegisterArea(S7AreaMK, 0, make([]byte, 8))
+	server.RegisterArea(S7AreaTM, 0, timers)
+	handler := startServer(t, server)
+	defer server.Close()
+	defer handler.Close()
+	client := NewClient(handler)
+
+	if err := client.AGWriteDB(3, 2, 4, []byte{1, 2, 3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(db[2:6], []byte{1, 2, 3, 4}) {
+		t.Fatalf("unexpected DB3: % x", db)
+	}
+	if err := client.WriteBool(S7AreaMK, 0, 1, 3, true); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := client.ReadWord(S7AreaMK, 0, 0); err != nil || value != 0x0008 {
+		t.Fatalf("unexpected MW0 %04X: %v", value, err)
+	}
+	server.WriteArea(S7AreaTM, 0, 2, []byte{0x12, 0x34}) // T1
+	buffer := make([]byte, 4)
+	if err := client.AGReadTM(1, 2, buffer); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buffer, []byte{0x12, 0, 0, 0}) { // AGReadTM returns the low byte of the timers
+		t.Fatalf("unexpected timers: % x", buffer)
+	}
+	if err := client.AGReadDB(4, 0, 2, buffer); err == nil {
+		t.Fatal("expected an error reading a missing DB")
+	}
+	if err := client.AGReadDB(3, 15, 2, buffer); err == nil {
+		t.Fatal("expected an error reading beyond DB3")
+	}
+
+	items := []S7DataItem{
+		{Area: S7AreaDB, WordLen: S7WLByte, DBNumber: 3, Start: 3, Amount: 3, Data: make([]byte, 3)},
+		{Area: S7AreaDB, WordLen: S7WLByte, DBNumber: 9, Start: 0, Amount: 2, Data: make([]byte, 2)},
+		{Area: S7AreaMK, WordLen: S7WLBit, Start: 1, Bit: 3, Amount: 1, Data: make([]byte, 1)},
+	}
+	if err := client.AGReadMulti(items, len(items)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(items[0].Data, []byte{2, 3, 4}) || items[1].Error == "" || items[2].Data[0] != 1 {
+		t.Fatalf("unexpected items: %+v", items)
+	}
+}
+
+func TestServerBlocks(t *testing.T) {
+	server := NewServer()
+	server.RegisterArea(S7AreaDB, 10, make([]byte, 100))
+	server.RegisterBlock(S7BlockInfo{BlkType: S7BlockDB, BlkNumber: 5, BlkLang: 5, MC7Size: 20, CodeDate: "22.01.2018", Author: "gos7"})
+	server.RegisterBlock(S7BlockInfo{BlkType: S7BlockOB, BlkNumber: 1, BlkLang: 1})
+	handler := startServer(t, server)
+	defer server.Close()
+	defer handler.Close()
+	client := NewClient(handler)
+
+	list, err := client.PGListBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalInts(list.DBList, []int{5, 10}) || !equalInts(list.OBList, []int{1}) || len(list.FBList) != 0 {
+		t.Fatalf("unexpected blocks: %+v", list)
+	}
+	info, err := client.GetAgBlockInfo(blockDB, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.BlkNumber != 5 || info.MC7Size != 20 || info.CodeDate != "22.01.2018" || info.Author != "gos7    " {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+	if info, err = client.GetAgBlockInfo(blockDB, 10); err != nil || info.MC7Size != 100 || info.BlkType != S7BlockDB {
+		t.Fatalf("unexpected info %+v: %v", info, err)
+	}
+	if _, err = client.GetAgBlockInfo(blockFB, 1); err == nil {
+		t.Fatal("expected an error for a missing block")
+	}
+}
+
+func TestServerDeleteBlock(t *testing.T) {
+	server := NewServer()
+	server.RegisterArea(S7AreaDB, 10, make([]byte, 100))
+	server.RegisterBlock(S7BlockInfo{BlkType: S7BlockFC, BlkNumber: 3, BlkLang: 1})
+	server.SetStatus(s7CpuStatusStop)
+	handler := startServer(t, server)
+	defer server.Close()
+	defer handler.Close()
+	client := NewClient(handler)
+
+	if err := client.DeleteBlock(S7BlockDB, 10); err == nil {
+		t.Fatal("expected a refused deletion without confirmation")
+	}
+	if err := server.ReadArea(S7AreaDB, 10, 0, make([]byte, 1)); err != nil {
+		t.Fatalf("DB10 deleted without confirmation: %v", err)
+	}
+	if err := client.DeleteBlock(S7BlockDB, 10, ConfirmDelete); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.DeleteBlock(blockFC, 3, ConfirmDelete); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := client.PGListBlocks(); err != nil || len(list.DBList) != 0 || len(list.FCList) != 0 {
+		t.Fatalf("unexpected blocks %+v: %v", list, err)
+	}
+	if err := client.AGReadDB(10, 0, 1, make([]byte, 1)); err == nil {
+		t.Fatal("expected DB10 to be gone")
+	}
+	if err := client.DeleteBlock(S7BlockDB, 10, ConfirmDelete); err == nil || err.Error() != ErrorText(errCliItemNotAvailable) {
+		t.Fatalf("unexpected error deleting a missing block: %v", err)
+	}
+	// the PI service of the deletion leaves the CPU alone
+	if status, err := client.PLCGetStatus(); err != nil || status != s7CpuStatusStop {
+		t.Fatalf("unexpected status %d: %v", status, err)
+	}
+}
+
+func TestServerControl(t *testing.T) {
+	server := NewServer()
+	handler := startServer(t, server)
+	defer server.Close()
+	defer handler.Close()
+	client := NewClient(handler)
+
+	if err := client.PLCHotStart(); err == nil {
+		t.Fatal("expected an error starting a running PLC")
+	}
+	if err := client.PLCStop(); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := client.PLCGetStatus(); err != nil || status != s7CpuStatusStop {
+		t.Fatalf("unexpected status %d: %v", status, err)
+	}
+	if err := client.PLCStop(); err == nil {
+		t.Fatal("expected an error stopping a stopped PLC")
+	}
+	if err := client.PLCColdStart(); err != nil {
+		t.Fatal(err)
+	}
+	if server.Status() != s7CpuStatusRun {
+		t.Fatalf("unexpected status %d", server.Status())
+	}
+
+	clock := time.Date(2019, 6, 23, 12, 30, 15, 0, time.UTC)
+	if err := client.PGClockRead(clock); err != nil {
+		t.Fatal(err)
+	}
+	read, err := client.PGClockWrite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := read.Sub(clock); d < 0 || d > 2*time.Second {
+		t.Fatalf("unexpected clock %v", read)
+	}
+}
+
+func TestServerSZL(t *testing.T) {
+	server := NewServer()
+	server.PDULength = 240
+	server.CPUInfo.SerialNumber = "S C-123"
+	handler := startServer(t, server)
+	defer server.Close()
+	defer handler.Close()
+	client := NewClient(handler)
+
+	info, err := client.GetCPInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.MaxPduLength != 240 || info.MaxConnections != 16 {
+		t.Fatalf("unexpected CP info: %+v", info)
+	}
+
+	// SZL 0x001C is larger than the PDU, the rest is fetched with a follow-up request
+	request := append([]byte{}, s7SZLFirstTelegram...)
+	binary.BigEndian.PutUint16(request[29:], 0x001C)
+	response, err := handler.Send(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response) != 7+240 || response[26] == 0 {
+		t.Fatalf("expected a first fragment of the PDU size: % x", response)
+	}
+	data := append([]byte{}, response[33:]...)
+	next := append([]byte{}, s7SZLNextTelegram...)
+	next[24] = response[24]
+	if response, err = handler.Send(next); err != nil {
+		t.Fatal(err)
+	}
+	if response[26] != 0 {
+		t.Fatalf("expected the last fragment: % x", response)
+	}
+	data = append(data, response[33:]...)
+	if len(data) != 8+10*szlCPUInfoRecord || !bytes.HasPrefix(data[8+4*szlCPUInfoRecord+2:], []byte("S C-123 ")) {
+		t.Fatalf("unexpected SZL 0x001C: % x", data)
+	}
+}
+
+func TestClientReadSZLFragments(t *testing.T) {
+	server := NewServer()
+	server.PDULength = 240
+	server.CPUInfo.SerialNumber = "S C-123"
+	handler := startServer(t, server)
+	defer server.Close()
+	defer handler.Close()
+	client := NewClient(handler)
+
+	// SZL 0x001C needs two fragments with a PDU of 240 bytes, the serial number is in the second
+	cpu, err := client.GetCPUInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cpu.SerialNumber != "S C-123" || cpu.ModuleTypeName != "CPU 315-2 PN/DP" {
+		t.Fatalf("unexpected CPU info: %+v", cpu)
+	}
+}
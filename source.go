@@ -0,0 +1,534 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The declarations of STEP 7 sources (AWL/STL and SCL, also the TIA Portal variant) are read from:
+//
+//	TYPE UDT 1 STRUCT ... END_STRUCT; END_TYPE
+//	DATA_BLOCK DB 10 STRUCT ... END_STRUCT; BEGIN ... END_DATA_BLOCK
+//	DATA_BLOCK "Motors" VAR ... END_VAR BEGIN ... END_DATA_BLOCK
+//	DATA_BLOCK DB 11 FB 1 BEGIN ... END_DATA_BLOCK (instance DB) or DATA_BLOCK DB 12 UDT 1 BEGIN ...
+//	FUNCTION_BLOCK FB 1 VAR_INPUT ... END_VAR VAR_OUTPUT ... VAR_IN_OUT ... VAR ... END_VAR ... END_FUNCTION_BLOCK
+//
+// A declaration is "name : type [:= start value];" with an optional comment "// ..." on the same line.
+// The types are the elementary types, STRING[n], ARRAY[low..high, ...] OF type, STRUCT ... END_STRUCT and
+// references to UDTs or FBs by number (UDT 1) or symbol ("Motor"). Attributes { ... } and comments (* ... *)
+// are skipped, so are the statements of code blocks.
+
+// sourceDecl is a declaration of a source: a variable, a STRUCT member or the element of an ARRAY
+type sourceDecl struct {
+	name    string
+	kind    int    // MC7In, MC7Out, MC7InOut, MC7Stat or MC7Temp
+	typ     string // elementary type, STRING, ARRAY, STRUCT, or UDT/FB for a reference
+	length  int    // maximum length of a STRING
+	ref     string // referenced UDT or FB, such as "UDT 1" or "\"Motor\""
+	bounds  [][2]int
+	elem    *sourceDecl
+	members []sourceDecl
+	comment string
+	line    int
+}
+
+// sourceBlock is a DB, UDT or FB of a source
+type sourceBlock struct {
+	kind string // DATA_BLOCK, TYPE or FUNCTION_BLOCK
+	name string // "DB 10", "UDT 1", "FB 1" or a symbol in quotes
+	// decl is the STRUCT of the declarations, or a reference to the UDT or FB of a DB
+	decl sourceDecl
+}
+
+// sourceError reports a problem of a STEP 7 source at a line (starting at 1)
+type sourceError struct {
+	line   int
+	reason string
+}
+
+func (e *sourceError) Error() string {
+	if e.line == 0 {
+		// declarations that do not come from a text source, e.g. TIA Portal XML
+		return "s7: " + e.reason
+	}
+	return fmt.Sprintf("s7: source line %d: %s", e.line, e.reason)
+}
+
+// sourceToken is a word, number, symbol in quotes, string in apostrophes or punctuation of a source
+type sourceToken struct {
+	text string
+	line int
+	// comment is the "//" comment following the token on the same line
+	comment string
+}
+
+// tokenizeSource splits a source into tokens, comments and attributes are dropped
+func tokenizeSource(src string) ([]sourceToken, error) {
+	var tokens []sourceToken
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			end := strings.IndexByte(src[i:], '\n')
+			if end < 0 {
+				end = len(src) - i
+			}
+			if n := len(tokens); n > 0 && tokens[n-1].line == line {
+				tokens[n-1].comment = strings.TrimSpace(src[i+2 : i+end])
+			}
+			i += end
+		case strings.HasPrefix(src[i:], "(*") || c == '{':
+			closing := "*)"
+			if c == '{' {
+				closing = "}"
+			}
+			end := strings.Index(src[i:], closing)
+			if end < 0 {
+				return nil, &sourceError{line, "unterminated comment or attribute"}
+			}
+			line += strings.Count(src[i:i+end], "\n")
+			i += end + len(closing)
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(src[i+1:], c)
+			if end < 0 {
+				return nil, &sourceError{line, "unterminated " + string(c)}
+			}
+			tokens = append(tokens, sourceToken{text: src[i : i+end+2], line: line})
+			i += end + 2
+		case strings.HasPrefix(src[i:], ":=") || strings.HasPrefix(src[i:], ".."):
+			tokens = append(tokens, sourceToken{text: src[i : i+2], line: line})
+			i += 2
+		case isSourceWord(rune(c)):
+			begin := i
+			for i < len(src) && (isSourceWord(rune(src[i])) || src[i] == '#' ||
+				// a period belongs to numbers such as 1.5 but not to ranges such as 1..4
+				src[i] == '.' && i+1 < len(src) && src[i+1] != '.' && unicode.IsDigit(rune(src[begin]))) {
+				i++
+			}
+			tokens = append(tokens, sourceToken{text: src[begin:i], line: line})
+		default:
+			tokens = append(tokens, sourceToken{text: string(c), line: line})
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+func isSourceWord(c rune) bool {
+	return c == '_' || c == '#' || c == '-' || c == '+' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// sourceParser reads the blocks of a source from its tokens
+type sourceParser struct {
+	tokens []sourceToken
+	pos    int
+}
+
+// parseSource reads the DBs, UDTs and FBs of a source, other blocks are skipped
+func parseSource(src string) ([]sourceBlock, error) {
+	tokens, err := tokenizeSource(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &sourceParser{tokens: tokens}
+	var blocks []sourceBlock
+	for !p.done() {
+		keyword := strings.ToUpper(p.next().text)
+		switch keyword {
+		case "TYPE", "DATA_BLOCK", "FUNCTION_BLOCK":
+			block, err := p.block(keyword)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, block)
+		case "FUNCTION", "ORGANIZATION_BLOCK":
+			if err := p.skipTo("END_" + keyword); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return blocks, nil
+}
+
+func (p *sourceParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *sourceParser) fail(format string, v ...interface{}) error {
+	line := 0
+	if n := len(p.tokens); n > 0 {
+		line = p.tokens[n-1].line
+		if p.pos < n {
+			line = p.tokens[p.pos].line
+		}
+	}
+	return &sourceError{line, fmt.Sprintf(format, v...)}
+}
+
+// peek returns the next token in upper case, "" at the end
+func (p *sourceParser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return strings.ToUpper(p.tokens[p.pos].text)
+}
+
+func (p *sourceParser) next() sourceToken {
+	if p.done() {
+		return sourceToken{}
+	}
+	p.pos++
+	return p.tokens[p.pos-1]
+}
+
+func (p *sourceParser) accept(text string) bool {
+	if p.peek() == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *sourceParser) expect(text string) error {
+	if !p.accept(text) {
+		return p.fail("expected %s instead of %q", text, p.peek())
+	}
+	return nil
+}
+
+// skipTo skips all tokens up to and including the keyword
+func (p *sourceParser) skipTo(keyword string) error {
+	for !p.done() {
+		if strings.ToUpper(p.next().text) == keyword {
+			return nil
+		}
+	}
+	return p.fail("missing %s", keyword)
+}
+
+// blockName reads "DB 10", "DB10", "UDT 1" or a symbol
+func (p *sourceParser) blockName() (string, error) {
+	token := p.next()
+	text := strings.ToUpper(token.text)
+	if strings.HasPrefix(text, "\"") {
+		return token.text, nil
+	}
+	for _, prefix := range []string{"DB", "UDT", "FB", "SFB"} {
+		if text == prefix && !p.done() {
+			text += p.next().text
+		}
+		if strings.HasPrefix(text, prefix) {
+			if n, err := strconv.Atoi(text[len(prefix):]); err == nil {
+				return prefix + " " + strconv.Itoa(n), nil
+			}
+		}
+	}
+	return "", &sourceError{token.line, fmt.Sprintf("invalid block name %q", token.text)}
+}
+
+// block reads a DB, UDT or FB after its keyword
+func (p *sourceParser) block(keyword string) (block sourceBlock, err error) {
+	block.kind = keyword
+	if block.name, err = p.blockName(); err != nil {
+		return
+	}
+	block.decl = sourceDecl{typ: "STRUCT", kind: MC7Stat, line: p.tokens[p.pos-1].line}
+	for !p.done() {
+		switch word := p.peek(); word {
+		case "TITLE":
+			// the title takes the rest of the line
+			line := p.next().line
+			for !p.done() && p.tokens[p.pos].line == line {
+				p.pos++
+			}
+		case "VERSION", "AUTHOR", "FAMILY", "NAME":
+			p.pos++
+			p.accept(":")
+			p.next()
+		case "KNOW_HOW_PROTECT", "NON_RETAIN", "RETAIN", "UNLINKED", "READ_ONLY", "CODE_VERSION1":
+			p.pos++
+		case "STRUCT":
+			var decl sourceDecl
+			if decl, err = p.declType(); err != nil {
+				return
+			}
+			block.decl.members = decl.members
+			p.accept(";")
+		case "VAR", "VAR_INPUT", "VAR_OUTPUT", "VAR_IN_OUT", "VAR_TEMP", "VAR_STAT":
+			p.pos++
+			kind := map[string]int{"VAR_INPUT": MC7In, "VAR_OUTPUT": MC7Out, "VAR_IN_OUT": MC7InOut, "VAR_TEMP": MC7Temp}[word]
+			if kind == 0 {
+				kind = MC7Stat
+			}
+			p.accept("RETAIN")
+			p.accept("NON_RETAIN")
+			var members []sourceDecl
+			if members, err = p.declarations("END_VAR", kind); err != nil {
+				return
+			}
+			block.decl.members = append(block.decl.members, members...)
+		case "UDT", "FB", "SFB":
+			// a DB of a UDT or the instance DB of an FB
+			block.decl.typ = word
+			if block.decl.ref, err = p.blockName(); err != nil {
+				return
+			}
+		case "BEGIN", "END_TYPE", "END_DATA_BLOCK", "END_FUNCTION_BLOCK":
+			err = p.skipTo("END_" + keyword)
+			return
+		default:
+			if keyword == "DATA_BLOCK" && strings.HasPrefix(word, "\"") {
+				// a DB of a UDT or FB by symbol
+				block.decl.typ = "UDT"
+				block.decl.ref = p.next().text
+				continue
+			}
+			return block, p.fail("unexpected %q in %s %s", p.tokens[p.pos].text, keyword, block.name)
+		}
+	}
+	return block, p.fail("missing END_%s", keyword)
+}
+
+// declarations reads "name : type;" up to the end keyword
+func (p *sourceParser) declarations(end string, kind int) (decls []sourceDecl, err error) {
+	for !p.accept(end) {
+		if p.done() {
+			return nil, p.fail("missing %s", end)
+		}
+		name := p.next()
+		if name.text == ":" || name.text == ";" {
+			return nil, &sourceError{name.line, fmt.Sprintf("expected a name instead of %q", name.text)}
+		}
+		if err = p.expect(":"); err != nil {
+			return
+		}
+		var decl sourceDecl
+		if decl, err = p.declType(); err != nil {
+			return
+		}
+		decl.name = strings.Trim(name.text, "\"")
+		decl.kind = kind
+		decl.line = name.line
+		// the start value runs to the semicolon
+		if p.accept(":=") {
+			for !p.done() && p.peek() != ";" {
+				p.pos++
+			}
+		}
+		if err = p.expect(";"); err != nil {
+			return
+		}
+		decl.comment = p.tokens[p.pos-1].comment
+		decls = append(decls, decl)
+	}
+	p.accept(";")
+	return
+}
+
+// declType reads a type
+func (p *sourceParser) declType() (decl sourceDecl, err error) {
+	token := p.next()
+	decl.line = token.line
+	decl.typ = strings.ToUpper(token.text)
+	switch {
+	case decl.typ == "STRUCT":
+		decl.members, err = p.declarations("END_STRUCT", MC7Stat)
+	case decl.typ == "ARRAY":
+		if decl.bounds, err = p.bounds(); err != nil {
+			return
+		}
+		if err = p.expect("OF"); err != nil {
+			return
+		}
+		var elem sourceDecl
+		if elem, err = p.declType(); err != nil {
+			return
+		}
+		decl.elem = &elem
+	case decl.typ == "STRING" || decl.typ == "WSTRING":
+		decl.length = 254
+		if p.accept("[") {
+			if decl.length, err = p.integer(); err != nil {
+				return
+			}
+			if err = p.expect("]"); err != nil {
+				return
+			}
+		}
+	case decl.typ == "UDT" || decl.typ == "FB" || decl.typ == "SFB":
+		p.pos--
+		decl.ref, err = p.blockName()
+	case strings.HasPrefix(decl.typ, "UDT") || strings.HasPrefix(decl.typ, "\""):
+		p.pos--
+		decl.typ = "UDT"
+		decl.ref, err = p.blockName()
+	case decl.typ == "" || !unicode.IsLetter(rune(decl.typ[0])):
+		err = &sourceError{token.line, fmt.Sprintf("expected a type instead of %q", token.text)}
+	}
+	return
+}
+
+// bounds reads the bounds "[low..high, ...]" of an ARRAY
+func (p *sourceParser) bounds() (bounds [][2]int, err error) {
+	if err = p.expect("["); err != nil {
+		return
+	}
+	for {
+		var low, high int
+		if low, err = p.integer(); err != nil {
+			return
+		}
+		if err = p.expect(".."); err != nil {
+			return
+		}
+		if high, err = p.integer(); err != nil {
+			return
+		}
+		if high < low {
+			return nil, p.fail("ARRAY bounds %d..%d", low, high)
+		}
+		bounds = append(bounds, [2]int{low, high})
+		if !p.accept(",") {
+			break
+		}
+	}
+	err = p.expect("]")
+	return
+}
+
+func (p *sourceParser) integer() (int, error) {
+	token := p.next()
+	n, err := strconv.Atoi(token.text)
+	if err != nil {
+		return 0, &sourceError{token.line, fmt.Sprintf("expected a number instead of %q", token.text)}
+	}
+	return n, nil
+}
+
+// resolve replaces the references to UDTs and FBs by their declarations, an FB contributes the
+// declarations of its instance DB (no temporary variables)
+func resolveSource(decl sourceDecl, blocks []sourceBlock, depth int) (sourceDecl, error) {
+	if depth > 16 {
+		return decl, &sourceError{decl.line, "UDTs nested too deep"}
+	}
+	switch decl.typ {
+	case "UDT", "FB", "SFB":
+		for _, block := range blocks {
+			if block.name != decl.ref || block.kind == "DATA_BLOCK" {
+				continue
+			}
+			resolved, err := resolveSource(block.decl, blocks, depth+1)
+			if err != nil {
+				return decl, err
+			}
+			resolved.name, resolved.kind, resolved.comment, resolved.line = decl.name, decl.kind, decl.comment, decl.line
+			if block.kind == "FUNCTION_BLOCK" {
+				members := resolved.members[:0:0]
+				for _, member := range resolved.members {
+					if member.kind != MC7Temp {
+						members = append(members, member)
+					}
+				}
+				resolved.members = members
+			}
+			return resolved, nil
+		}
+		return decl, &sourceError{decl.line, fmt.Sprintf("%s is not declared in the source", decl.ref)}
+	case "ARRAY":
+		elem, err := resolveSource(*decl.elem, blocks, depth+1)
+		if err != nil {
+			return decl, err
+		}
+		decl.elem = &elem
+	case "STRUCT":
+		members := make([]sourceDecl, len(decl.members))
+		for i, member := range decl.members {
+			var err error
+			if members[i], err = resolveSource(member, blocks, depth+1); err != nil {
+				return decl, err
+			}
+		}
+		decl.members = members
+	}
+	return decl, nil
+}
+
+// parameter converts a resolved declaration into the parameter of a block interface
+func (d sourceDecl) parameter() MC7Parameter {
+	p := MC7Parameter{Kind: d.kind, Type: d.typ, Length: d.length, Bounds: d.bounds}
+	if typ, ok := s7Types[d.typ]; ok {
+		// TOD and DT are written as TIME_OF_DAY and DATE_AND_TIME
+		p.Type = typ.name
+	}
+	if d.elem != nil {
+		element := d.elem.parameter()
+		p.Element = &element
+	}
+	for _, member := range d.members {
+		p.Members = append(p.Members, member.parameter())
+	}
+	return p
+}
+
+// ParseSource lays out the DBs and UDTs of a STEP 7 source (.db, .udt, .awl or .scl) by the S7 classic rules,
+// the variables are named after their declarations. UDTs and the FBs of instance DBs are resolved from the
+// same source. DBs named by a symbol and UDTs are laid out in DB 0, see DBLayout.SetDBNumber.
+func ParseSource(src string) ([]*DBLayout, error) {
+	blocks, err := parseSource(src)
+	if err != nil {
+		return nil, err
+	}
+	var layouts []*DBLayout
+	for _, block := range blocks {
+		if block.kind == "FUNCTION_BLOCK" {
+			continue
+		}
+		decl, err := resolveSource(block.decl, blocks, 0)
+		if err != nil {
+			return nil, err
+		}
+		if decl.typ != "STRUCT" {
+			return nil, &sourceError{decl.line, block.name + " of type " + decl.typ}
+		}
+		dbNumber := 0
+		if strings.HasPrefix(block.name, "DB ") {
+			dbNumber, _ = strconv.Atoi(block.name[3:])
+		}
+		l, err := layoutDecls(strings.Trim(block.name, "\""), dbNumber, decl.members)
+		if err != nil {
+			return nil, err
+		}
+		layouts = append(layouts, l)
+	}
+	return layouts, nil
+}
+
+// layoutDecls lays out resolved declarations in a DB and names the variables after them
+func layoutDecls(name string, dbNumber int, decls []sourceDecl) (*DBLayout, error) {
+	l := &DBLayout{Name: name, DBNumber: dbNumber}
+	pos := 0
+	for _, decl := range decls {
+		v, end, err := layoutVariable(decl.parameter(), dbNumber, pos)
+		if err != nil {
+			return nil, &sourceError{decl.line, decl.name + ": " + strings.TrimPrefix(err.Error(), "s7: ")}
+		}
+		l.Variables = append(l.Variables, v)
+		pos = end
+	}
+	l.Size = alignBits(pos, 16) / 8
+	if err := mergeVariables(l.Variables, decls, ""); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,102 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSource(t *testing.T) {
+	layouts, err := ParseSource(testDBSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(layouts) != 2 {
+		t.Fatalf("%d layouts instead of 2", len(layouts))
+	}
+	udt, db := layouts[0], layouts[1]
+	if udt.Name != "MotorType" || udt.DBNumber != 0 || udt.Size != 24 || len(udt.Variables) != 2 {
+		t.Errorf("unexpected UDT %+v", udt)
+	}
+	if db.Name != "DB 3" || db.DBNumber != 3 || db.Size != 48 {
+		t.Errorf("unexpected DB %+v", db)
+	}
+	expected := map[string]string{
+		"Speed":         "DB3.DBD0",
+		"Fault":         "DB3.DBX4.1",
+		"Motor.Running": "DB3.DBX6.0",
+		"Motor.Name":    "DB3.DBSTRING8.20",
+		"Values[3]":     "DB3.DBW34",
+		"Mode":          "DB3.DBB36",
+		"Bits[9]":       "DB3.DBX39.1",
+		"Stamp":         "DB3.DBB40[8]",
+	}
+	for _, v := range db.Elementary() {
+		if a, ok := expected[v.Name]; ok {
+			if v.Address.String() != a {
+				t.Errorf("%s: %s instead of %s", v.Name, v.Address, a)
+			}
+			delete(expected, v.Name)
+		}
+	}
+	if len(expected) > 0 {
+		t.Errorf("missing variables %v", expected)
+	}
+}
+
+func TestParseSourceSCL(t *testing.T) {
+	src := `DATA_BLOCK "Motors"
+{ S7_Optimized_Access := 'FALSE' }
+VERSION : 0.1
+NON_RETAIN
+   VAR
+      Speed : Real;   // rpm
+      Flags : Array[0..2] of Bool;
+      Count : Int := 3;
+   END_VAR
+
+BEGIN
+   Count := 5;
+END_DATA_BLOCK
+`
+	layouts, err := ParseSource(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(layouts) != 1 || layouts[0].Name != "Motors" || layouts[0].Size != 8 {
+		t.Fatalf("unexpected layouts %+v", layouts)
+	}
+	l := layouts[0]
+	l.SetDBNumber(7)
+	if v := l.Variables[0]; v.Comment != "rpm" || v.Type != "REAL" || v.Address.String() != "DB7.DBD0" {
+		t.Errorf("unexpected variable %+v", v)
+	}
+	if a := l.Variables[1].Address.String(); a != "DB7.DBX4.0[3]" {
+		t.Errorf("unexpected array address %s", a)
+	}
+	if v := l.Variables[2]; v.Name != "Count" || v.Address.String() != "DB7.DBW6" {
+		t.Errorf("unexpected variable %+v", v)
+	}
+	elementary := l.Elementary()
+	if v := elementary[3]; v.Name != "Flags[2]" || v.Address.String() != "DB7.DBX4.2" {
+		t.Errorf("unexpected element %+v", v)
+	}
+}
+
+func TestParseSourceErrors(t *testing.T) {
+	errors := map[string]string{
+		"DATA_BLOCK DB 1\nSTRUCT\n a : WCHAR;\nEND_STRUCT;\nBEGIN\nEND_DATA_BLOCK":         "line 3: a: unknown type WCHAR",
+		"DATA_BLOCK DB 1\nSTRUCT\n a : INT\n b : INT;\nEND_STRUCT;\nBEGIN\nEND_DATA_BLOCK": "line 4: expected ;",
+		"DATA_BLOCK DB 1\nSTRUCT\n a : ARRAY[2..1] OF INT;\nEND_STRUCT;":                   "line 3: ARRAY bounds 2..1",
+		"DATA_BLOCK DB 1\nSTRUCT\n a : INT;\nEND_STRUCT;\nBEGIN\n":                         "missing END_DATA_BLOCK",
+		"DATA_BLOCK DB 1 UDT 2\nBEGIN\nEND_DATA_BLOCK":                                     "UDT 2 is not declared",
+		"DATA_BLOCK DB 1\nSTRUCT\n (* a : INT;\nEND_STRUCT;":                               "unterminated comment",
+	}
+	for src, expected := range errors {
+		if _, err := ParseSource(src); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("error %v, expected %q", err, expected)
+		}
+	}
+}
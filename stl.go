@@ -0,0 +1,405 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MC7 is not documented by Siemens, the opcodes below are reverse engineered. An instruction takes 2 bytes,
+// opcode and operand byte, unless it is one of the longer forms:
+//
+//	0x30 type, 16 bits constant          L W#16#1234, L 100, L S5T#2S, L C#10, L 2#...
+//	0x38 type, 32 bits constant          L 1.5, L DW#16#..., L L#100000, L P#2.0, L T#1S, L TOD#...
+//	0x3E function, 16 bits block number  UC FC 300, CC FB 2, UC SFC 20, OPN DB 500, OPN DI 3
+//	0x70 function, 16 bits distance      JU, JC, LOOP, JL, ... the distance is counted in words from the jump
+//	0x7E function|area, 16 bits byte     L MW 300, T DBD 4, L PIW 256, ...
+//	0x7F function|area, 16 bits byte*8+bit  A I 300.1, = DBX 2.0, S L 1.0, ...
+//
+// The short forms address bytes 0..255: bit logic on M bits (0x80..0xB7) and on I (operand < 0x80) or Q
+// (operand >= 0x80) bits (0xC0..0xF7) carry the bit number in the low 3 bits of the opcode.
+// Opcodes that are not known are listed as raw words, so a listing always covers the whole code.
+
+// Mnemonics of DisassembleMC7
+const (
+	MnemonicsEnglish = iota // A I 0.1, JU, OPN
+	MnemonicsGerman         // U E 0.1, SPA, AUF
+)
+
+// STLInstruction is a disassembled MC7 instruction
+type STLInstruction struct {
+	Offset   int    // byte offset in the MC7 code
+	Label    string // jump label of the jump target, such as M001
+	Mnemonic string // A, L, JC, ..., empty for an unknown opcode
+	Operand  string // I 0.1, DBW 4, M001, ...
+	Code     []byte // MC7 bytes of the instruction
+}
+
+// String formats the instruction as a line of an STL listing
+func (i STLInstruction) String() string {
+	label := ""
+	if i.Label != "" {
+		label = i.Label + ":"
+	}
+	if i.Mnemonic == "" {
+		return fmt.Sprintf("%-6s// MC7 % X", label, i.Code)
+	}
+	return strings.TrimRight(fmt.Sprintf("%-6s%-6s%s", label, i.Mnemonic, i.Operand), " ")
+}
+
+// mc7Op is an instruction of the opcode tables, operand is one of the stl* operand kinds
+type mc7Op struct {
+	mnemonic string
+	operand  int
+	area     string // area or block type of byte operands
+}
+
+// operand kinds
+const (
+	stlByte = iota
+	stlSigned
+	stlHex
+)
+
+// mc7ShortOps are the opcodes with a byte operand
+var mc7ShortOps = map[byte]mc7Op{
+	0x02: {"L", stlByte, "T"},
+	0x04: {"FR", stlByte, "T"},
+	0x0A: {"L", stlByte, "MB"},
+	0x0B: {"T", stlByte, "MB"},
+	0x0C: {"LC", stlByte, "T"},
+	0x10: {"BLD", stlByte, ""},
+	0x11: {"INC", stlByte, ""},
+	0x12: {"L", stlByte, "MW"},
+	0x13: {"T", stlByte, "MW"},
+	0x14: {"SF", stlByte, "T"},
+	0x19: {"DEC", stlByte, ""},
+	0x1A: {"L", stlByte, "MD"},
+	0x1B: {"T", stlByte, "MD"},
+	0x1C: {"SE", stlByte, "T"},
+	0x1D: {"CC", stlByte, "FC"},
+	0x20: {"OPN", stlByte, "DB"},
+	0x24: {"SD", stlByte, "T"},
+	0x28: {"L", stlHex, "B#16#"},
+	0x29: {"SLD", stlByte, ""},
+	0x2C: {"SS", stlByte, "T"},
+	0x34: {"SP", stlByte, "T"},
+	0x3C: {"R", stlByte, "T"},
+	0x3D: {"UC", stlByte, "FC"},
+	0x42: {"L", stlByte, "C"},
+	0x44: {"FR", stlByte, "C"},
+	0x4C: {"LC", stlByte, "C"},
+	0x54: {"CD", stlByte, "C"},
+	0x55: {"CC", stlByte, "FB"},
+	0x58: {"+", stlSigned, ""},
+	0x5C: {"S", stlByte, "C"},
+	0x61: {"SLW", stlByte, ""},
+	0x64: {"RLD", stlByte, ""},
+	0x69: {"SRW", stlByte, ""},
+	0x6C: {"CU", stlByte, "C"},
+	0x71: {"SSD", stlByte, ""},
+	0x74: {"RRD", stlByte, ""},
+	0x75: {"UC", stlByte, "FB"},
+	0x7C: {"R", stlByte, "C"},
+}
+
+// mc7SubOps are the opcodes whose operand byte selects the instruction
+var mc7SubOps = map[byte]map[byte]string{
+	0x00: {0x00: "NOP 0"},
+	0x01: {0x00: "INVI"},
+	0x09: {0x00: "NEGI"},
+	0x21: {0x20: ">I", 0x40: "<I", 0x60: "<>I", 0x80: "==I", 0xA0: ">=I", 0xC0: "<=I"},
+	0x31: {0x20: ">R", 0x40: "<R", 0x60: "<>R", 0x80: "==R", 0xA0: ">=R", 0xC0: "<=R"},
+	0x39: {0x20: ">D", 0x40: "<D", 0x60: "<>D", 0x80: "==D", 0xA0: ">=D", 0xC0: "<=D"},
+	0x41: {0x00: "AW"},
+	0x49: {0x00: "OW"},
+	0x51: {0x00: "XOW"},
+	0x59: {0x00: "-I"},
+	0x79: {0x00: "+I"},
+	0x60: {0x00: "/I", 0x01: "MOD", 0x02: "ABS", 0x03: "/R", 0x04: "*I", 0x06: "NEGR", 0x07: "*R", 0x08: "ENT",
+		0x09: "-D", 0x0A: "*D", 0x0B: "-R", 0x0D: "+D", 0x0E: "/D", 0x0F: "+R"},
+	0x65: {0x00: "BE", 0x01: "BEU"},
+	0x68: {0x06: "DTR", 0x07: "NEGD", 0x08: "ITB", 0x0A: "DTB", 0x0C: "BTI", 0x0D: "INVD", 0x0E: "BTD",
+		0x12: "ITD", 0x13: "RND", 0x14: "RND+", 0x15: "RND-", 0x16: "TRUNC"},
+	0xFB: {0x00: "A(", 0x01: "AN(", 0x02: "O(", 0x03: "ON(", 0x04: "X(", 0x05: "XN(", 0x06: ")", 0x07: "O"},
+	0xFE: {0x04: "LAR1", 0x05: "TAR1", 0x0C: "LAR2", 0x0D: "TAR2"},
+	0xFF: {0x00: "NOT", 0x01: "SET", 0x02: "CLR", 0x03: "SAVE", 0x06: "CAW", 0x07: "CAD", 0x08: "TAK",
+		0x09: "PUSH", 0x0A: "POP"},
+}
+
+// mc7BitOps are the bit logic operations of the short forms (bits 3..5 of the opcode) and the long
+// form (high nibble of the function byte)
+var (
+	mc7ShortBitOps = []string{"A", "O", "S", "=", "AN", "ON", "R"}
+	mc7LongBitOps  = map[byte]string{1: "A", 2: "AN", 3: "O", 4: "ON", 5: "X", 6: "XN", 7: "S", 8: "R", 9: "=",
+		0xA: "FP", 0xB: "FN"}
+	mc7LoadOps = map[byte]struct {
+		mnemonic string
+		size     string
+	}{1: {"L", "B"}, 2: {"L", "W"}, 3: {"L", "D"}, 5: {"T", "B"}, 6: {"T", "W"}, 7: {"T", "D"}}
+)
+
+// mc7Jumps are the functions of the 0x70 jumps
+var mc7Jumps = map[byte]string{0x01: "JC", 0x02: "JCN", 0x03: "JCB", 0x04: "JNB", 0x05: "JBI", 0x06: "JNBI",
+	0x07: "JO", 0x08: "LOOP", 0x09: "JL", 0x0A: "JOS", 0x0B: "JU", 0x0C: "JZ", 0x0D: "JN", 0x0E: "JP",
+	0x0F: "JM", 0x10: "JPZ", 0x11: "JMZ", 0x12: "JUO"}
+
+// mc7Calls are the functions of the 0x3E calls and opens
+var mc7Calls = map[byte][2]string{0x00: {"UC", "FC"}, 0x01: {"CC", "FC"}, 0x02: {"UC", "FB"}, 0x03: {"CC", "FB"},
+	0x04: {"UC", "SFC"}, 0x05: {"UC", "SFB"}, 0x06: {"OPN", "DB"}, 0x07: {"OPN", "DI"}}
+
+// germanMnemonics translates the English mnemonics, the others are the same in both
+var germanMnemonics = map[string]string{
+	"A": "U", "AN": "UN", "A(": "U(", "AN(": "UN(", "OPN": "AUF", "BEU": "BEA", "SF": "SA", "SE": "SV",
+	"SD": "SE", "SP": "SI", "CU": "ZV", "CD": "ZR", "CAW": "TAW", "CAD": "TAD", "AW": "UW",
+	"JU": "SPA", "JC": "SPB", "JCN": "SPBN", "JCB": "SPBB", "JNB": "SPBNB", "JBI": "SPBI", "JNBI": "SPBIN",
+	"JO": "SPO", "JOS": "SPS", "JZ": "SPZ", "JN": "SPN", "JP": "SPP", "JM": "SPM", "JPZ": "SPPZ",
+	"JMZ": "SPMZ", "JUO": "SPU", "JL": "SPL",
+}
+
+// areas of the long forms, loads from P read PI and transfers write PQ
+var mc7Areas = map[byte]string{1: "P", 2: "I", 3: "Q", 4: "M", 5: "DB", 6: "DI", 7: "L"}
+
+// germanArea translates the area of an operand such as IW, PQB or C
+func germanArea(area string) string {
+	switch {
+	case strings.HasPrefix(area, "PI"):
+		return "PE" + area[2:]
+	case strings.HasPrefix(area, "PQ"):
+		return "PA" + area[2:]
+	case strings.HasPrefix(area, "I"):
+		return "E" + area[1:]
+	case strings.HasPrefix(area, "Q"):
+		return "A" + area[1:]
+	case area == "C":
+		return "Z"
+	}
+	return area
+}
+
+// stlDecoder disassembles MC7 code
+type stlDecoder struct {
+	code    []byte
+	german  bool
+	targets map[int]int // jump instruction offset -> target offset
+}
+
+// DisassembleMC7 converts the MC7 code of an OB, FC or FB (MC7Block.Body) into STL instructions in English
+// or German mnemonics. The targets of jumps get labels M001, M002, ... in the order of the code.
+func DisassembleMC7(code []byte, mnemonics int) ([]STLInstruction, error) {
+	d := &stlDecoder{code: code, german: mnemonics == MnemonicsGerman, targets: map[int]int{}}
+	var instructions []STLInstruction
+	starts := map[int]int{}
+	for pos := 0; pos < len(code); {
+		i, err := d.instruction(pos)
+		if err != nil {
+			return nil, err
+		}
+		starts[pos] = len(instructions)
+		instructions = append(instructions, i)
+		pos += len(i.Code)
+	}
+	// label the targets in the order of the code
+	labels := map[int]string{}
+	for _, target := range d.targets {
+		if _, ok := starts[target]; !ok {
+			return nil, fmt.Errorf("s7: MC7 jump to byte %d is not at an instruction", target)
+		}
+		labels[target] = ""
+	}
+	count := 0
+	for n, i := range instructions {
+		if _, ok := labels[i.Offset]; ok {
+			count++
+			labels[i.Offset] = fmt.Sprintf("M%03d", count)
+			instructions[n].Label = labels[i.Offset]
+		}
+	}
+	for n, i := range instructions {
+		if target, ok := d.targets[i.Offset]; ok {
+			instructions[n].Operand = labels[target]
+		}
+	}
+	return instructions, nil
+}
+
+// instruction decodes the instruction at pos
+func (d *stlDecoder) instruction(pos int) (i STLInstruction, err error) {
+	i.Offset = pos
+	if pos+2 > len(d.code) {
+		i.Code = d.code[pos:]
+		return
+	}
+	opcode, operand := d.code[pos], d.code[pos+1]
+	size := 2
+	switch {
+	case opcode == 0x30 || opcode == 0x3E || opcode == 0x70 || opcode == 0x7E || opcode == 0x7F:
+		size = 4
+	case opcode == 0x38:
+		size = 6
+	}
+	if pos+size > len(d.code) {
+		return i, fmt.Errorf("s7: MC7 instruction at byte %d exceeds the code", pos)
+	}
+	i.Code = d.code[pos : pos+size]
+	var word uint16
+	if size > 2 {
+		word = binary.BigEndian.Uint16(d.code[pos+2:])
+	}
+	switch {
+	case opcode >= 0x80 && opcode < 0xB8:
+		i.Mnemonic, i.Operand = mc7ShortBitOps[(opcode-0x80)>>3], d.area("M")+" "+strconv.Itoa(int(operand))+"."+strconv.Itoa(int(opcode&7))
+	case opcode >= 0xC0 && opcode < 0xF8:
+		area := "I"
+		if operand >= 0x80 {
+			area = "Q"
+		}
+		i.Mnemonic, i.Operand = mc7ShortBitOps[(opcode-0xC0)>>3], d.area(area)+" "+strconv.Itoa(int(operand&0x7F))+"."+strconv.Itoa(int(opcode&7))
+	case opcode == 0x30:
+		i.Mnemonic, i.Operand = "L", stlConstant16(operand, word)
+	case opcode == 0x38:
+		i.Mnemonic, i.Operand = "L", stlConstant32(operand, binary.BigEndian.Uint32(d.code[pos+2:]))
+	case opcode == 0x3E:
+		if call, ok := mc7Calls[operand]; ok {
+			i.Mnemonic, i.Operand = call[0], call[1]+" "+strconv.Itoa(int(word))
+		}
+	case opcode == 0x70:
+		if jump, ok := mc7Jumps[operand]; ok {
+			i.Mnemonic = jump
+			d.targets[pos] = pos + 2*int(int16(word))
+		}
+	case opcode == 0x7E:
+		op, ok := mc7LoadOps[operand>>4]
+		area, known := mc7Areas[operand&0x0F]
+		if ok && known {
+			if area == "P" {
+				area = map[string]string{"L": "PI", "T": "PQ"}[op.mnemonic]
+			}
+			i.Mnemonic, i.Operand = op.mnemonic, d.area(area+op.size)+" "+strconv.Itoa(int(word))
+		}
+	case opcode == 0x7F:
+		op, ok := mc7LongBitOps[operand>>4]
+		area, known := mc7Areas[operand&0x0F]
+		if ok && known && area != "P" {
+			if area == "DB" || area == "DI" {
+				area += "X"
+			}
+			i.Mnemonic, i.Operand = op, d.area(area)+" "+strconv.Itoa(int(word>>3))+"."+strconv.Itoa(int(word&7))
+		}
+	default:
+		if op, ok := mc7ShortOps[opcode]; ok {
+			i.Mnemonic = op.mnemonic
+			switch op.operand {
+			case stlSigned:
+				i.Operand = strconv.Itoa(int(int8(operand)))
+			case stlHex:
+				i.Operand = fmt.Sprintf("%s%02X", op.area, operand)
+			default:
+				i.Operand = strings.TrimLeft(d.area(op.area)+" "+strconv.Itoa(int(operand)), " ")
+			}
+		} else if mnemonic, ok := mc7SubOps[opcode][operand]; ok {
+			i.Mnemonic = mnemonic
+			if fields := strings.Fields(mnemonic); len(fields) == 2 {
+				i.Mnemonic, i.Operand = fields[0], fields[1]
+			}
+		}
+	}
+	if d.german && i.Mnemonic != "" {
+		if german, ok := germanMnemonics[i.Mnemonic]; ok {
+			i.Mnemonic = german
+		}
+	}
+	return
+}
+
+// area returns the area of an operand in the mnemonics of the decoder
+func (d *stlDecoder) area(area string) string {
+	if d.german {
+		return germanArea(area)
+	}
+	return area
+}
+
+// stlConstant16 formats the 16 bits constant of an 0x30 load
+func stlConstant16(typ byte, value uint16) string {
+	switch typ {
+	case 0x01:
+		return fmt.Sprintf("2#%016b", value)
+	case 0x03:
+		return strconv.Itoa(int(int16(value)))
+	case 0x05:
+		var s7 Helper
+		return "S5T#" + stlDuration(s7.GetS5TimeAt([]byte{byte(value >> 8), byte(value)}, 0))
+	case 0x06:
+		return fmt.Sprintf("C#%X", value)
+	}
+	return fmt.Sprintf("W#16#%04X", value)
+}
+
+// stlConstant32 formats the 32 bits constant of an 0x38 load
+func stlConstant32(typ byte, value uint32) string {
+	switch typ {
+	case 0x01:
+		return strconv.FormatFloat(float64(math.Float32frombits(value)), 'g', -1, 32)
+	case 0x03:
+		return "L#" + strconv.Itoa(int(int32(value)))
+	case 0x04:
+		return "P#" + strconv.Itoa(int(value>>3)) + "." + strconv.Itoa(int(value&7))
+	case 0x06:
+		return "T#" + stlDuration(time.Duration(int32(value))*time.Millisecond)
+	case 0x07:
+		return "TOD#" + time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(value)*time.Millisecond).Format("15:04:05.000")
+	}
+	return fmt.Sprintf("DW#16#%08X", value)
+}
+
+// stlDuration formats a duration as in T# and S5T# constants, such as 1H2M3S4MS
+func stlDuration(d time.Duration) string {
+	s := ""
+	if d < 0 {
+		s, d = "-", -d
+	}
+	units := []struct {
+		unit string
+		d    time.Duration
+	}{{"D", 24 * time.Hour}, {"H", time.Hour}, {"M", time.Minute}, {"S", time.Second}, {"MS", time.Millisecond}}
+	for _, u := range units {
+		if n := d / u.d; n > 0 {
+			s += strconv.Itoa(int(n)) + u.unit
+			d -= n * u.d
+		}
+	}
+	if s == "" || s == "-" {
+		s += "0MS"
+	}
+	return s
+}
+
+// STL disassembles the code of an OB, FC or FB written in STL, LAD, FBD, SCL or GRAPH into an STL listing,
+// one instruction per line
+func (b *MC7Block) STL(mnemonics int) (string, error) {
+	switch b.BlkType {
+	case S7BlockOB, S7BlockFC, S7BlockFB, S7BlockSFC, S7BlockSFB:
+	default:
+		return "", fmt.Errorf("s7: block type 0x%02X holds no code", b.BlkType)
+	}
+	if b.BlkLang < S7LangSTL || b.BlkLang > S7LangGRAPH || b.BlkLang == S7LangDB {
+		return "", fmt.Errorf("s7: block language %d cannot be listed in STL", b.BlkLang)
+	}
+	instructions, err := DisassembleMC7(b.Body, mnemonics)
+	if err != nil {
+		return "", err
+	}
+	var listing strings.Builder
+	for _, i := range instructions {
+		listing.WriteString(i.String())
+		listing.WriteByte('\n')
+	}
+	return listing.String(), nil
+}
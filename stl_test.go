@@ -0,0 +1,107 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"strings"
+	"testing"
+)
+
+var testMC7Code = []byte{
+	0xC1, 0x00, // A I 0.1
+	0x70, 0x01, 0x00, 0x04, // JC M001
+	0xE7, 0x84, // AN Q 4.7
+	0x9B, 0x0A, // = M 10.3
+	0x7E, 0x24, 0x01, 0x2C, // M001: L MW 300
+	0x38, 0x01, 0x3F, 0xC0, 0x00, 0x00, // L 1.5
+	0x30, 0x02, 0xAB, 0xCD, // L W#16#ABCD
+	0x30, 0x05, 0x20, 0x02, // L S5T#2S
+	0x3E, 0x00, 0x01, 0x2C, // M002: UC FC 300
+	0x20, 0x0A, // OPN DB 10
+	0x06, 0x00, // unknown
+	0x70, 0x0B, 0xFF, 0xFC, // JU M002
+	0x7F, 0x95, 0x00, 0x11, // = DBX 2.1
+	0x65, 0x00, // BE
+}
+
+func TestDisassembleMC7(t *testing.T) {
+	instructions, err := DisassembleMC7(testMC7Code, MnemonicsEnglish)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"      A     I 0.1",
+		"      JC    M001",
+		"      AN    Q 4.7",
+		"      =     M 10.3",
+		"M001: L     MW 300",
+		"      L     1.5",
+		"      L     W#16#ABCD",
+		"      L     S5T#2S",
+		"M002: UC    FC 300",
+		"      OPN   DB 10",
+		"      // MC7 06 00",
+		"      JU    M002",
+		"      =     DBX 2.1",
+		"      BE",
+	}
+	if len(instructions) != len(expected) {
+		t.Fatalf("%d instructions instead of %d: %v", len(instructions), len(expected), instructions)
+	}
+	for i, e := range expected {
+		if s := instructions[i].String(); s != e {
+			t.Errorf("instruction %d: %q instead of %q", i, s, e)
+		}
+	}
+	if i := instructions[5]; i.Offset != 14 || len(i.Code) != 6 {
+		t.Errorf("unexpected instruction %+v", i)
+	}
+
+	instructions, err = DisassembleMC7(testMC7Code, MnemonicsGerman)
+	if err != nil {
+		t.Fatal(err)
+	}
+	german := map[int]string{0: "      U     E 0.1", 1: "      SPB   M001", 2: "      UN    A 4.7", 9: "      AUF   DB 10",
+		11: "      SPA   M002"}
+	for i, e := range german {
+		if s := instructions[i].String(); s != e {
+			t.Errorf("instruction %d: %q instead of %q", i, s, e)
+		}
+	}
+}
+
+func TestDisassembleMC7Errors(t *testing.T) {
+	if _, err := DisassembleMC7([]byte{0x70, 0x0B, 0x00, 0x03, 0x65, 0x00}, MnemonicsEnglish); err == nil ||
+		!strings.Contains(err.Error(), "jump to byte 6") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := DisassembleMC7([]byte{0x65, 0x00, 0x38, 0x01, 0x00}, MnemonicsEnglish); err == nil ||
+		!strings.Contains(err.Error(), "byte 2 exceeds") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestMC7BlockSTL(t *testing.T) {
+	data := mc7Block(S7BlockFC, 1, []byte{0xC1, 0x00, 0x65, 0x00}, nil, nil)
+	data[4] = S7LangLAD
+	b, err := ParseMC7(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listing, err := b.STL(MnemonicsEnglish)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listing != "      A     I 0.1\n      BE\n" {
+		t.Errorf("unexpected listing %q", listing)
+	}
+	b.BlkLang = S7LangDB
+	if _, err = b.STL(MnemonicsEnglish); err == nil {
+		t.Error("expected an error for a DB language")
+	}
+	b.BlkType, b.BlkLang = S7BlockDB, S7LangSTL
+	if _, err = b.STL(MnemonicsEnglish); err == nil {
+		t.Error("expected an error for a DB")
+	}
+}
@@ -108,7 +108,6 @@ func (mb *client) GetOrderCode() (info S7OrderCode, err error) {
 //internal function readSZL
 func (mb *client) readSzl(id int, index int) (szl S7SZL, size int, err error) {
 	var dataSZL int
-	offset := 0
 	var done bool
 	first := true
 	var seqIn byte = 0x00
@@ -151,31 +150,30 @@ func (mb *client) readSzl(id int, index int) (szl S7SZL, size int, err error) {
 			dataSZL = int(binary.BigEndian.Uint16(res.Data[31:])) - 8 // Skips extra params (ID, Index ...)
 			done = res.Data[26] == 0x00
 			seqIn = byte(res.Data[24]) // Slice sequence
+			if dataSZL < 0 || len(res.Data) < 41+dataSZL {
+				err = fmt.Errorf(ErrorText(errIsoInvalidPDU))
+				return
+			}
 			//header
 			header := SZLHeader{}
 			header.LengthHeader = binary.BigEndian.Uint16(res.Data[37:])
 			header.NumberOfDataRecord = binary.BigEndian.Uint16(res.Data[39:])
-			//data
-			data := make([]byte, offset+dataSZL)
-			copy(data[offset:offset+dataSZL], res.Data[41:41+dataSZL])
 			//s7szl
 			szl.Header = header
-			szl.Data = data
-
-			offset += dataSZL
-			szl.Header.LengthHeader += szl.Header.LengthHeader
+			szl.Data = append(szl.Data, res.Data[41:41+dataSZL]...)
 		} else {
+			//follow-up slices carry data only, right after the data header
 			dataSZL = int(binary.BigEndian.Uint16(res.Data[31:]))
 			done = res.Data[26] == 0x00
 			seqIn = byte(res.Data[24]) // Slice sequence
-			data := make([]byte, offset+dataSZL)
-			szl.Data = data
-
-			copy(szl.Data[offset:offset+dataSZL], res.Data[37:37+dataSZL])
-			offset += dataSZL
-			szl.Header.LengthHeader += szl.Header.LengthHeader
+			if len(res.Data) < 33+dataSZL {
+				err = fmt.Errorf(ErrorText(errIsoInvalidPDU))
+				return
+			}
+			szl.Data = append(szl.Data, res.Data[33:33+dataSZL]...)
 		}
 		first = false
 	}
+	size = len(szl.Data)
 	return szl, size, err
 }
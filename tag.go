@@ -0,0 +1,416 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// Tag is a named PLC variable with its absolute address, e.g. imported by ParseOpenness
+type Tag struct {
+	Name    string    // symbolic name, members of DBs as "DB name.member", such as "Motors.Speed"
+	Address S7Address // absolute address, see S7Address.String
+	Type    string    // S7 type, such as BOOL, INT, REAL or STRING[20], empty if unknown
+	Comment string
+}
+
+// TagTable maps symbolic names to tags. It is filled in code (Add, AddTags) or loaded from CSV (LoadCSV)
+// and STEP 7 symbol table exports (LoadSymbolTable), the client resolves ReadTag, WriteTag and ReadTags
+// through it, see Client.SetTagTable.
+type TagTable struct {
+	tags  map[string]Tag
+	names []string
+}
+
+// NewTagTable creates a tag table holding the tags
+func NewTagTable(tags ...Tag) (*TagTable, error) {
+	t := &TagTable{tags: map[string]Tag{}}
+	if err := t.AddTags(tags...); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Add adds a tag by name, address in S7 syntax (see ParseAddress) and optionally its S7 type
+func (t *TagTable) Add(name string, address string, typeName string) error {
+	a, err := ParseAddress(address)
+	if err != nil {
+		return err
+	}
+	return t.AddTags(Tag{Name: name, Address: a, Type: strings.ToUpper(strings.TrimSpace(typeName))})
+}
+
+// AddTags adds tags, a name can be added once only. If a tag cannot be added, none of them is.
+func (t *TagTable) AddTags(tags ...Tag) error {
+	added := map[string]bool{}
+	for _, tag := range tags {
+		if err := checkTag(tag); err != nil {
+			return err
+		}
+		if _, ok := t.tags[tag.Name]; ok || added[tag.Name] {
+			return fmt.Errorf("s7: tag %q is defined twice", tag.Name)
+		}
+		added[tag.Name] = true
+	}
+	if t.tags == nil {
+		t.tags = map[string]Tag{}
+	}
+	for _, tag := range tags {
+		t.tags[tag.Name] = tag
+		t.names = append(t.names, tag.Name)
+	}
+	return nil
+}
+
+// checkTag checks a tag on its own: it has a name and its type fits the address
+func checkTag(tag Tag) error {
+	if tag.Name == "" {
+		return fmt.Errorf("s7: tag of %s without name", tag.Address)
+	}
+	_, err := tagType(tag)
+	return err
+}
+
+// Lookup returns the tag of a name
+func (t *TagTable) Lookup(name string) (Tag, bool) {
+	tag, ok := t.tags[name]
+	return tag, ok
+}
+
+// Tags returns the tags in the order they were added
+func (t *TagTable) Tags() []Tag {
+	tags := make([]Tag, len(t.names))
+	for i, name := range t.names {
+		tags[i] = t.tags[name]
+	}
+	return tags
+}
+
+// LoadCSV adds the tags of a CSV file with the columns name, address and optionally type and comment,
+// such as "Speed,DB12.DBD40,REAL,set point". The separator is a comma or a semicolon, a first line with
+// the column titles (its second column "address") is skipped. The tags are added only if all records are valid.
+func (t *TagTable) LoadCSV(r io.Reader) error {
+	reader := bufio.NewReader(r)
+	first, _ := reader.Peek(4096)
+	line := string(first)
+	if end := strings.IndexByte(line, '\n'); end >= 0 {
+		line = line[:end]
+	}
+	records := csv.NewReader(reader)
+	records.FieldsPerRecord = -1
+	records.TrimLeadingSpace = true
+	records.Comment = '#'
+	if strings.Contains(line, ";") {
+		records.Comma = ';'
+	}
+	var tags []Tag
+	for n := 1; ; n++ {
+		record, err := records.Read()
+		if err == io.EOF {
+			return t.AddTags(tags...)
+		}
+		if err != nil {
+			return fmt.Errorf("s7: tag CSV: %v", err)
+		}
+		if n == 1 && len(record) > 1 && strings.EqualFold(strings.TrimSpace(record[1]), "address") {
+			continue
+		}
+		if len(record) < 2 {
+			return fmt.Errorf("s7: tag CSV record %d: expected name and address", n)
+		}
+		tag := Tag{Name: strings.TrimSpace(record[0])}
+		if tag.Address, err = ParseAddress(record[1]); err != nil {
+			return fmt.Errorf("s7: tag CSV record %d: %v", n, err)
+		}
+		if len(record) > 2 {
+			tag.Type = strings.ToUpper(strings.TrimSpace(record[2]))
+		}
+		if len(record) > 3 {
+			tag.Comment = strings.TrimSpace(record[3])
+		}
+		if err = checkTag(tag); err != nil {
+			return fmt.Errorf("s7: tag CSV record %d: %v", n, strings.TrimPrefix(err.Error(), "s7: "))
+		}
+		tags = append(tags, tag)
+	}
+}
+
+// LoadSymbolTable adds the symbols of a STEP 7 symbol table export, either .sdf ("Symbol","Address",
+// "Type","Comment" in quotes) or .asc (lines "126," followed by symbol, address, type and comment in
+// columns of 24, 12, 10 and 80 characters). Symbols of blocks and of the periphery are skipped.
+// The symbols are added only if all lines are valid.
+func (t *TagTable) LoadSymbolTable(r io.Reader) error {
+	reader := bufio.NewReader(r)
+	if head, _ := reader.Peek(4); string(head) == "126," {
+		tags, err := parseASC(reader)
+		if err != nil {
+			return err
+		}
+		return t.AddTags(tags...)
+	}
+	records := csv.NewReader(reader)
+	records.FieldsPerRecord = -1
+	var tags []Tag
+	for n := 1; ; n++ {
+		record, err := records.Read()
+		if err == io.EOF {
+			return t.AddTags(tags...)
+		}
+		if err != nil {
+			return fmt.Errorf("s7: symbol table: %v", err)
+		}
+		if len(record) < 3 {
+			return fmt.Errorf("s7: symbol table line %d: expected symbol, address and type", n)
+		}
+		comment := ""
+		if len(record) > 3 {
+			comment = record[3]
+		}
+		if tags, err = appendSymbol(tags, record[0], record[1], record[2], comment); err != nil {
+			return fmt.Errorf("s7: symbol table line %d: %v", n, err)
+		}
+	}
+}
+
+// parseASC returns the tags of the symbols of an .asc export
+func parseASC(r io.Reader) (tags []Tag, err error) {
+	lines := bufio.NewScanner(r)
+	for n := 1; lines.Scan(); n++ {
+		line := strings.TrimRight(lines.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "126,") {
+			return nil, fmt.Errorf("s7: symbol table line %d: expected 126,", n)
+		}
+		columns := make([]string, 4)
+		rest := line[4:]
+		for i, width := range []int{24, 12, 10} {
+			if len(rest) < width {
+				width = len(rest)
+			}
+			columns[i], rest = rest[:width], rest[width:]
+		}
+		columns[3] = rest
+		if tags, err = appendSymbol(tags, columns[0], columns[1], columns[2], columns[3]); err != nil {
+			return nil, fmt.Errorf("s7: symbol table line %d: %v", n, err)
+		}
+	}
+	return tags, lines.Err()
+}
+
+// appendSymbol appends the tag of a symbol of a symbol table, addresses are written like "E       0.0"
+// or "MW     10"
+func appendSymbol(tags []Tag, symbol, address, typeName, comment string) ([]Tag, error) {
+	address = strings.ToUpper(strings.TrimSpace(address))
+	for _, prefix := range []string{"OB", "FB", "FC", "SFB", "SFC", "DB", "UDT", "VAT", "P"} {
+		if strings.HasPrefix(address, prefix) && !strings.Contains(address, ".DB") {
+			return tags, nil
+		}
+	}
+	a, err := ParseAddress(address)
+	if err != nil {
+		return tags, err
+	}
+	tag := Tag{Name: strings.TrimSpace(symbol), Address: a, Type: strings.ToUpper(strings.TrimSpace(typeName)),
+		Comment: strings.TrimSpace(comment)}
+	if err = checkTag(tag); err != nil {
+		return tags, err
+	}
+	return append(tags, tag), nil
+}
+
+// dbTags returns the tags of the elementary variables of a DB layout, prefixed with the name of the DB
+func dbTags(l *DBLayout) []Tag {
+	var tags []Tag
+	for _, v := range l.Elementary() {
+		tags = append(tags, Tag{Name: l.Name + "." + v.Name, Address: v.Address, Type: v.Type, Comment: v.Comment})
+	}
+	return tags
+}
+
+// tagType returns the S7 type of a tag that is read and written as that type, nil if the tag is read
+// by its address alone (no or an unknown type, timers, counters and arrays). The type has to fit the address.
+func tagType(tag Tag) (*s7Type, error) {
+	if tag.Type == "" || tag.Address.WordLen == s7wltimer || tag.Address.WordLen == s7wlcounter {
+		return nil, nil
+	}
+	typ, err := lookupS7Type(tag.Type)
+	if err != nil {
+		return nil, nil
+	}
+	if typ.bits == 1 {
+		if tag.Address.WordLen != s7wlbit {
+			return nil, fmt.Errorf("s7: tag %q: BOOL does not fit %s", tag.Name, tag.Address)
+		}
+		if tag.Address.Amount > 1 {
+			return nil, nil
+		}
+		return typ, nil
+	}
+	size := tag.Address.Size()
+	if tag.Address.WordLen == s7wlbit || size%(typ.bits/8) != 0 {
+		return nil, fmt.Errorf("s7: tag %q: %s does not fit %s", tag.Name, tag.Type, tag.Address)
+	}
+	if size != typ.bits/8 {
+		// an array of the type
+		return nil, nil
+	}
+	return typ, nil
+}
+
+// tagGoTypes are the Go types of the values of typed tags
+var tagGoTypes = map[string]reflect.Type{
+	"BOOL":          reflect.TypeOf(false),
+	"BYTE":          reflect.TypeOf(uint8(0)),
+	"CHAR":          reflect.TypeOf(uint8(0)),
+	"USINT":         reflect.TypeOf(uint8(0)),
+	"SINT":          reflect.TypeOf(int8(0)),
+	"WORD":          reflect.TypeOf(uint16(0)),
+	"UINT":          reflect.TypeOf(uint16(0)),
+	"INT":           reflect.TypeOf(int16(0)),
+	"DWORD":         reflect.TypeOf(uint32(0)),
+	"UDINT":         reflect.TypeOf(uint32(0)),
+	"DINT":          reflect.TypeOf(int32(0)),
+	"LWORD":         reflect.TypeOf(uint64(0)),
+	"ULINT":         reflect.TypeOf(uint64(0)),
+	"LINT":          reflect.TypeOf(int64(0)),
+	"REAL":          reflect.TypeOf(float32(0)),
+	"LREAL":         reflect.TypeOf(float64(0)),
+	"STRING":        reflect.TypeOf(""),
+	"WSTRING":       reflect.TypeOf(""),
+	"TIME":          durationType,
+	"S5TIME":        durationType,
+	"DATE":          timeType,
+	"TIME_OF_DAY":   timeType,
+	"DATE_AND_TIME": timeType,
+	"DTL":           timeType,
+}
+
+// decodeTag converts the memory of a tag into its value: typed tags as the Go type of their S7 type
+// (int16 for INT, float32 for REAL, time.Duration for TIME, ...), the others like Read except that
+// a single DB bit is a bool
+func decodeTag(tag Tag, buffer []byte) (interface{}, error) {
+	typ, err := tagType(tag)
+	if err != nil {
+		return nil, err
+	}
+	if typ == nil || typ.bits == 1 {
+		return tag.Address.decode(buffer), nil
+	}
+	v := reflect.New(tagGoTypes[typ.name]).Elem()
+	if err = typ.decode(buffer, 0, v); err != nil {
+		return nil, fmt.Errorf("s7: tag %q: %v", tag.Name, err)
+	}
+	return v.Interface(), nil
+}
+
+// encodeTag converts the value of a tag into its memory, typed tags take the Go types accepted by Marshal
+// for their S7 type, the others the types accepted by Write
+func encodeTag(tag Tag, value interface{}) ([]byte, error) {
+	typ, err := tagType(tag)
+	if err != nil {
+		return nil, err
+	}
+	if typ == nil || typ.bits == 1 {
+		return tag.Address.encode(value)
+	}
+	v := reflect.ValueOf(value)
+	if !v.IsValid() || !typ.accepts(v.Type()) {
+		return nil, fmt.Errorf("s7: tag %q: %T cannot be written to %s", tag.Name, value, tag.Type)
+	}
+	buffer := make([]byte, tag.Address.Size())
+	if err = typ.encode(buffer, 0, v); err != nil {
+		return nil, fmt.Errorf("s7: tag %q: %v", tag.Name, err)
+	}
+	return buffer, nil
+}
+
+// tag resolves a name through the tag table of the client
+func (mb *client) tag(name string) (Tag, error) {
+	if mb.tags == nil {
+		return Tag{}, fmt.Errorf("s7: no tag table, see SetTagTable")
+	}
+	tag, ok := mb.tags.Lookup(name)
+	if !ok {
+		return tag, fmt.Errorf("s7: unknown tag %q", name)
+	}
+	return tag, nil
+}
+
+// SetTagTable sets the tag table that ReadTag, WriteTag and ReadTags resolve the names through
+func (mb *client) SetTagTable(table *TagTable) {
+	mb.tags = table
+}
+
+// ReadTag reads a tag of the tag table, see decodeTag for the Go type of the value
+func (mb *client) ReadTag(name string) (value interface{}, err error) {
+	tag, err := mb.tag(name)
+	if err != nil {
+		return
+	}
+	buffer := make([]byte, tag.Address.Size())
+	if err = mb.readAddress(tag.Address, buffer); err != nil {
+		return
+	}
+	return decodeTag(tag, buffer)
+}
+
+// WriteTag writes a tag of the tag table, BOOLs are written alone without reading their byte first
+func (mb *client) WriteTag(name string, value interface{}) (err error) {
+	tag, err := mb.tag(name)
+	if err != nil {
+		return
+	}
+	buffer, err := encodeTag(tag, value)
+	if err != nil {
+		return
+	}
+	return mb.writeAddress(tag.Address, buffer)
+}
+
+// ReadTags reads tags of the tag table with multi item reads (see ReadItems) and returns their values in
+// the order of the names. A tag that cannot be read has a nil value, the first error is returned.
+func (mb *client) ReadTags(names ...string) (values []interface{}, err error) {
+	tags := make([]Tag, len(names))
+	items := make([]S7DataItem, len(names))
+	for i, name := range names {
+		if tags[i], err = mb.tag(name); err != nil {
+			return nil, err
+		}
+		items[i] = tagItem(tags[i].Address)
+	}
+	values = make([]interface{}, len(names))
+	readErr := mb.ReadItems(items)
+	for i, item := range items {
+		if item.Error != "" {
+			if err == nil {
+				err = fmt.Errorf("s7: tag %q: %s", names[i], item.Error)
+			}
+			continue
+		}
+		var decodeErr error
+		if values[i], decodeErr = decodeTag(tags[i], item.Data); decodeErr != nil && err == nil {
+			err = decodeErr
+		}
+	}
+	if err == nil {
+		err = readErr
+	}
+	return
+}
+
+// tagItem returns the item reading the memory spanned by an address, as readAddress
+func tagItem(a S7Address) S7DataItem {
+	if a.WordLen == s7wltimer || a.WordLen == s7wlcounter {
+		return S7DataItem{Area: a.Area, WordLen: a.WordLen, Start: a.Start, Amount: a.Amount, Data: make([]byte, a.Size())}
+	}
+	return S7DataItem{Area: a.Area, WordLen: s7wlbyte, DBNumber: a.DBNumber, Start: a.Start, Amount: a.Size(),
+		Data: make([]byte, a.Size())}
+}
@@ -0,0 +1,197 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTagTableLoadCSV(t *testing.T) {
+	table, err := NewTagTable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := "name;address;type;comment\nSpeed;DB12.DBD40;real;set point\n# spare\nStart; E0.1\n"
+	if err = table.LoadCSV(strings.NewReader(src)); err != nil {
+		t.Fatal(err)
+	}
+	tags := table.Tags()
+	if len(tags) != 2 {
+		t.Fatalf("unexpected tags %+v", tags)
+	}
+	if tag := tags[0]; tag.Name != "Speed" || tag.Address.String() != "DB12.DBD40" || tag.Type != "REAL" || tag.Comment != "set point" {
+		t.Errorf("unexpected tag %+v", tag)
+	}
+	if tag, ok := table.Lookup("Start"); !ok || tag.Address.String() != "E0.1" || tag.Type != "" {
+		t.Errorf("unexpected tag %+v", tag)
+	}
+	errors := map[string]string{
+		"Speed,DB12.DBD40\n":                 `tag "Speed" is defined twice`,
+		"Level,MW10,REAL\n":                  `tag "Level": REAL does not fit MW10`,
+		"Level,MX10\n":                       "tag CSV record 1",
+		"Level\n":                            "expected name and address",
+		"Flag,M1.0,INT\n":                    `tag "Flag": INT does not fit M1.0`,
+		"Name,DB1.DBB0,STRING\n":             `STRING does not fit`,
+		"Words,DB1.DBW0[3],INT\n":            "",
+		"Level,MW10\nFlag,M1.0,INT\n":        `tag CSV record 2: tag "Flag": INT does not fit M1.0`,
+		"Level,MW10\nLevel,MW12\n":           `tag "Level" is defined twice`,
+		"Level,MW10\nFlag,M1.0\nSpeed,MD0\n": `tag "Speed" is defined twice`,
+	}
+	for src, expected := range errors {
+		table, err := NewTagTable(tags...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = table.LoadCSV(strings.NewReader(src))
+		if expected == "" && err != nil || expected != "" && (err == nil || !strings.Contains(err.Error(), expected)) {
+			t.Errorf("error %v, expected %q", err, expected)
+		}
+		// nothing is added from a CSV file with an invalid record
+		if n := len(table.Tags()); expected != "" && n != len(tags) {
+			t.Errorf("%q: %d tags after the error", src, n)
+		}
+	}
+}
+
+func TestTagTableAddTags(t *testing.T) {
+	speed := Tag{Name: "Speed", Address: S7Address{Area: s7areadb, DBNumber: 1, WordLen: s7wldword, Amount: 1}, Type: "REAL"}
+	level := Tag{Name: "Level", Address: S7Address{Area: s7areamk, WordLen: s7wlword, Amount: 1}, Type: "REAL"}
+	if table, err := NewTagTable(speed, level); err == nil || table != nil {
+		t.Errorf("unexpected table %v: %v", table, err)
+	}
+	table, err := NewTagTable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = table.AddTags(speed, level); err == nil || len(table.Tags()) != 0 {
+		t.Errorf("unexpected tags %+v: %v", table.Tags(), err)
+	}
+	if err = table.AddTags(speed, speed); err == nil || len(table.Tags()) != 0 {
+		t.Errorf("unexpected tags %+v: %v", table.Tags(), err)
+	}
+	if err = table.AddTags(speed); err != nil || len(table.Tags()) != 1 {
+		t.Errorf("unexpected tags %+v: %v", table.Tags(), err)
+	}
+}
+
+func TestTagTableLoadSymbolTable(t *testing.T) {
+	asc := "126,Start                   E       0.1 BOOL      start button\r\n" +
+		"126,Speed                   MD     10   REAL      \r\n" +
+		"126,Main                    OB      1   OB      1 cycle\r\n" +
+		"126,Input                   PEW   256   INT                 \r\n"
+	table := &TagTable{}
+	if err := table.LoadSymbolTable(strings.NewReader(asc)); err != nil {
+		t.Fatal(err)
+	}
+	tags := table.Tags()
+	if len(tags) != 2 {
+		t.Fatalf("unexpected tags %+v", tags)
+	}
+	if tag := tags[0]; tag.Name != "Start" || tag.Address.String() != "E0.1" || tag.Type != "BOOL" || tag.Comment != "start button" {
+		t.Errorf("unexpected tag %+v", tag)
+	}
+	if tag := tags[1]; tag.Name != "Speed" || tag.Address.String() != "MD10" || tag.Type != "REAL" {
+		t.Errorf("unexpected tag %+v", tag)
+	}
+
+	sdf := `"Motor on","A       4.0","BOOL","motor contactor"` + "\r\n" +
+		`"Counter","Z      3","COUNTER",""` + "\r\n" +
+		`"Data","DB     10","DB     10",""` + "\r\n"
+	table = &TagTable{}
+	if err := table.LoadSymbolTable(strings.NewReader(sdf)); err != nil {
+		t.Fatal(err)
+	}
+	tags = table.Tags()
+	if len(tags) != 2 || tags[0].Name != "Motor on" || tags[0].Address.String() != "A4.0" || tags[0].Comment != "motor contactor" ||
+		tags[1].Address.String() != "Z3" {
+		t.Errorf("unexpected tags %+v", tags)
+	}
+	if err := table.LoadSymbolTable(strings.NewReader(`"Level","MW     10","REAL",""`)); err == nil ||
+		!strings.Contains(err.Error(), "symbol table line 1") {
+		t.Errorf("unexpected error %v", err)
+	}
+	table = &TagTable{}
+	err := table.LoadSymbolTable(strings.NewReader(asc + "126,Level                   MW     10   REAL      \r\n"))
+	if err == nil || !strings.Contains(err.Error(), "symbol table line 5") || len(table.Tags()) != 0 {
+		t.Errorf("unexpected tags %+v: %v", table.Tags(), err)
+	}
+}
+
+func TestClientTags(t *testing.T) {
+	server := NewServer()
+	db := make([]byte, 40)
+	server.RegisterArea(S7AreaDB, 3, db)
+	server.RegisterArea(S7AreaMK, 0, make([]byte, 16))
+	handler := startServer(t, server)
+	defer server.Close()
+	defer handler.Close()
+	client := NewClient(handler)
+
+	if _, err := client.ReadTag("Speed"); err == nil || !strings.Contains(err.Error(), "no tag table") {
+		t.Errorf("unexpected error %v", err)
+	}
+	table, err := NewTagTable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range [][3]string{
+		{"Speed", "DB3.DBD0", "REAL"},
+		{"Count", "DB3.DBW4", "INT"},
+		{"Fault", "DB3.DBX6.2", "BOOL"},
+		{"Name", "DB3.DBSTRING8.10", "STRING[10]"},
+		{"Delay", "DB3.DBD20", "TIME"},
+		{"Raw", "MW2", ""},
+	} {
+		if err = table.Add(tag[0], tag[1], tag[2]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client.SetTagTable(table)
+
+	writes := map[string]interface{}{
+		"Speed": float32(1.5),
+		"Count": int16(-3),
+		"Fault": true,
+		"Name":  "pump",
+		"Delay": 2 * time.Second,
+		"Raw":   uint16(0x1234),
+	}
+	for name, value := range writes {
+		if err = client.WriteTag(name, value); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	if db[6] != 0x04 || db[4] != 0xFF || db[5] != 0xFD {
+		t.Errorf("unexpected DB3 % x", db)
+	}
+	if value, err := client.ReadTag("Count"); err != nil || value != int16(-3) {
+		t.Errorf("unexpected Count %v: %v", value, err)
+	}
+	names := []string{"Speed", "Count", "Fault", "Name", "Delay", "Raw"}
+	values, err := client.ReadTags(names...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range names {
+		if values[i] != writes[name] {
+			t.Errorf("%s: %v (%T) instead of %v", name, values[i], values[i], writes[name])
+		}
+	}
+
+	if err = client.WriteTag("Count", 3.5); err == nil || !strings.Contains(err.Error(), `tag "Count": float64 cannot be written to INT`) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err = client.ReadTags("Speed", "Level"); err == nil || !strings.Contains(err.Error(), `unknown tag "Level"`) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err = table.Add("Missing", "DB4.DBW0", "INT"); err != nil {
+		t.Fatal(err)
+	}
+	values, err = client.ReadTags("Count", "Missing")
+	if err == nil || !strings.Contains(err.Error(), `tag "Missing"`) || values[0] != int16(-3) || values[1] != nil {
+		t.Errorf("unexpected values %v: %v", values, err)
+	}
+}
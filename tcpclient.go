@@ -4,6 +4,7 @@ package gos7
 // This software may be modified and distributed under the terms
 // of the BSD license. See the LICENSE file for details.
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -31,6 +32,9 @@ const (
 	connectionTypeBasic = 3 // Basic connection
 )
 
+// aLongTimeAgo is a deadline in the past, used to abort blocked socket I/O.
+var aLongTimeAgo = time.Unix(1, 0)
+
 // TCPClientHandler implements Packager and Transporter interface.
 type TCPClientHandler struct {
 	tcpPackager
@@ -89,6 +93,12 @@ type tcpTransporter struct {
 	IdleTimeout time.Duration
 	// Transmission logger
 	Logger *log.Logger
+	// Reconnect enables re-establishing a lost connection, nil disables it
+	Reconnect *ReconnectPolicy
+	// Pipelining allows as many requests on the connection at once as the PLC accepts parallel jobs
+	// (see MaxParallelJobs), the replies are matched to the requests by the PDU reference.
+	// Requests from several goroutines overlap then instead of waiting for each other.
+	Pipelining bool
 
 	// TCP connection
 	mu           sync.Mutex
@@ -104,6 +114,12 @@ type tcpTransporter struct {
 	LastPDUType                   byte
 
 	PDULength int
+	// negotiated count of parallel jobs
+	maxAmqCalling, maxAmqCalled int
+	// pipeline of the current connection if Pipelining is enabled
+	pipeline *pipeline
+	// PDU reference of the last request
+	pduRef uint16
 }
 
 func (mb *tcpTransporter) setConnectionParameters(address string, localTSAP uint16, remoteTSAP uint16) {
@@ -121,42 +137,237 @@ func (mb *tcpTransporter) setConnectionParameters(address string, localTSAP uint
 }
 
 // Send sends data to server and ensures response length is greater than header length.
+// S7 telegrams are sent with a PDU reference of the connection, which is written into request.
 func (mb *tcpTransporter) Send(request []byte) (response []byte, err error) {
+	return mb.SendContext(context.Background(), request)
+}
+
+// SendContext is like Send but aborts the socket I/O when ctx is done and returns ctx.Err().
+// The earlier of Timeout and the context deadline applies. Since a cancelled request leaves
+// a partial telegram on the wire, the connection is closed and has to be established again.
+// With a Reconnect policy a lost connection is established again before the request is sent,
+// and the request is repeated on the new connection if it is safe to do so.
+// With Pipelining the connection is kept when ctx is done, the late reply is discarded.
+func (mb *tcpTransporter) SendContext(ctx context.Context, request []byte) (response []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if mb.Pipelining {
+		var p *pipeline
+		if p, err = mb.openPipeline(ctx); err != nil || p != nil {
+			if err == nil {
+				response, err = mb.sendPipelined(ctx, p, request)
+			}
+			return
+		}
+	}
 	mb.mu.Lock()
 	defer mb.mu.Unlock()
-	// Set timer to close when idle
-	mb.lastActivity = time.Now()
-	mb.startCloseTimer()
-	// Set write and read timeout
-	var timeout time.Time
-	if mb.Timeout > 0 {
-		timeout = mb.lastActivity.Add(mb.Timeout)
+
+	if mb.conn == nil && mb.Reconnect != nil {
+		if err = mb.reconnect(ctx); err != nil {
+			return
+		}
 	}
+	response, err = mb.send(ctx, request)
+	if err == nil || mb.Reconnect == nil || ctx.Err() != nil {
+		return
+	}
+	// The connection is broken, whatever is left on it can not be trusted anymore
+	mb.logf("s7: closing connection due to %v", err)
+	mb.close()
+	if !mb.Reconnect.retryable(request) {
+		return
+	}
+	if err = mb.reconnect(ctx); err != nil {
+		return
+	}
+	return mb.send(ctx, request)
+}
+
+// send sends the request over the current connection. Caller must hold the mutex before calling this method.
+func (mb *tcpTransporter) send(ctx context.Context, request []byte) (response []byte, err error) {
+	timeout := mb.activity(ctx)
 	if mb.conn == nil {
 		err = fmt.Errorf("Connection to address %s is null", mb.Address)
 		return
 	}
+	// Every S7 telegram gets its own PDU reference, replies to earlier requests are told apart by it
+	job := request
+	if isS7Telegram(request) {
+		if mb.pduRef++; mb.pduRef == 0 {
+			mb.pduRef++
+		}
+		binary.BigEndian.PutUint16(job[11:], mb.pduRef)
+	}
+	data := make([]byte, tcpMaxLength)
+	// Drop what is left from earlier requests, e.g. the late reply of a timed out one
+	if err = mb.flush(data); err != nil {
+		return
+	}
 	if err = mb.conn.SetDeadline(timeout); err != nil {
 		return
 	}
+	// Unblock pending reads and writes as soon as the context is done
+	stop := abortOnDone(ctx, mb.conn)
+	defer func() {
+		if stop() && err != nil {
+			response = nil
+			err = ctx.Err()
+			mb.logf("s7: closing connection due to %v", err)
+			mb.close()
+		}
+	}()
 	// Send data
-	mb.logf("s7: sending % x", request)
-	if _, err = mb.conn.Write(request); err != nil {
+	mb.logf("s7: sending % x", job)
+	if _, err = mb.conn.Write(job); err != nil {
 		return
 	}
-	done := false
+	for {
+		if response, mb.LastPDUType, err = readFrame(mb.conn, data); err != nil {
+			response = nil
+			return
+		}
+		if !isS7Telegram(job) || !isS7Telegram(response) || response[11] == job[11] && response[12] == job[12] {
+			break
+		}
+		mb.logf("s7: discarding stale reply % x", response)
+	}
+	mb.logf("s7: received % x\n", response)
+	return
+}
+
+// ReceiveJob waits for the next job the PLC sends to the client, implements Exchanger.
+// Other telegrams coming in meanwhile are discarded.
+func (mb *tcpTransporter) ReceiveJob(ctx context.Context) (job []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	mb.mu.Lock()
+	if p := mb.pipeline; p != nil {
+		// the reader of the pipeline hands the jobs over
+		mb.mu.Unlock()
+		return p.receiveJob(ctx, mb.Timeout)
+	}
+	defer mb.mu.Unlock()
+
+	stop, err := mb.exchange(ctx)
+	if err != nil {
+		return
+	}
+	defer func() { err = stop(err) }()
 	data := make([]byte, tcpMaxLength)
+	for {
+		if job, mb.LastPDUType, err = readFrame(mb.conn, data); err != nil {
+			return nil, err
+		}
+		if isS7Telegram(job) && job[8] == 1 {
+			mb.logf("s7: received job % x", job)
+			return append([]byte{}, job...), nil
+		}
+		mb.logf("s7: discarding unexpected telegram % x", job)
+	}
+}
+
+// Reply answers a job of the PLC, implements Exchanger. The reply keeps the PDU reference of the job.
+func (mb *tcpTransporter) Reply(ctx context.Context, reply []byte) (err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	mb.mu.Lock()
+	if p := mb.pipeline; p != nil {
+		mb.mu.Unlock()
+		return p.reply(reply, mb.Timeout)
+	}
+	defer mb.mu.Unlock()
+
+	stop, err := mb.exchange(ctx)
+	if err != nil {
+		return
+	}
+	defer func() { err = stop(err) }()
+	mb.logf("s7: sending % x", reply)
+	_, err = mb.conn.Write(reply)
+	return
+}
+
+// exchange prepares the connection for socket I/O of an exchange with the PLC. The returned stop ends it,
+// it returns the error of the I/O or ctx.Err() if ctx aborted the I/O. Caller must hold the mutex.
+func (mb *tcpTransporter) exchange(ctx context.Context) (stop func(err error) error, err error) {
+	timeout := mb.activity(ctx)
+	if mb.conn == nil {
+		return nil, fmt.Errorf("Connection to address %s is null", mb.Address)
+	}
+	if err = mb.conn.SetDeadline(timeout); err != nil {
+		return
+	}
+	aborted := abortOnDone(ctx, mb.conn)
+	return func(err error) error {
+		if aborted() && err != nil {
+			err = ctx.Err()
+			mb.logf("s7: closing connection due to %v", err)
+			mb.close()
+		}
+		return err
+	}, nil
+}
+
+// activity restarts the idle timer and returns the deadline of the socket I/O, the earlier of Timeout and
+// the deadline of ctx. Caller must hold the mutex before calling this method.
+func (mb *tcpTransporter) activity(ctx context.Context) (timeout time.Time) {
+	// Set timer to close when idle
+	mb.lastActivity = time.Now()
+	mb.startCloseTimer()
+	// Set write and read timeout
+	if mb.Timeout > 0 {
+		timeout = mb.lastActivity.Add(mb.Timeout)
+	}
+	if deadline, ok := ctx.Deadline(); ok && (timeout.IsZero() || deadline.Before(timeout)) {
+		timeout = deadline
+	}
+	return
+}
+
+// abortOnDone unblocks the pending reads and writes of conn as soon as ctx is done. The returned stop ends
+// the watch, it tells whether ctx aborted the I/O.
+func abortOnDone(ctx context.Context, conn net.Conn) (stop func() bool) {
+	done := make(chan struct{})
+	cancelled := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(aLongTimeAgo)
+			cancelled <- true
+		case <-done:
+			cancelled <- false
+		}
+	}()
+	return func() bool {
+		close(done)
+		return <-cancelled
+	}
+}
+
+// isS7Telegram tells whether the telegram carries an S7 header with PDU reference
+func isS7Telegram(telegram []byte) bool {
+	return len(telegram) >= 13 && telegram[7] == 0x32
+}
+
+// readFrame reads the next ISO on TCP telegram into data, empty telegrams are skipped.
+// It returns the telegram and its COTP PDU type.
+func readFrame(conn net.Conn, data []byte) (frame []byte, pduType byte, err error) {
+	done := false
 	length := 0
 	for !done && err == nil {
 		// Get TPKT (4 bytes)
-		if _, err = io.ReadFull(mb.conn, data[:4]); err != nil {
+		if _, err = io.ReadFull(conn, data[:4]); err != nil {
 			log.Printf("%T %+v", err, err)
 			return
 		}
 		// Read length, ignore transaction & protocol id (4 bytes)
 		length = int(binary.BigEndian.Uint16(data[2:]))
 		if length == isoHSize {
-			_, err = io.ReadFull(mb.conn, data[4:7])
+			_, err = io.ReadFull(conn, data[4:7])
 			if err != nil { // Skip remaining 3 bytes and Done is still false
 				return
 			}
@@ -169,35 +380,103 @@ func (mb *tcpTransporter) Send(request []byte) (response []byte, err error) {
 		}
 	}
 	// Skip remaining 3 COTP bytes
-	_, err = io.ReadFull(mb.conn, data[4:7])
+	_, err = io.ReadFull(conn, data[4:7])
 	if err != nil {
 		return
 	}
-	mb.LastPDUType = data[5] // Stores PDU Type, we need it
+	pduType = data[5] // Stores PDU Type, we need it
 	// Receives the S7 Payload
-	_, err = io.ReadFull(mb.conn, data[7:length])
+	_, err = io.ReadFull(conn, data[7:length])
 	if err != nil {
 		return
 	}
-	response = data[0:length]
-	mb.logf("s7: received % x\n", response)
-	return
+	return data[0:length], pduType, nil
+}
+
+// openPipeline returns the pipeline of the connection, the connection is established again first
+// if it is lost and a Reconnect policy is set. It returns nil if the PLC does not accept parallel jobs
+// or there is no connection, the requests are sent one after another then.
+func (mb *tcpTransporter) openPipeline(ctx context.Context) (p *pipeline, err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if mb.pipeline != nil {
+		select {
+		case <-mb.pipeline.done:
+			// the connection broke while no request was waiting
+			mb.logf("s7: closing connection due to %v", mb.pipeline.err)
+			mb.close()
+		default:
+		}
+	}
+	if mb.conn == nil && mb.Reconnect != nil {
+		if err = mb.reconnect(ctx); err != nil {
+			return
+		}
+	}
+	if mb.pipeline == nil && mb.conn != nil && mb.MaxParallelJobs() > 1 {
+		mb.pipeline = newPipeline(mb.conn, mb.MaxParallelJobs(), mb.logf)
+	}
+	if mb.pipeline != nil {
+		mb.lastActivity = time.Now()
+		mb.startCloseTimer()
+	}
+	return mb.pipeline, nil
+}
+
+// sendPipelined sends the request through the pipeline, a broken pipeline closes the connection
+// and the request is repeated on a new one according to the Reconnect policy.
+func (mb *tcpTransporter) sendPipelined(ctx context.Context, p *pipeline, request []byte) (response []byte, err error) {
+	response, err = p.send(ctx, request, mb.Timeout)
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+	mb.mu.Lock()
+	if mb.pipeline == p {
+		mb.logf("s7: closing connection due to %v", err)
+		mb.close()
+	}
+	mb.mu.Unlock()
+	if mb.Reconnect == nil || !mb.Reconnect.retryable(request) {
+		return
+	}
+	if p, err = mb.openPipeline(ctx); err != nil {
+		return
+	}
+	if p == nil {
+		mb.mu.Lock()
+		defer mb.mu.Unlock()
+		return mb.send(ctx, request)
+	}
+	return p.send(ctx, request, mb.Timeout)
 }
 
 // Connect establishes a new connection to the address in Address.
 // Connect and Close are exported so that multiple requests can be done with one session
 func (mb *tcpTransporter) Connect() error {
-	// mb.mu.Lock()
-	// defer mb.mu.Unlock()
+	return mb.ConnectContext(context.Background())
+}
+
+// ConnectContext is like Connect but gives up when ctx is done.
+func (mb *tcpTransporter) ConnectContext(ctx context.Context) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
 
-	return mb.connect()
+	return mb.connect(ctx)
 }
+
 func (mb *tcpTransporter) tcpConnect() error {
 	mb.mu.Lock()
 	defer mb.mu.Unlock()
+
+	return mb.dial(context.Background())
+}
+
+// dial opens the TCP connection. Caller must hold the mutex before calling this method.
+func (mb *tcpTransporter) dial(ctx context.Context) error {
 	if mb.conn == nil {
 		dialer := net.Dialer{Timeout: mb.Timeout}
-		conn, err := dialer.Dial("tcp", mb.Address)
+		conn, err := dialer.DialContext(ctx, "tcp", mb.Address)
 		if err != nil {
 			if conn != nil {
 				_ = conn.Close()
@@ -208,26 +487,52 @@ func (mb *tcpTransporter) tcpConnect() error {
 	}
 	return nil
 }
-func (mb *tcpTransporter) connect() error {
+
+// connect runs all stages of the connection setup. Caller must hold the mutex before calling this method.
+func (mb *tcpTransporter) connect(ctx context.Context) error {
 	//first stage: TCP connection
-	err := mb.tcpConnect()
+	err := mb.dial(ctx)
 	if err != nil {
 		return err
 	}
 	//second stage: ISOTCP (ISO 8073) Connection
-	err = mb.isoConnect()
+	err = mb.isoConnect(ctx)
+	if err == nil {
+		// Third stage : S7 protocol data unit negotiation
+		err = mb.negotiatePduLength(ctx)
+	}
 	if err != nil {
-		if mb.conn != nil {
-			_ = mb.conn.Close()
-		}
-		return err
+		mb.close()
 	}
-	// Third stage : S7 protocol data unit negotiation
-	return mb.negotiatePduLength()
+	return err
+}
 
+// reconnect establishes the connection again according to the Reconnect policy.
+// Caller must hold the mutex before calling this method.
+func (mb *tcpTransporter) reconnect(ctx context.Context) (err error) {
+	attempts := mb.Reconnect.MaxAttempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(mb.Reconnect.backoff(attempt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		mb.logf("s7: connecting to %s, attempt %d of %d", mb.Address, attempt+1, attempts)
+		if err = mb.connect(ctx); err == nil || ctx.Err() != nil {
+			return
+		}
+	}
+	return
 }
 
-func (mb *tcpTransporter) isoConnect() error {
+func (mb *tcpTransporter) isoConnect(ctx context.Context) error {
 	msg := make([]byte, len(isoConnectionRequestTelegram))
 	copy(msg, isoConnectionRequestTelegram)
 	msg[16] = mb.localTSAPHigh
@@ -236,35 +541,52 @@ func (mb *tcpTransporter) isoConnect() error {
 	msg[21] = mb.remoteTSAPLow
 
 	// Sends the connection request telegram
-	response, err := mb.Send(msg)
+	response, err := mb.send(ctx, msg)
 	if size := len(response); size == 22 {
 		if mb.LastPDUType != byte(0xD0) { // 0xD0 = CC Connection confirm
 			err = fmt.Errorf("errIsoConnect")
 		}
-	} else {
+	} else if err == nil {
 		err = fmt.Errorf(ErrorText(errIsoInvalidPDU))
 	}
 	return err
 }
-func (mb *tcpTransporter) negotiatePduLength() error {
+func (mb *tcpTransporter) negotiatePduLength(ctx context.Context) error {
 	// Set PDU Size Requested //lth
 	pduSizePackage := make([]byte, len(s7PDUNegogiationTelegram))
 	copy(pduSizePackage, s7PDUNegogiationTelegram)
 	binary.BigEndian.PutUint16(pduSizePackage[23:], uint16(pduSizeRequested))
 	// Sends the connection request telegram
-	response, err := mb.Send(pduSizePackage)
+	response, err := mb.send(ctx, pduSizePackage)
 	length := len(response)
 	if length == 27 && response[17] == 0 && response[18] == 0 { // 20 = size of Negotiate Answer
 		// Get PDU Size Negotiated
 		mb.PDULength = int(binary.BigEndian.Uint16(response[25:]))
+		mb.maxAmqCalling = int(binary.BigEndian.Uint16(response[21:]))
+		mb.maxAmqCalled = int(binary.BigEndian.Uint16(response[23:]))
 		if mb.PDULength <= 0 {
 			err = fmt.Errorf(ErrorText(errCliNegotiatingPDU))
 		}
-	} else {
+	} else if err == nil {
 		err = fmt.Errorf(ErrorText(errCliNegotiatingPDU))
 	}
 	return err
 }
+
+// PDUSize returns the PDU length negotiated with the PLC, implements Session.
+func (mb *tcpTransporter) PDUSize() int {
+	return mb.PDULength
+}
+
+// MaxParallelJobs returns the parallel jobs negotiated with the PLC, implements Session.
+// It is the smaller one of max AmQ calling and max AmQ called.
+func (mb *tcpTransporter) MaxParallelJobs() int {
+	if mb.maxAmqCalled < mb.maxAmqCalling {
+		return mb.maxAmqCalled
+	}
+	return mb.maxAmqCalling
+}
+
 func (mb *tcpTransporter) startCloseTimer() {
 	if mb.IdleTimeout <= 0 {
 		return
@@ -285,20 +607,26 @@ func (mb *tcpTransporter) Close() error {
 	return mb.close()
 }
 
-// flush flushes pending data in the connection,
+// flush discards everything which has been received but not read yet,
 // returns io.EOF if connection is closed.
 func (mb *tcpTransporter) flush(b []byte) (err error) {
-	if err = mb.conn.SetReadDeadline(time.Now()); err != nil {
-		return
-	}
-	// Timeout setting will be reset when reading
-	if _, err = mb.conn.Read(b); err != nil {
-		// Ignore timeout error
-		if netError, ok := err.(net.Error); ok && netError.Timeout() {
-			err = nil
+	for {
+		if err = mb.conn.SetReadDeadline(time.Now()); err != nil {
+			return
+		}
+		// Timeout setting will be reset when reading
+		var n int
+		if n, err = mb.conn.Read(b); n > 0 {
+			mb.logf("s7: discarding stale data % x", b[:n])
+		}
+		if err != nil {
+			// Ignore timeout error
+			if netError, ok := err.(net.Error); ok && netError.Timeout() {
+				err = nil
+			}
+			return
 		}
 	}
-	return
 }
 
 func (mb *tcpTransporter) logf(format string, v ...interface{}) {
@@ -309,6 +637,10 @@ func (mb *tcpTransporter) logf(format string, v ...interface{}) {
 
 // closeLocked closes current connection. Caller must hold the mutex before calling this method.
 func (mb *tcpTransporter) close() (err error) {
+	if mb.pipeline != nil {
+		mb.pipeline.fail(fmt.Errorf("s7: connection closed"))
+		mb.pipeline = nil
+	}
 	if mb.conn != nil {
 		err = mb.conn.Close()
 		mb.conn = nil
@@ -331,7 +663,60 @@ func (mb *tcpTransporter) closeIdle() {
 	}
 }
 
-// reserve for future use, need to verify the request and response
+// Verify checks that the response answers the request: S7 protocol id, ROSCTR (ack data for a job,
+// userdata for userdata), PDU reference, function code and item count of read/write var,
+// function group and subfunction of userdata. An ack without data to a job returns the error of the PLC.
 func (mb *tcpPackager) Verify(request []byte, response []byte) (err error) {
+	if len(request) < 19 || request[7] != 0x32 {
+		// not an S7 job or userdata, nothing to verify
+		return
+	}
+	if len(response) < 17 || response[7] != 0x32 {
+		return verifyError("no S7 telegram")
+	}
+	if length := int(binary.BigEndian.Uint16(response[2:])); length != len(response) {
+		return verifyError("%d bytes instead of the %d bytes of the TPKT header", len(response), length)
+	}
+	if ref, expected := binary.BigEndian.Uint16(response[11:]), binary.BigEndian.Uint16(request[11:]); ref != expected {
+		return verifyError("PDU reference %d instead of %d", ref, expected)
+	}
+	paramLength := int(binary.BigEndian.Uint16(response[13:]))
+	switch request[8] {
+	case 1: // job
+		if response[8] == 2 && len(response) >= 19 {
+			// ack without data, the PLC refused the job
+			if code := binary.BigEndian.Uint16(response[17:]); code != 0 {
+				return fmt.Errorf(ErrorText(CPUError(uint(code))))
+			}
+		}
+		if response[8] != 3 {
+			return verifyError("ROSCTR %d instead of ack data", response[8])
+		}
+		if paramLength == 0 || len(response) < 20 {
+			// the error class and code of the header tell what went wrong
+			return
+		}
+		if response[19] != request[17] {
+			return verifyError("function 0x%02X instead of 0x%02X", response[19], request[17])
+		}
+		if (request[17] == 0x04 || request[17] == 0x05) && (len(response) < 21 || response[20] != request[18]) {
+			return verifyError("item count does not match %d", request[18])
+		}
+	case 7: // userdata
+		if response[8] != 7 {
+			return verifyError("ROSCTR %d instead of userdata", response[8])
+		}
+		if len(request) < 24 || paramLength < 8 || len(response) < 24 {
+			return
+		}
+		if response[22]&0x0F != request[22]&0x0F || response[23] != request[23] {
+			return verifyError("function group %d subfunction %d instead of %d %d",
+				response[22]&0x0F, response[23], request[22]&0x0F, request[23])
+		}
+	}
 	return
 }
+
+func verifyError(format string, v ...interface{}) error {
+	return fmt.Errorf(ErrorText(errCliInvalidPlcAnswer)+": "+format, v...)
+}
@@ -5,8 +5,13 @@ package gos7
 // of the BSD license. See the LICENSE file for details.
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
 	"io"
 	"net"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -51,3 +56,499 @@ func TestTCPTransporter(t *testing.T) {
 		t.Fatalf("connection is not closed: %+v", client.conn)
 	}
 }
+
+func TestTCPTransporterSendContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// never answer
+		io.Copy(io.Discard, conn)
+	}()
+	client := &tcpTransporter{
+		Address: ln.Addr().String(),
+		Timeout: 10 * time.Second,
+	}
+	req := []byte{0, 1, 0, 17, 0, 2, 1, 2, 0, 1, 0, 17, 0, 2, 1, 2, 2}
+
+	client.tcpConnect()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	begin := time.Now()
+	_, err = client.SendContext(ctx, req)
+	if err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Fatalf("request was not aborted, returned after %v", elapsed)
+	}
+	if client.conn != nil {
+		t.Fatalf("connection is not closed: %+v", client.conn)
+	}
+}
+
+// servePLC answers the connection setup, read var and write var requests like a PLC accepting parallelJobs
+// would, every connection is closed by the PLC after it answered requestsPerConn read or write requests.
+func servePLC(ln net.Listener, requestsPerConn int, parallelJobs byte, connections *int32) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		atomic.AddInt32(connections, 1)
+		go func(conn net.Conn) {
+			defer conn.Close()
+			requests := 0
+			for requests < requestsPerConn {
+				header := make([]byte, 4)
+				if _, err := io.ReadFull(conn, header); err != nil {
+					return
+				}
+				request := make([]byte, binary.BigEndian.Uint16(header[2:]))
+				copy(request, header)
+				if _, err := io.ReadFull(conn, request[4:]); err != nil {
+					return
+				}
+				var response []byte
+				switch {
+				case request[5] == 0xE0: // connection request
+					response = append([]byte{}, request...)
+					response[5] = 0xD0
+				case request[17] == 0xF0: // setup communication
+					response = []byte{3, 0, 0, 27, 2, 240, 128, 50, 3, 0, 0, request[11], request[12], 0, 8, 0, 0, 0, 0, 240, 0, 0, parallelJobs, 0, parallelJobs, 0, 240}
+				case request[17] == 0x04: // read var
+					amount := int(binary.BigEndian.Uint16(request[23:]))
+					response = make([]byte, 25+amount)
+					copy(response, []byte{3, 0, 0, 0, 2, 240, 128, 50, 3, 0, 0, request[11], request[12], 0, 2, 0, 0, 0, 0, 4, 1, 0xFF, tsResByte})
+					binary.BigEndian.PutUint16(response[2:], uint16(len(response)))
+					binary.BigEndian.PutUint16(response[23:], uint16(amount<<3))
+					requests++
+				case request[17] == 0x05: // write var
+					response = []byte{3, 0, 0, 22, 2, 240, 128, 50, 3, 0, 0, request[11], request[12], 0, 2, 0, 1, 0, 0, 5, 1, 0xFF}
+					requests++
+				default:
+					return
+				}
+				if _, err := conn.Write(response); err != nil {
+					return
+				}
+			}
+		}(conn)
+	}
+}
+
+func TestTCPTransporterReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var connections int32
+	go servePLC(ln, 1, 1, &connections)
+
+	handler := NewTCPClientHandler(ln.Addr().String(), 0, 2)
+	handler.Timeout = time.Second
+	handler.Reconnect = &ReconnectPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond}
+	if err := handler.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	client := NewClient(handler)
+	buffer := make([]byte, 4)
+	for i := 0; i < 3; i++ {
+		// the PLC drops the connection after every read, reads are sent again on a new connection
+		if err := client.AGReadDB(1, 0, 4, buffer); err != nil {
+			t.Fatalf("read %d: %v", i, err)
+		}
+	}
+	if n := atomic.LoadInt32(&connections); n != 3 {
+		t.Fatalf("unexpected connection count: %d", n)
+	}
+	if handler.PDULength != 240 {
+		t.Fatalf("unexpected PDU length: %d", handler.PDULength)
+	}
+}
+
+func TestTCPTransporterReconnectNoWriteRetry(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var connections, writes int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&connections, 1)
+			go func(conn net.Conn) {
+				defer conn.Close()
+				// answers the connection setup, the connection is lost with every write var request
+				for {
+					header := make([]byte, 4)
+					if _, err := io.ReadFull(conn, header); err != nil {
+						return
+					}
+					request := make([]byte, binary.BigEndian.Uint16(header[2:]))
+					copy(request, header)
+					if _, err := io.ReadFull(conn, request[4:]); err != nil {
+						return
+					}
+					var response []byte
+					switch {
+					case request[5] == 0xE0: // connection request
+						response = append([]byte{}, request...)
+						response[5] = 0xD0
+					case request[17] == 0xF0: // setup communication
+						response = []byte{3, 0, 0, 27, 2, 240, 128, 50, 3, 0, 0, request[11], request[12], 0, 8, 0, 0, 0, 0, 240, 0, 0, 1, 0, 1, 0, 240}
+					case request[17] == 0x05: // write var
+						atomic.AddInt32(&writes, 1)
+						return
+					default:
+						return
+					}
+					if _, err := conn.Write(response); err != nil {
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	handler := NewTCPClientHandler(ln.Addr().String(), 0, 2)
+	handler.Timeout = time.Second
+	handler.Reconnect = &ReconnectPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond}
+	if err := handler.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	client := NewClient(handler)
+	// the PLC may have executed the write, it is not sent again on a new connection
+	if err := client.AGWriteDB(1, 0, 2, []byte{1, 2}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if n := atomic.LoadInt32(&writes); n != 1 {
+		t.Fatalf("the PLC received %d writes", n)
+	}
+	if n := atomic.LoadInt32(&connections); n != 1 {
+		t.Fatalf("unexpected connection count: %d", n)
+	}
+}
+
+func TestTCPTransporterPipeliningBrokenConnection(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var connections int32
+	go servePLC(ln, 1, 3, &connections)
+
+	handler := NewTCPClientHandler(ln.Addr().String(), 0, 2)
+	handler.Timeout = time.Second
+	handler.Pipelining = true
+	handler.Reconnect = &ReconnectPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond}
+	if err := handler.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	client := NewClient(handler)
+	buffer := make([]byte, 4)
+	if err := client.AGReadDB(1, 0, len(buffer), buffer); err != nil {
+		t.Fatal(err)
+	}
+	// the PLC closes the connection after the read, the pipeline fails before the next request
+	time.Sleep(50 * time.Millisecond)
+	// a write is not repeated, it must go over a new connection instead of the broken pipeline
+	if err := client.AGWriteDB(1, 0, len(buffer), buffer); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&connections); n != 2 {
+		t.Fatalf("unexpected connection count: %d", n)
+	}
+}
+
+// servePipelinedPLC accepts 3 parallel jobs, it waits for 3 read var requests and answers them in
+// reverse order, the data bytes are the start address of the request
+func servePipelinedPLC(ln net.Listener) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	var jobs [][]byte
+	for {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		request := make([]byte, binary.BigEndian.Uint16(header[2:]))
+		copy(request, header)
+		if _, err := io.ReadFull(conn, request[4:]); err != nil {
+			return
+		}
+		var responses [][]byte
+		switch {
+		case request[5] == 0xE0: // connection request
+			response := append([]byte{}, request...)
+			response[5] = 0xD0
+			responses = append(responses, response)
+		case request[17] == 0xF0: // setup communication
+			responses = append(responses, []byte{3, 0, 0, 27, 2, 240, 128, 50, 3, 0, 0, request[11], request[12], 0, 8, 0, 0, 0, 0, 240, 0, 0, 3, 0, 3, 0, 240})
+		case request[17] == 0x04: // read var
+			if jobs = append(jobs, request); len(jobs) < 3 {
+				continue
+			}
+			for i := len(jobs) - 1; i >= 0; i-- {
+				job := jobs[i]
+				amount := int(binary.BigEndian.Uint16(job[23:]))
+				response := make([]byte, 25+amount)
+				copy(response, []byte{3, 0, 0, 0, 2, 240, 128, 50, 3, 0, 0, job[11], job[12], 0, 2, 0, 0, 0, 0, 4, 1, 0xFF, tsResByte})
+				binary.BigEndian.PutUint16(response[2:], uint16(len(response)))
+				binary.BigEndian.PutUint16(response[23:], uint16(amount<<3))
+				for j := 0; j < amount; j++ {
+					response[25+j] = byte((int(job[29])<<8 + int(job[30])) >> 3)
+				}
+				responses = append(responses, response)
+			}
+			jobs = nil
+		default:
+			return
+		}
+		for _, response := range responses {
+			if _, err := conn.Write(response); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestTCPTransporterPipelining(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go servePipelinedPLC(ln)
+
+	handler := NewTCPClientHandler(ln.Addr().String(), 0, 2)
+	handler.Timeout = time.Second
+	handler.Pipelining = true
+	if err := handler.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	if handler.MaxParallelJobs() != 3 {
+		t.Fatalf("unexpected parallel jobs %d", handler.MaxParallelJobs())
+	}
+	client := NewClient(handler)
+	errs := make(chan error, 3)
+	for _, start := range []int{10, 20, 30} {
+		go func(start int) {
+			buffer := make([]byte, 4)
+			err := client.AGReadDB(1, start, len(buffer), buffer)
+			if err == nil && !bytes.Equal(buffer, []byte{byte(start), byte(start), byte(start), byte(start)}) {
+				err = fmt.Errorf("start %d: unexpected data % x", start, buffer)
+			}
+			errs <- err
+		}(start)
+	}
+	// the PLC answers only after all 3 requests arrived, sequential requests would time out
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestTCPTransporterPipeliningLostReplies(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// accepts 3 parallel jobs and swallows every read var request
+		for {
+			header := make([]byte, 4)
+			if _, err := io.ReadFull(conn, header); err != nil {
+				return
+			}
+			request := make([]byte, binary.BigEndian.Uint16(header[2:]))
+			copy(request, header)
+			if _, err := io.ReadFull(conn, request[4:]); err != nil {
+				return
+			}
+			var response []byte
+			switch {
+			case request[5] == 0xE0: // connection request
+				response = append([]byte{}, request...)
+				response[5] = 0xD0
+			case request[17] == 0xF0: // setup communication
+				response = []byte{3, 0, 0, 27, 2, 240, 128, 50, 3, 0, 0, request[11], request[12], 0, 8, 0, 0, 0, 0, 240, 0, 0, 3, 0, 3, 0, 240}
+			default:
+				continue
+			}
+			if _, err := conn.Write(response); err != nil {
+				return
+			}
+		}
+	}()
+
+	handler := NewTCPClientHandler(ln.Addr().String(), 0, 2)
+	handler.Timeout = 200 * time.Millisecond
+	handler.Pipelining = true
+	if err := handler.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	client := NewClient(handler).(ClientContext)
+	buffer := make([]byte, 4)
+	// cancelled requests leave their job slots to the replies that never come
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		err := client.AGReadDBContext(ctx, 1, 0, len(buffer), buffer)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	// a later request must not wait for the slots forever
+	done := make(chan error, 1)
+	go func() {
+		done <- client.AGReadDB(1, 0, len(buffer), buffer)
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("the request is blocked by the abandoned jobs")
+	}
+}
+
+func TestTCPTransporterStaleReply(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// answers every request with its first data byte, the first one too late
+		for i := 0; ; i++ {
+			request := make([]byte, 31)
+			if _, err := io.ReadFull(conn, request); err != nil {
+				return
+			}
+			if i == 0 {
+				time.Sleep(300 * time.Millisecond)
+			}
+			response := []byte{3, 0, 0, 26, 2, 240, 128, 50, 3, 0, 0, request[11], request[12], 0, 2, 0, 5, 0, 0, 4, 1, 0xFF, tsResByte, 0, 8, request[30]}
+			if _, err := conn.Write(response); err != nil {
+				return
+			}
+		}
+	}()
+	handler := NewTCPClientHandler(ln.Addr().String(), 0, 2)
+	handler.Timeout = 200 * time.Millisecond
+	if err := handler.tcpConnect(); err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	client := NewClient(handler)
+	buffer := make([]byte, 1)
+	if err := client.AGReadDB(1, 1, 1, buffer); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	// the late reply to the first request must not be taken for the reply to the second one
+	if err := client.AGReadDB(1, 2, 1, buffer); err != nil {
+		t.Fatal(err)
+	}
+	if buffer[0] != 2<<3 {
+		t.Fatalf("unexpected data %d", buffer[0])
+	}
+}
+
+// ackTransporter answers every job with an ack without data carrying errCode and the PDU reference ref,
+// the one of the request if ref is 0
+type ackTransporter struct {
+	errCode uint16
+	ref     uint16
+}
+
+func (t *ackTransporter) Send(request []byte) ([]byte, error) {
+	reply := s7Reply(request, 2, t.errCode, nil, nil)
+	if t.ref != 0 {
+		binary.BigEndian.PutUint16(reply[11:], t.ref)
+	}
+	return reply, nil
+}
+
+func TestClientAckError(t *testing.T) {
+	client := NewClient2(&tcpPackager{}, &ackTransporter{errCode: code7NeedPassword})
+	buffer := make([]byte, 2)
+	if err := client.AGReadDB(1, 0, 2, buffer); err == nil || err.Error() != ErrorText(errCliNeedPassword) {
+		t.Errorf("unexpected error %v", err)
+	}
+	client = NewClient2(&tcpPackager{}, &ackTransporter{errCode: code7NeedPassword, ref: 0xABCD})
+	if err := client.AGReadDB(1, 0, 2, buffer); err == nil || !strings.Contains(err.Error(), "PDU reference 43981") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestTCPPackagerVerify(t *testing.T) {
+	read := []byte{3, 0, 0, 31, 2, 240, 128, 50, 1, 0, 0, 0, 7, 0, 14, 0, 0, 4, 1, 18, 10, 16, 2, 0, 1, 0, 1, 132, 0, 0, 8}
+	readReply := []byte{3, 0, 0, 26, 2, 240, 128, 50, 3, 0, 0, 0, 7, 0, 2, 0, 5, 0, 0, 4, 1, 0xFF, 4, 0, 8, 1}
+	szl := []byte{3, 0, 0, 33, 2, 240, 128, 50, 7, 0, 0, 0, 7, 0, 8, 0, 8, 0, 1, 18, 4, 17, 68, 1, 0, 255, 9, 0, 4, 0, 17, 0, 0}
+	szlReply := []byte{3, 0, 0, 33, 2, 240, 128, 50, 7, 0, 0, 0, 7, 0, 12, 0, 4, 0, 1, 18, 8, 18, 132, 1, 1, 0, 0, 0, 0, 255, 9, 0, 0}
+	modified := func(telegram []byte, index int, value byte) []byte {
+		telegram = append([]byte{}, telegram...)
+		telegram[index] = value
+		return telegram
+	}
+	input := []struct {
+		name     string
+		request  []byte
+		response []byte
+		valid    bool
+	}{
+		{"read", read, readReply, true},
+		{"userdata", szl, szlReply, true},
+		{"protocol id", read, modified(readReply, 7, 0x72), false},
+		{"ROSCTR", read, modified(readReply, 8, 7), false},
+		{"PDU reference", read, modified(readReply, 12, 8), false},
+		{"function", read, modified(readReply, 19, 5), false},
+		{"item count", read, modified(readReply, 20, 2), false},
+		{"userdata group", szl, modified(szlReply, 22, 0x83), false},
+		{"userdata subfunction", szl, modified(szlReply, 23, 2), false},
+		{"header error", read, []byte{3, 0, 0, 19, 2, 240, 128, 50, 3, 0, 0, 0, 7, 0, 0, 0, 0, 0x81, 4}, true},
+		{"ack error", read, []byte{3, 0, 0, 19, 2, 240, 128, 50, 2, 0, 0, 0, 7, 0, 0, 0, 0, 0x81, 4}, false},
+		{"ack without error", read, []byte{3, 0, 0, 19, 2, 240, 128, 50, 2, 0, 0, 0, 7, 0, 0, 0, 0, 0, 0}, false},
+	}
+	var packager tcpPackager
+	for _, i := range input {
+		if err := packager.Verify(i.request, i.response); (err == nil) != i.valid {
+			t.Errorf("%s: unexpected result %v", i.name, err)
+		}
+	}
+}
@@ -204,3 +204,66 @@ var s7PGBlockDeleteTelegram = []byte{
 	0, 0, 0, 0, 0, //block number, should replace
 	66, 5, // should add byte
 	95, 68, 69, 76, 69} //bytes of string "_DELE"
+
+// S7 Start Upload request
+var s7StartUploadTelegram = []byte{
+	3, 0, 0, 35, 2, 240, 128, 50, 1, 0, 0, 0, 0, 0, 18, 0, 0,
+	29,      // Function 0x1D Start Upload
+	0, 0, 0, // Function status, unknown
+	0, 0, 0, 0, // Upload ID (idx=21)
+	9, 95, 48, // File name length, '_', '0'
+	65,                 // Block Type (idx=28)
+	48, 48, 48, 48, 48, // ASCII Block Number (idx=29)
+	65} // File system 'A'
+
+// S7 Upload request, the function is changed to End Upload for the last one
+var s7UploadTelegram = []byte{
+	3, 0, 0, 25, 2, 240, 128, 50, 1, 0, 0, 0, 0, 0, 8, 0, 0,
+	30,      // Function 0x1E Upload, 0x1F End Upload (idx=17)
+	0, 0, 0, // Function status, unknown
+	0, 0, 0, 0} // Upload ID (idx=21)
+
+// S7 Request Download request
+var s7RequestDownloadTelegram = []byte{
+	3, 0, 0, 49, 2, 240, 128, 50, 1, 0, 0, 0, 0, 0, 32, 0, 0,
+	26,      // Function 0x1A Request Download
+	0, 1, 0, // Function status, unknown
+	0, 0, 0, 0, // Download ID
+	9, 95, 48, // File name length, '_', '0'
+	65,                 // Block Type (idx=28)
+	48, 48, 48, 48, 48, // ASCII Block Number (idx=29)
+	80,     // Destination file system 'P' (passive)
+	13, 49, // Length part 2, '1'
+	48, 48, 48, 48, 48, 48, // ASCII Load memory length (idx=37)
+	48, 48, 48, 48, 48, 48} // ASCII MC7 code length (idx=43)
+
+// S7 PI service Insert, activates a downloaded block
+var s7PGBlockInsertTelegram = []byte{
+	3, 0, 0, 43, 2, 240, 128, 50, 1, 0, 0, 0, 0, 0, 26, 0, 0,
+	40, 0, 0, 0, 0, 0, 0, 253, 0, 10, 1, 0, 48,
+	65,                 // Block Type (idx=30)
+	48, 48, 48, 48, 48, // ASCII Block Number (idx=31)
+	80, 5, // File system 'P', length of the PI service name
+	95, 73, 78, 83, 69} // "_INSE"
+
+// S7 PI service Compress, compresses the work memory
+var s7CompressTelegram = []byte{
+	3, 0, 0, 33, 2, 240, 128, 50, 1, 0, 0, 0, 0, 0, 16, 0, 0,
+	40, 0, 0, 0, 0, 0, 0, 253, 0, 0, // no parameter block
+	5, 95, 71, 65, 82, 66} // "_GARB"
+
+// S7 PI service Copy RAM to ROM, copies the work memory into the load memory
+var s7CopyRamToRomTelegram = []byte{
+	3, 0, 0, 35, 2, 240, 128, 50, 1, 0, 0, 0, 0, 0, 18, 0, 0,
+	40, 0, 0, 0, 0, 0, 0, 253, 0, 2, 69, 80, // parameter block "EP"
+	5, 95, 77, 79, 68, 85} // "_MODU"
+
+const (
+	pduRequestDownload = 0x1A // Request download of a block
+	pduDownloadBlock   = 0x1B // Download a segment of a block (job of the PLC)
+	pduDownloadEnded   = 0x1C // End of the download (job of the PLC)
+	pduStartUpload     = 0x1D // Start upload of a block
+	pduUpload          = 0x1E // Upload a segment of a block
+	pduEndUpload       = 0x1F // End upload of a block
+	pduControl         = 0x28 // PI service
+)
@@ -0,0 +1,54 @@
+package test
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"testing"
+	"time"
+
+	"github.com/robinson/gos7"
+)
+
+// newTestServer emulates the PLC ClientTestAll expects
+func newTestServer(t *testing.T) *gos7.Server {
+	server := gos7.NewServer()
+	server.CPUInfo.SerialNumber = "0118701484"
+	for _, db := range []int{2710, 2810, 2910} {
+		if err := server.RegisterArea(gos7.S7AreaDB, db, make([]byte, 32)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blocks := []gos7.S7BlockInfo{{BlkType: gos7.S7BlockDB, BlkNumber: 2710, BlkLang: 5, CodeDate: "22.01.2018", IntfDate: "22.01.2018"}}
+	for i := 1; i <= 110; i++ {
+		blocks = append(blocks, gos7.S7BlockInfo{BlkType: gos7.S7BlockDB, BlkNumber: i, BlkLang: 5})
+	}
+	for _, ob := range []int{1, 10, 20, 32, 35, 40, 80, 82, 86, 100} {
+		blocks = append(blocks, gos7.S7BlockInfo{BlkType: gos7.S7BlockOB, BlkNumber: ob, BlkLang: 1})
+	}
+	for i := 1; i <= 81; i++ {
+		blocks = append(blocks, gos7.S7BlockInfo{BlkType: gos7.S7BlockFB, BlkNumber: i, BlkLang: 1})
+	}
+	for _, block := range blocks {
+		if err := server.RegisterBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := server.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestServerClient(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+	handler := gos7.NewTCPClientHandler(server.Addr().String(), rack, slot)
+	handler.Timeout = 5 * time.Second
+	if err := handler.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	client := gos7.NewClient(handler)
+	ClientTestAll(t, client)
+}
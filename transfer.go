@@ -0,0 +1,213 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+const (
+	sizeBlockHeader = 36 // header of a block in load memory
+	sizeBlockFooter = 36 // footer of a block in load memory, author, family, name, version and checksum
+	// maxBlockLength is the largest load memory length the 6 ASCII digits of a download request take
+	maxBlockLength = 999999
+	// sizeDownloadReply is the size of a reply to a download block job without the segment
+	sizeDownloadReply = 18
+)
+
+// UploadBlock uploads a block from the load memory of the PLC with the start upload / upload / end upload
+// sequence, the block comes in as many segments as it needs. blockType is S7BlockOB, S7BlockDB, ... or one of
+// the block type bytes of GetAgBlockInfo. The block is returned as stored: header, MC7 code and footer.
+func (mb *client) UploadBlock(blockType int, number int) (data []byte, err error) {
+	asciiType, err := blockTypeASCII(blockType)
+	if err != nil {
+		return nil, err
+	}
+	requestData := make([]byte, len(s7StartUploadTelegram))
+	copy(requestData, s7StartUploadTelegram)
+	requestData[28] = asciiType
+	if err = putBlockNumber(requestData[29:], number); err != nil {
+		return nil, err
+	}
+	request := NewProtocolDataUnit(requestData)
+	response, err := mb.send(&request)
+	if err != nil {
+		return nil, err
+	}
+	if err = transferError(response.Data, pduStartUpload, errCliUploadSequenceFailed); err != nil {
+		return nil, err
+	}
+	if len(response.Data) < 28 || len(response.Data) < 28+int(response.Data[27]) {
+		return nil, fmt.Errorf(ErrorText(errCliUploadSequenceFailed))
+	}
+	uploadID := response.Data[23:27]
+	// the block length comes as ASCII digits, it only sizes the buffer
+	length, _ := strconv.Atoi(string(response.Data[28 : 28+int(response.Data[27])]))
+	data = make([]byte, 0, length)
+	// the upload is always ended once started, so that the PLC releases it
+	defer func() {
+		if _, endErr := mb.upload(pduEndUpload, uploadID); err == nil && endErr != nil {
+			data, err = nil, endErr
+		}
+	}()
+	for more := true; more; {
+		var segment []byte
+		if segment, more, err = mb.uploadSegment(uploadID); err != nil {
+			return nil, err
+		}
+		data = append(data, segment...)
+	}
+	return data, nil
+}
+
+// uploadSegment requests the next segment of an upload, more tells whether further segments follow
+func (mb *client) uploadSegment(uploadID []byte) (segment []byte, more bool, err error) {
+	var response *ProtocolDataUnit
+	if response, err = mb.upload(pduUpload, uploadID); err != nil {
+		return
+	}
+	// data: length, 0x00 0xFB, segment
+	offset := 19 + int(binary.BigEndian.Uint16(response.Data[13:]))
+	if len(response.Data) < 21 || len(response.Data) < offset+4 {
+		return nil, false, fmt.Errorf(ErrorText(errCliUploadSequenceFailed))
+	}
+	size := int(binary.BigEndian.Uint16(response.Data[offset:]))
+	if len(response.Data) < offset+4+size {
+		return nil, false, fmt.Errorf(ErrorText(errCliUploadSequenceFailed))
+	}
+	return response.Data[offset+4 : offset+4+size], response.Data[20] == 1, nil
+}
+
+// upload sends an upload or end upload request
+func (mb *client) upload(function byte, uploadID []byte) (*ProtocolDataUnit, error) {
+	requestData := make([]byte, len(s7UploadTelegram))
+	copy(requestData, s7UploadTelegram)
+	requestData[17] = function
+	copy(requestData[21:], uploadID)
+	request := NewProtocolDataUnit(requestData)
+	response, err := mb.send(&request)
+	if err != nil {
+		return nil, err
+	}
+	if err = transferError(response.Data, function, errCliUploadSequenceFailed); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// DownloadBlock downloads a block as returned by UploadBlock (header, MC7 code and footer) into the PLC and
+// inserts it, an existing block of the same type and number is replaced. The header is validated before anything
+// is sent. The PLC drives the download with jobs of its own, so the transporter has to implement Exchanger.
+func (mb *client) DownloadBlock(data []byte) (err error) {
+	blockType, number, mc7Size, err := blockHeader(data)
+	if err != nil {
+		return
+	}
+	exchanger, ok := mb.transporter.(Exchanger)
+	if !ok {
+		return fmt.Errorf(ErrorText(errCliFunctionNotImplemented))
+	}
+	asciiType, _ := blockTypeASCII(blockType)
+	requestData := make([]byte, len(s7RequestDownloadTelegram))
+	copy(requestData, s7RequestDownloadTelegram)
+	requestData[28] = asciiType
+	putBlockNumber(requestData[29:], number)
+	copy(requestData[37:43], fmt.Sprintf("%06d", len(data)))
+	copy(requestData[43:49], fmt.Sprintf("%06d", mc7Size))
+	request := NewProtocolDataUnit(requestData)
+	response, err := mb.send(&request)
+	if err != nil {
+		return
+	}
+	if err = transferError(response.Data, pduRequestDownload, errCliDownloadSequenceFailed); err != nil {
+		return
+	}
+	// the PLC requests the segments until it has the whole block, then it ends the download
+	ctx := mb.context()
+	segment := mb.pduLength() - sizeDownloadReply
+	for offset := 0; ; {
+		var job []byte
+		if job, err = exchanger.ReceiveJob(ctx); err != nil {
+			return
+		}
+		if len(job) < 18 {
+			return fmt.Errorf(ErrorText(errCliDownloadSequenceFailed))
+		}
+		switch job[17] {
+		case pduDownloadBlock:
+			end, status := offset+segment, byte(1)
+			if end >= len(data) {
+				end, status = len(data), 0
+			}
+			payload := make([]byte, 4, 4+end-offset)
+			binary.BigEndian.PutUint16(payload, uint16(end-offset))
+			payload[3] = 0xFB
+			payload = append(payload, data[offset:end]...)
+			offset = end
+			if err = exchanger.Reply(ctx, s7Reply(job, 3, 0, []byte{pduDownloadBlock, status}, payload)); err != nil {
+				return
+			}
+		case pduDownloadEnded:
+			if err = exchanger.Reply(ctx, s7Reply(job, 3, 0, []byte{pduDownloadEnded}, nil)); err != nil {
+				return
+			}
+			if offset < len(data) {
+				return fmt.Errorf(ErrorText(errCliDownloadSequenceFailed))
+			}
+			return mb.insertBlock(asciiType, number)
+		default:
+			return fmt.Errorf(ErrorText(errCliDownloadSequenceFailed))
+		}
+	}
+}
+
+// insertBlock activates a downloaded block with the PI service _INSE
+func (mb *client) insertBlock(asciiType byte, number int) error {
+	requestData := make([]byte, len(s7PGBlockInsertTelegram))
+	copy(requestData, s7PGBlockInsertTelegram)
+	requestData[30] = asciiType
+	putBlockNumber(requestData[31:], number)
+	request := NewProtocolDataUnit(requestData)
+	response, err := mb.send(&request)
+	if err != nil {
+		return err
+	}
+	return piServiceError(response.Data, errCliInsertRefused)
+}
+
+// blockHeader validates the header of a block as stored in load memory and returns its sub block type,
+// number and MC7 code size
+func blockHeader(data []byte) (blockType int, number int, mc7Size int, err error) {
+	if len(data) < sizeBlockHeader+sizeBlockFooter || data[0] != 0x70 || data[1] != 0x70 ||
+		int(binary.BigEndian.Uint32(data[8:])) != len(data) || len(data) > maxBlockLength {
+		return 0, 0, 0, fmt.Errorf(ErrorText(errCliInvalidBlockSize))
+	}
+	blockType = int(data[5])
+	if blockType < S7BlockOB || blockType > S7BlockSFB || blockType == 0x09 {
+		return 0, 0, 0, fmt.Errorf(ErrorText(errCliInvalidBlockType))
+	}
+	number = int(binary.BigEndian.Uint16(data[6:]))
+	mc7Size = int(binary.BigEndian.Uint16(data[34:]))
+	if mc7Size > len(data)-sizeBlockHeader-sizeBlockFooter {
+		return 0, 0, 0, fmt.Errorf(ErrorText(errCliInvalidBlockSize))
+	}
+	return
+}
+
+// transferError returns the error of a block transfer reply: the error code of the header, or sequenceError
+// for a reply of another function
+func transferError(response []byte, function byte, sequenceError int) error {
+	if len(response) < 19 {
+		return fmt.Errorf(ErrorText(errIsoInvalidPDU))
+	}
+	if code := binary.BigEndian.Uint16(response[17:]); code != 0 {
+		return fmt.Errorf(ErrorText(CPUError(uint(code))))
+	}
+	if len(response) < 20 || response[19] != function {
+		return fmt.Errorf(ErrorText(sequenceError))
+	}
+	return nil
+}
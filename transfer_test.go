@@ -0,0 +1,241 @@
+package gos7
+
+// Copyright 2018 Trung Hieu Le. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// uploadTransporter uploads block, segment bytes per upload reply, and records the functions of the requests
+type uploadTransporter struct {
+	block     []byte
+	segment   int
+	missing   bool
+	offset    int
+	functions []byte
+}
+
+func (t *uploadTransporter) reply(request []byte, errCode uint16, param []byte, data []byte) []byte {
+	response := make([]byte, 19, 19+len(param)+len(data))
+	copy(response, tpktISOTelegram)
+	response[7] = 0x32
+	response[8] = 3
+	copy(response[11:13], request[11:13])
+	binary.BigEndian.PutUint16(response[13:], uint16(len(param)))
+	binary.BigEndian.PutUint16(response[15:], uint16(len(data)))
+	binary.BigEndian.PutUint16(response[17:], errCode)
+	response = append(append(response, param...), data...)
+	binary.BigEndian.PutUint16(response[2:], uint16(len(response)))
+	return response
+}
+
+func (t *uploadTransporter) Send(request []byte) ([]byte, error) {
+	function := request[17]
+	t.functions = append(t.functions, function)
+	switch function {
+	case pduStartUpload:
+		if t.missing || string(request[25:35]) != "\x09_0A00042A" {
+			return t.reply(request, code7ResItemNotAvailable1, nil, nil), nil
+		}
+		length := strconv.Itoa(len(t.block))
+		param := append([]byte{pduStartUpload, 0, 1, 0, 0, 0, 0, 7, byte(len(length))}, length...)
+		return t.reply(request, 0, param, nil), nil
+	case pduUpload:
+		if binary.BigEndian.Uint32(request[21:]) != 7 {
+			return t.reply(request, code7FunNotAvailable, nil, nil), nil
+		}
+		end := t.offset + t.segment
+		status := byte(1)
+		if end >= len(t.block) {
+			end, status = len(t.block), 0
+		}
+		data := []byte{0, 0, 0, 0xFB}
+		binary.BigEndian.PutUint16(data, uint16(end-t.offset))
+		data = append(data, t.block[t.offset:end]...)
+		t.offset = end
+		return t.reply(request, 0, []byte{pduUpload, status}, data), nil
+	}
+	return t.reply(request, 0, []byte{function}, nil), nil
+}
+
+func TestUploadBlock(t *testing.T) {
+	block := make([]byte, 500)
+	for i := range block {
+		block[i] = byte(i)
+	}
+	transporter := &uploadTransporter{block: block, segment: 200}
+	client := NewClient2(&tcpPackager{}, transporter)
+	data, err := client.UploadBlock(S7BlockDB, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, block) {
+		t.Fatalf("unexpected block: % x", data)
+	}
+	if !bytes.Equal(transporter.functions, []byte{pduStartUpload, pduUpload, pduUpload, pduUpload, pduEndUpload}) {
+		t.Fatalf("unexpected requests: % x", transporter.functions)
+	}
+	// the block type byte of GetAgBlockInfo selects the same block
+	transporter = &uploadTransporter{block: block, segment: 1000}
+	if data, err = NewClient2(&tcpPackager{}, transporter).UploadBlock(blockDB, 42); err != nil || !bytes.Equal(data, block) {
+		t.Fatalf("unexpected block % x: %v", data, err)
+	}
+}
+
+func TestUploadBlockErrors(t *testing.T) {
+	transporter := &uploadTransporter{missing: true}
+	client := NewClient2(&tcpPackager{}, transporter)
+	if _, err := client.UploadBlock(S7BlockDB, 42); err == nil || err.Error() != ErrorText(errCliItemNotAvailable) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// no end upload without a started upload
+	if !bytes.Equal(transporter.functions, []byte{pduStartUpload}) {
+		t.Fatalf("unexpected requests: % x", transporter.functions)
+	}
+	if _, err := client.UploadBlock(0x09, 1); err == nil {
+		t.Fatal("expected an invalid block type")
+	}
+	if _, err := client.UploadBlock(S7BlockDB, 65536); err == nil {
+		t.Fatal("expected an invalid block number")
+	}
+}
+
+// testBlock returns a data block as stored in load memory with mc7Size bytes of code
+func testBlock(number int, mc7Size int) []byte {
+	block := make([]byte, sizeBlockHeader+mc7Size+sizeBlockFooter)
+	block[0], block[1] = 0x70, 0x70
+	block[5] = S7BlockDB
+	binary.BigEndian.PutUint16(block[6:], uint16(number))
+	binary.BigEndian.PutUint32(block[8:], uint32(len(block)))
+	binary.BigEndian.PutUint16(block[34:], uint16(mc7Size))
+	for i := sizeBlockHeader; i < len(block); i++ {
+		block[i] = byte(i)
+	}
+	return block
+}
+
+// readTelegram reads the next ISO on TCP telegram of conn
+func readTelegram(conn net.Conn) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	telegram := make([]byte, binary.BigEndian.Uint16(header[2:]))
+	copy(telegram, header)
+	_, err := io.ReadFull(conn, telegram[4:])
+	return telegram, err
+}
+
+// serveDownloadPLC accepts a download like a PLC with the given parallel jobs, the downloaded block is sent
+// to blocks once it is inserted
+func serveDownloadPLC(ln net.Listener, jobs byte, blocks chan<- []byte) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	var block, filename []byte
+	plcJob := func(function byte) []byte {
+		param := append([]byte{function, 0, 1, 0, 0, 0, 0, 7}, filename...)
+		job := s7Reply([]byte{11: 0x12, 12: 0x34}, 1, 0, param, nil)
+		_, err := conn.Write(job)
+		if err != nil {
+			return nil
+		}
+		return job
+	}
+	for {
+		request, err := readTelegram(conn)
+		if err != nil {
+			return
+		}
+		var response []byte
+		switch {
+		case request[5] == 0xE0: // connection request
+			response = append([]byte{}, request...)
+			response[5] = 0xD0
+		case request[8] == 1 && request[17] == 0xF0: // setup communication
+			response = s7Reply(request, 3, 0, []byte{0xF0, 0, 0, jobs, 0, jobs, 0, 240}, nil)
+		case request[8] == 1 && request[17] == pduRequestDownload:
+			filename = request[25:35]
+			response = s7Reply(request, 3, 0, []byte{pduRequestDownload}, nil)
+		case request[8] == 3 && request[19] == pduDownloadBlock:
+			data := request[21:]
+			block = append(block, data[4:4+binary.BigEndian.Uint16(data)]...)
+			if request[20] == 1 {
+				plcJob(pduDownloadBlock)
+			} else {
+				plcJob(pduDownloadEnded)
+			}
+			continue
+		case request[8] == 3 && request[19] == pduDownloadEnded:
+			continue
+		case request[8] == 1 && request[17] == pduControl && string(request[38:]) == "_INSE" &&
+			bytes.Equal(request[30:36], filename[3:9]):
+			blocks <- block
+			response = s7Reply(request, 3, 0, []byte{pduControl}, nil)
+		default:
+			return
+		}
+		if _, err = conn.Write(response); err != nil {
+			return
+		}
+		if request[8] == 1 && request[17] == pduRequestDownload {
+			plcJob(pduDownloadBlock)
+		}
+	}
+}
+
+func TestDownloadBlock(t *testing.T) {
+	for _, pipelining := range []bool{false, true} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		blocks := make(chan []byte, 1)
+		go serveDownloadPLC(ln, 3, blocks)
+		handler := NewTCPClientHandler(ln.Addr().String(), 0, 2)
+		handler.Timeout = time.Second
+		handler.Pipelining = pipelining
+		if err := handler.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		defer handler.Close()
+		block := testBlock(42, 500)
+		if err := NewClient(handler).DownloadBlock(block); err != nil {
+			t.Fatalf("pipelining %v: %v", pipelining, err)
+		}
+		if downloaded := <-blocks; !bytes.Equal(downloaded, block) {
+			t.Fatalf("pipelining %v: unexpected block % x", pipelining, downloaded)
+		}
+	}
+}
+
+func TestDownloadBlockHeader(t *testing.T) {
+	client := NewClient(&fakeHandler{conn: serverConn{server: NewServer()}})
+	block := testBlock(1, 10)
+	if err := client.DownloadBlock(block[:50]); err == nil || err.Error() != ErrorText(errCliInvalidBlockSize) {
+		t.Fatalf("unexpected error for a short block: %v", err)
+	}
+	block[5] = 0x09
+	if err := client.DownloadBlock(block); err == nil || err.Error() != ErrorText(errCliInvalidBlockType) {
+		t.Fatalf("unexpected error for a wrong type: %v", err)
+	}
+	block[5] = S7BlockDB
+	binary.BigEndian.PutUint16(block[34:], 100)
+	if err := client.DownloadBlock(block); err == nil || err.Error() != ErrorText(errCliInvalidBlockSize) {
+		t.Fatalf("unexpected error for a wrong MC7 size: %v", err)
+	}
+	// a valid block needs a transporter which lets the PLC send its jobs
+	if err := client.DownloadBlock(testBlock(1, 10)); err == nil || err.Error() != ErrorText(errCliFunctionNotImplemented) {
+		t.Fatalf("unexpected error without Exchanger: %v", err)
+	}
+}